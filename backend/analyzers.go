@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gocv.io/x/gocv"
+)
+
+// Names of the analyzers ANALYZER_CHAIN can list.
+const (
+	analyzerOpenAI = "openai"
+	analyzerAzure  = "azure"
+	analyzerLocal  = "local"
+)
+
+// Analyzer rates a single frame. img is the decoded frame and dataURL the
+// same frame encoded for the chat APIs.
+type Analyzer interface {
+	Name() string
+	Analyze(ctx context.Context, img gocv.Mat, dataURL, sceneContext string) (RatingData, error)
+}
+
+// analyzerProviders builds each analyzer by name, or reports the config it
+// is missing. A new backend only needs an Analyzer and an entry here to be
+// listed in ANALYZER_CHAIN or a request's analyzers.
+var analyzerProviders = map[string]func() (Analyzer, error){
+	analyzerOpenAI: func() (Analyzer, error) {
+		return openAIAnalyzer{}, nil
+	},
+	analyzerAzure: func() (Analyzer, error) {
+		if config.AzureOpenAIEndpoint == "" || config.AzureOpenAIKey == "" || config.AzureOpenAIDeployment == "" {
+			return nil, fmt.Errorf("azure requires AZURE_OPENAI_ENDPOINT, AZURE_OPENAI_API_KEY and AZURE_OPENAI_DEPLOYMENT")
+		}
+		return azureAnalyzer{}, nil
+	},
+	analyzerLocal: func() (Analyzer, error) {
+		if localClassifier == nil {
+			return nil, fmt.Errorf("local requires LOCAL_MODEL or PREFILTER_MODEL")
+		}
+		return localAnalyzer{}, nil
+	},
+}
+
+// analyzers is the chain built from ANALYZER_CHAIN at startup.
+var analyzers []Analyzer
+
+// newAnalyzerChain builds the analyzers named in names, checking that each
+// one is configured.
+func newAnalyzerChain(names []string) ([]Analyzer, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no analyzers listed")
+	}
+	chain := make([]Analyzer, 0, len(names))
+	for _, name := range names {
+		provider, ok := analyzerProviders[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown analyzer %q", name)
+		}
+		analyzer, err := provider()
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, analyzer)
+	}
+	return chain, nil
+}
+
+// availableAnalyzers returns the names of the configured analyzers, which
+// requests may list in their analyzers.
+func availableAnalyzers() []string {
+	var names []string
+	for name, provider := range analyzerProviders {
+		if _, err := provider(); err == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+type analyzerChainKey struct{}
+
+// withAnalyzerChain returns ctx carrying the chain from the request's
+// "analyzers" form field, a comma-separated list like ANALYZER_CHAIN. Without
+// it, frames are rated by the ANALYZER_CHAIN chain.
+func withAnalyzerChain(ctx context.Context, c *gin.Context) (context.Context, error) {
+	raw := c.PostForm("analyzers")
+	if raw == "" {
+		return ctx, nil
+	}
+	var names []string
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	chain, err := newAnalyzerChain(names)
+	if err != nil {
+		return nil, fmt.Errorf("invalid analyzers: %v", err)
+	}
+	return context.WithValue(ctx, analyzerChainKey{}, chain), nil
+}
+
+// analyzeFrame rates img with each analyzer of the request's chain in turn
+// until one succeeds. If all of them fail, their errors are returned
+// together.
+func analyzeFrame(ctx context.Context, img gocv.Mat, dataURL, sceneContext string, timestamp float64) (RatingData, error) {
+	chain := analyzers
+	if requested, ok := ctx.Value(analyzerChainKey{}).([]Analyzer); ok {
+		chain = requested
+	}
+	var errs []error
+	for i, analyzer := range chain {
+		data, err := analyzer.Analyze(ctx, img, dataURL, sceneContext)
+		if err == nil {
+			slog.Debug("Frame analyzed", "timestamp", timestamp, "analyzer", analyzer.Name())
+			return data, nil
+		}
+		if ctx.Err() != nil || len(chain) == 1 {
+			return RatingData{}, err
+		}
+		if i+1 < len(chain) {
+			log.Printf("Analyzer %s failed at %.2fs, trying %s: %v", analyzer.Name(), timestamp, chain[i+1].Name(), err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", analyzer.Name(), err))
+	}
+	return RatingData{}, errors.Join(errs...)
+}
+
+// openAIAnalyzer rates frames with the OpenAI API.
+type openAIAnalyzer struct{}
+
+func (openAIAnalyzer) Name() string { return analyzerOpenAI }
+
+func (openAIAnalyzer) Analyze(ctx context.Context, img gocv.Mat, dataURL, sceneContext string) (RatingData, error) {
+	return analyzeFrameWithOpenAI(ctx, dataURL, sceneContext)
+}
+
+// azureAnalyzer rates frames with an Azure OpenAI deployment, using the
+// same prompt, HTTP client and limits as OpenAI.
+type azureAnalyzer struct{}
+
+func (azureAnalyzer) Name() string { return analyzerAzure }
+
+func (azureAnalyzer) Analyze(ctx context.Context, img gocv.Mat, dataURL, sceneContext string) (RatingData, error) {
+	endpoint := fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
+		strings.TrimSuffix(config.AzureOpenAIEndpoint, "/"),
+		url.PathEscape(config.AzureOpenAIDeployment),
+		url.QueryEscape(config.AzureOpenAIAPIVersion))
+	return analyzeFrameWithChat(ctx, "Azure OpenAI", endpoint, func(header http.Header) {
+		header.Set("api-key", config.AzureOpenAIKey)
+	}, dataURL, sceneContext)
+}
+
+// localAnalyzer rates frames offline with the LOCAL_MODEL classifier, so
+// analysis works without an API key and costs nothing per frame.
+type localAnalyzer struct{}
+
+func (localAnalyzer) Name() string { return analyzerLocal }
+
+func (localAnalyzer) Analyze(ctx context.Context, img gocv.Mat, dataURL, sceneContext string) (RatingData, error) {
+	score, err := localClassifier.score(img)
+	if err != nil {
+		return RatingData{}, fmt.Errorf("local classifier failed: %v", err)
+	}
+	return RatingData{Rating: classifierRating(score), Notes: "local classifier"}, nil
+}
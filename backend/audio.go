@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// audioEncoders maps the codec names accepted in config to ffmpeg encoders.
+var audioEncoders = map[string]string{
+	"aac":    "aac",
+	"opus":   "libopus",
+	"mp3":    "libmp3lame",
+	"vorbis": "libvorbis",
+	"flac":   "flac",
+}
+
+// containerAudioCodecs lists, per output container, the source codecs that
+// can be stream-copied and the codec used when a transcode is needed.
+var containerAudioCodecs = map[string]struct {
+	compatible []string
+	fallback   string
+}{
+	".mp4":  {compatible: []string{"aac", "mp3", "alac", "ac3", "eac3", "opus", "flac"}, fallback: "aac"},
+	".mov":  {compatible: []string{"aac", "mp3", "alac", "ac3", "pcm_s16le", "pcm_s24le"}, fallback: "aac"},
+	".webm": {compatible: []string{"opus", "vorbis"}, fallback: "opus"},
+	".mkv":  {compatible: []string{"aac", "mp3", "ac3", "eac3", "opus", "vorbis", "flac", "dts"}, fallback: "opus"},
+}
+
+// audioChannelLayouts maps supported channel layout names to channel counts.
+var audioChannelLayouts = map[string]int{
+	"mono":   1,
+	"stereo": 2,
+	"5.1":    6,
+}
+
+// audioCodecArgs picks the ffmpeg audio arguments for muxing a source stream
+// with codec sourceCodec into outputPath's container. With AUDIO_CODEC unset
+// the stream is copied when the container accepts it and transcoded to the
+// container's default otherwise. An explicit codec the container can't hold
+// is an error. A filtered stream cannot be copied, so it is always
+// transcoded, to the container's default if AUDIO_CODEC is copy.
+func audioCodecArgs(sourceCodec, outputPath string, filtered bool) ([]string, error) {
+	ext := strings.ToLower(filepath.Ext(outputPath))
+	container, ok := containerAudioCodecs[ext]
+	if !ok {
+		return nil, fmt.Errorf("unsupported output container %s", ext)
+	}
+
+	codec := config.AudioCodec
+	if codec == "" || codec == "copy" {
+		needsEncode := filtered || config.AudioBitrate != "" || config.AudioChannelLayout != ""
+		if !needsEncode && contains(container.compatible, sourceCodec) {
+			return []string{"-c:a", "copy"}, nil
+		}
+		if codec == "copy" && !filtered {
+			return nil, fmt.Errorf("audio codec %s cannot be copied into %s", sourceCodec, ext)
+		}
+		codec = container.fallback
+	}
+
+	encoder, ok := audioEncoders[codec]
+	if !ok {
+		return nil, fmt.Errorf("unsupported audio codec %s", codec)
+	}
+	if !contains(container.compatible, codec) {
+		return nil, fmt.Errorf("audio codec %s is not supported in %s output", codec, ext)
+	}
+
+	args := []string{"-c:a", encoder}
+	if config.AudioBitrate != "" {
+		args = append(args, "-b:a", config.AudioBitrate)
+	}
+	if config.AudioChannelLayout != "" {
+		channels, ok := audioChannelLayouts[config.AudioChannelLayout]
+		if !ok {
+			return nil, fmt.Errorf("unsupported audio channel layout %s", config.AudioChannelLayout)
+		}
+		args = append(args, "-ac", strconv.Itoa(channels))
+	}
+	return args, nil
+}
+
+// outputContainer is the container every output is muxed into, before any
+// HLS segmenting.
+const outputContainer = ".mp4"
+
+// checkAudioConfig reports AUDIO_CODEC and AUDIO_CHANNEL_LAYOUT settings
+// that no output could use, whatever its source, so they fail at startup
+// rather than after a whole video is rendered.
+func checkAudioConfig() error {
+	if !config.AudioMux {
+		return nil
+	}
+	_, err := audioCodecArgs("", "output"+outputContainer, true)
+	return err
+}
+
+// checkOutputAudio reports whether the audio of sourcePath can be muxed
+// into an output, before any frame of it is rendered. edited is set when
+// the audio is cut or censored, which transcodes it anyway.
+func checkOutputAudio(sourcePath string, edited bool) error {
+	if !config.AudioMux {
+		return nil
+	}
+	stream, err := sourceAudioStream(sourcePath)
+	if err != nil || stream == nil {
+		return err
+	}
+	_, err = audioCodecArgs(stream.CodecName, "output"+outputContainer, edited)
+	return err
+}
+
+// audioEdit is how an output's audio differs from its source's. The zero
+// value keeps it as is.
+type audioEdit struct {
+	// keep, if not nil, cuts the audio down to these spans, joined end to
+	// end, to match a trimmed video.
+	keep []TimeRange
+	// censored spans of the source are bleeped or muted, per censorMode.
+	censored   []ProfanitySegment
+	censorMode string
+}
+
+// filter returns the ffmpeg filter graph applying e to the input stream,
+// with its output labeled "audio", or "" if e keeps the audio as is.
+// Censoring works on source times, so it runs before the cut.
+func (e audioEdit) filter(input string) string {
+	var graph []string
+	stream := input
+	if len(e.censored) > 0 {
+		spans := make([]TimeRange, len(e.censored))
+		for i, s := range e.censored {
+			spans[i] = TimeRange{Start: s.Start, End: s.End}
+		}
+		within := timeSpansExpr(spans)
+		graph = append(graph, fmt.Sprintf("[%s]volume=0:enable='%s'[muted]", stream, within))
+		stream = "muted"
+		if e.censorMode == audioCensorBleep {
+			graph = append(graph,
+				fmt.Sprintf("sine=frequency=%d,volume=0.25,volume=0:enable='not(%s)'[tone]", bleepFrequency, within),
+				"[muted][tone]amix=inputs=2:duration=first:normalize=0[bleeped]")
+			stream = "bleeped"
+		}
+	}
+	if e.keep != nil {
+		graph = append(graph, fmt.Sprintf("[%s]aselect='%s',asetpts=N/SR/TB[kept]", stream, timeSpansExpr(e.keep)))
+		stream = "kept"
+	}
+	if len(graph) == 0 {
+		return ""
+	}
+	return strings.Join(append(graph, fmt.Sprintf("[%s]anull[audio]", stream)), ";")
+}
+
+// finalizeOutput turns the video-only file written by gocv into the final
+// output at outputPath. When includeAudio is set, the first audio stream of
+// sourcePath is muxed in, changed by audio; when COPY_METADATA is set, the
+// source's global metadata is carried over. Every output is tagged censored_by=censor-ai,
+// plus any extra key=value tags. If chaptersPath is set, the chapters in
+// that ffmpeg metadata file replace the source's. The video is converted
+// to OUTPUT_PIX_FMT if needed.
+func finalizeOutput(sourcePath, videoOnlyPath, outputPath string, includeAudio bool, audio audioEdit, chaptersPath string, tags ...string) error {
+	videoArgs, err := videoCodecArgs(videoOnlyPath)
+	if err != nil {
+		return err
+	}
+
+	var codecArgs []string
+	audioFilter := audio.filter("1:a:0")
+	if includeAudio {
+		stream, err := sourceAudioStream(sourcePath)
+		if err != nil {
+			return err
+		}
+
+		if stream == nil {
+			log.Printf("Source %s is silent, writing video only", filepath.Base(sourcePath))
+		} else if audio.keep != nil && len(audio.keep) == 0 {
+			log.Printf("Output of %s keeps no audio, writing video only", filepath.Base(sourcePath))
+		} else {
+			codecArgs, err = audioCodecArgs(stream.CodecName, outputPath, audioFilter != "")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	args := []string{
+		"-y",
+		"-v", "error",
+		"-i", videoOnlyPath,
+		"-i", sourcePath,
+	}
+	if chaptersPath != "" {
+		args = append(args, "-i", chaptersPath, "-map_chapters", "2")
+	}
+	args = append(args, "-map", "0:v:0")
+	if codecArgs != nil && audioFilter != "" {
+		args = append(args, "-filter_complex", audioFilter, "-map", "[audio]")
+	} else if codecArgs != nil {
+		args = append(args, "-map", "1:a:0")
+	}
+	args = append(args, videoArgs...)
+	args = append(args, codecArgs...)
+	args = append(args, metadataArgs(tags...)...)
+	if codecArgs != nil {
+		args = append(args, "-shortest")
+	}
+	args = append(args, outputPath)
+
+	if output, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("failed to mux output: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return os.Remove(videoOnlyPath)
+}
+
+// trimmedAudioRanges returns the spans of the source, in seconds, whose
+// frames a trim output keeps, so its audio can be cut to match. It makes
+// the same per-frame decision as trimInappropriateContent.
+func trimmedAudioRanges(ratings []RatingResult, age int, failSafe bool, fps float64, totalFrames int) []TimeRange {
+	ranges := []TimeRange{}
+	open := false
+	for frame := 0; frame < totalFrames; frame++ {
+		timestamp := float64(frame) / fps
+		keep, _ := trimKeepsFrame(ratings, age, timestamp)
+		if keep && failSafe && uncertainAt(ratings, timestamp, config.FailSafeConfidence) {
+			keep = false
+		}
+		switch {
+		case keep && open:
+			ranges[len(ranges)-1].End = float64(frame+1) / fps
+		case keep:
+			ranges = append(ranges, TimeRange{Start: timestamp, End: float64(frame+1) / fps})
+		}
+		open = keep
+	}
+	return ranges
+}
+
+// timeSpansExpr returns an ffmpeg expression that is non-zero while t is
+// within any of ranges.
+func timeSpansExpr(ranges []TimeRange) string {
+	spans := make([]string, len(ranges))
+	for i, r := range ranges {
+		spans[i] = fmt.Sprintf("between(t,%.6f,%.6f)", r.Start, r.End)
+	}
+	return strings.Join(spans, "+")
+}
+
+// sourceAudioStream returns the first audio stream of path, or nil if the
+// source is silent. Outputs of silent sources are written video only,
+// since mapping a missing audio stream makes ffmpeg fail; every mode that
+// muxes audio goes through here.
+func sourceAudioStream(path string) (*ProbeStream, error) {
+	streams, err := probeStreams(path)
+	if err != nil {
+		return nil, err
+	}
+	for i := range streams {
+		if streams[i].CodecType == "audio" {
+			return &streams[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// outputMarker is the censored_by tag value written on every output, by
+// which re-submitted outputs are recognized.
+const outputMarker = "censor-ai"
+
+// debugOutputTag marks debug outputs, which are not censored and so are
+// not treated as outputs of this tool when uploaded again.
+const debugOutputTag = "censor_debug"
+
+// isOwnOutput reports whether the file at path carries the censored_by tag
+// of an output of this tool. Files that cannot be probed are assumed not
+// to be.
+func isOwnOutput(path string) (bool, map[string]string) {
+	tags, err := probeFormatTags(path)
+	if err != nil {
+		return false, nil
+	}
+	return tags["censored_by"] == outputMarker && tags[debugOutputTag] != "true", tags
+}
+
+// metadataArgs copies the source's (input 1) global metadata unless
+// COPY_METADATA is off, and adds the censored_by tag and any extra
+// key=value tags. MP4 only keeps custom keys with use_metadata_tags.
+func metadataArgs(tags ...string) []string {
+	args := []string{"-map_metadata", "-1"}
+	if config.CopyMetadata {
+		args = []string{"-map_metadata", "1"}
+	}
+	args = append(args, "-movflags", "use_metadata_tags", "-metadata", "censored_by="+outputMarker)
+	for _, tag := range tags {
+		args = append(args, "-metadata", tag)
+	}
+	return args
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestFinalizeTrimOfVideoOnlySource(t *testing.T) {
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.mp4")
+	videoOnly := filepath.Join(dir, "output_video.mp4")
+	output := filepath.Join(dir, "output.mp4")
+	// Two seconds at 10fps without an audio stream, standing in for both
+	// the source and the frames gocv wrote.
+	for _, path := range []string{source, videoOnly} {
+		generate := exec.Command("ffmpeg", "-y", "-v", "error",
+			"-f", "lavfi", "-i", "testsrc=duration=2:size=64x64:rate=10",
+			"-c:v", "mpeg4", "-pix_fmt", "yuv420p", "-an", path)
+		if out, err := generate.CombinedOutput(); err != nil {
+			t.Fatalf("failed to generate test video: %v: %s", err, out)
+		}
+	}
+
+	ratings := []RatingResult{
+		{Start: 0, End: 1, Rating: mildestRating()},
+		{Start: 1, End: 2, Rating: strictestRating()},
+	}
+	keep := trimmedAudioRanges(ratings, getRatingValue(mildestRating()), false, 10, 20)
+	if len(keep) != 1 {
+		t.Fatalf("kept spans = %+v, want the first second", keep)
+	}
+
+	if err := finalizeOutput(source, videoOnly, output, true, audioEdit{keep: keep}, ""); err != nil {
+		t.Fatalf("finalizeOutput: %v", err)
+	}
+
+	streams, err := probeStreams(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 1 || streams[0].CodecType != "video" {
+		t.Errorf("output streams = %+v, want a single video stream", streams)
+	}
+	if _, err := os.Stat(videoOnly); !os.IsNotExist(err) {
+		t.Error("video-only file was not removed")
+	}
+}
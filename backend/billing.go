@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers OpenAI uses to attribute a request's usage to an organization
+// and project. Clients may send the same headers to override the
+// configured values when OPENAI_BILLING_OVERRIDE is set.
+const (
+	openAIOrganizationHeader = "OpenAI-Organization"
+	openAIProjectHeader      = "OpenAI-Project"
+)
+
+// openAIBilling is the organization and project OpenAI requests are billed
+// to. Empty fields are not sent, leaving the API key's default.
+type openAIBilling struct {
+	Organization string
+	Project      string
+}
+
+type openAIBillingKey struct{}
+
+// withOpenAIBilling returns ctx carrying the billing for the request c: the
+// configured OPENAI_ORGANIZATION and OPENAI_PROJECT, overridden by the
+// request's own headers if allowed.
+func withOpenAIBilling(ctx context.Context, c *gin.Context) context.Context {
+	billing := openAIBilling{Organization: config.OpenAIOrganization, Project: config.OpenAIProject}
+	if config.OpenAIBillingOverride {
+		if org := c.GetHeader(openAIOrganizationHeader); org != "" {
+			billing.Organization = org
+		}
+		if project := c.GetHeader(openAIProjectHeader); project != "" {
+			billing.Project = project
+		}
+	}
+	return context.WithValue(ctx, openAIBillingKey{}, billing)
+}
+
+// setOpenAIBillingHeaders adds the billing headers carried by ctx, or the
+// configured ones if it carries none, to an OpenAI request.
+func setOpenAIBillingHeaders(ctx context.Context, header http.Header) {
+	billing, ok := ctx.Value(openAIBillingKey{}).(openAIBilling)
+	if !ok {
+		billing = openAIBilling{Organization: config.OpenAIOrganization, Project: config.OpenAIProject}
+	}
+	if billing.Organization != "" {
+		header.Set(openAIOrganizationHeader, billing.Organization)
+	}
+	if billing.Project != "" {
+		header.Set(openAIProjectHeader, billing.Project)
+	}
+}
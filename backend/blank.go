@@ -0,0 +1,27 @@
+package main
+
+import (
+	"gocv.io/x/gocv"
+)
+
+// isBlankFrame reports whether img is nearly uniform black or white, such as
+// a fade or an intro card, and so not worth an OpenAI call. The frame's
+// grayscale standard deviation must be at most BLANK_FRAME_MAX_STDDEV and
+// its mean within BLANK_FRAME_MARGIN of 0 or 255.
+func isBlankFrame(img gocv.Mat) bool {
+	gray := gocv.NewMat()
+	defer gray.Close()
+	gocv.CvtColor(img, &gray, gocv.ColorBGRToGray)
+
+	mean := gocv.NewMat()
+	defer mean.Close()
+	stddev := gocv.NewMat()
+	defer stddev.Close()
+	gocv.MeanStdDev(gray, &mean, &stddev)
+
+	if stddev.GetDoubleAt(0, 0) > config.BlankFrameMaxStdDev {
+		return false
+	}
+	m := mean.GetDoubleAt(0, 0)
+	return m <= config.BlankFrameMargin || m >= 255-config.BlankFrameMargin
+}
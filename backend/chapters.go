@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// highestRating returns the most restrictive rating among ratings, or ""
+// if there are none.
+func highestRating(ratings []RatingResult) string {
+	highest := ""
+	for _, rating := range ratings {
+		if highest == "" || getRatingValue(rating.Rating) > getRatingValue(highest) {
+			highest = rating.Rating
+		}
+	}
+	return highest
+}
+
+// ratingTags returns the key=value metadata tags describing the ratings
+// of a video censored for age.
+func ratingTags(ratings []RatingResult, age int) []string {
+	tags := []string{"censored_for_age=" + config.RatingScale[ageLevel(age)].Name}
+	if highest := highestRating(ratings); highest != "" {
+		tags = append(tags, "content_rating="+highest)
+	}
+	return tags
+}
+
+// writeChapters writes an ffmpeg metadata file with one chapter per rated
+// segment, titled with its rating and whether it was censored. Each chapter
+// runs to the start of the next segment. The caller removes the file.
+func writeChapters(path string, ratings []RatingResult, age int) error {
+	ratings = append([]RatingResult(nil), ratings...)
+	sort.SliceStable(ratings, func(i, j int) bool {
+		return ratings[i].Start < ratings[j].Start
+	})
+
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for i, segment := range ratings {
+		end := segment.End
+		if i+1 < len(ratings) {
+			end = ratings[i+1].Start
+		}
+		if end <= segment.Start {
+			continue
+		}
+
+		title := segment.Rating
+		if getRatingValue(segment.Rating) > age {
+			title += " (censored)"
+		}
+		fmt.Fprintf(&b, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			int64(segment.Start*1000), int64(end*1000), escapeFFMetadata(title))
+	}
+
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write chapters: %v", err)
+	}
+	return nil
+}
+
+// escapeFFMetadata escapes the characters special to ffmpeg metadata files.
+func escapeFFMetadata(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n").Replace(value)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// analysisCheckpoint saves the progress of a video's analysis to
+// CHECKPOINT_DIR so a failed analysis of the same content can resume where
+// it stopped instead of paying for every frame again. Checkpoints are keyed
+// by a hash of the file, and only resume an analysis run with the same
+// options. A nil *analysisCheckpoint saves nothing.
+type analysisCheckpoint struct {
+	path    string
+	options string
+}
+
+// checkpointFile is the on-disk form of a checkpoint.
+type checkpointFile struct {
+	Options string         `json:"options"`
+	Cursor  AnalysisCursor `json:"cursor"`
+}
+
+// newAnalysisCheckpoint returns the checkpoint of videoPath analyzed with
+// opts, or nil if checkpoints are disabled or the file cannot be hashed.
+func newAnalysisCheckpoint(videoPath string, opts AnalysisOptions) *analysisCheckpoint {
+	if config.CheckpointDir == "" {
+		return nil
+	}
+	hash, err := hashFile(videoPath)
+	if err != nil {
+		log.Printf("Failed to hash %s, analyzing without checkpoints: %v", videoPath, err)
+		return nil
+	}
+	// Settings that change how frames are rated are part of the key too, so
+	// a checkpoint never mixes ratings from different models or prompts.
+	options, _ := json.Marshal(struct {
+		Interpolation    int
+		SamplesPerSecond int
+		SampleInterval   float64
+		Resolution       int
+		SceneContext     string
+		Transform        *FrameTransform
+		Verbose          bool
+		Analyzers        []string
+		Models           []string
+		ImageDetail      string
+		ImageFormat      string
+		Prompt           string
+		Temperature      float64
+		Seed             *int
+	}{
+		int(opts.Interpolation), opts.SamplesPerSecond, opts.SampleInterval, opts.resolution(), opts.SceneContext, opts.Transform, opts.Verbose,
+		config.AnalyzerChain, []string{openAIModel, config.AzureOpenAIDeployment, config.LocalModel, config.PrefilterModel},
+		config.OpenAIImageDetail, config.AnalysisImageFormat, promptHash(opts.SceneContext),
+		config.OpenAITemperature, config.OpenAISeed,
+	})
+
+	return &analysisCheckpoint{
+		path:    filepath.Join(config.CheckpointDir, hash+".json"),
+		options: string(options),
+	}
+}
+
+// load returns the saved cursor, or an empty one if there is no usable
+// checkpoint.
+func (cp *analysisCheckpoint) load() AnalysisCursor {
+	if cp == nil {
+		return AnalysisCursor{}
+	}
+	data, err := os.ReadFile(cp.path)
+	if err != nil {
+		return AnalysisCursor{}
+	}
+	var saved checkpointFile
+	if err := json.Unmarshal(data, &saved); err != nil || saved.Options != cp.options {
+		return AnalysisCursor{}
+	}
+	return saved.Cursor
+}
+
+// save replaces the checkpoint with cursor. Failures are logged, as the
+// analysis itself can carry on without it.
+func (cp *analysisCheckpoint) save(cursor AnalysisCursor) {
+	if cp == nil {
+		return
+	}
+	data, err := json.Marshal(checkpointFile{Options: cp.options, Cursor: cursor})
+	if err == nil {
+		err = os.MkdirAll(config.CheckpointDir, os.ModePerm)
+	}
+	if err == nil {
+		// Written aside and renamed, so a crash never leaves half a file.
+		tempPath := cp.path + ".tmp"
+		if err = os.WriteFile(tempPath, data, 0644); err == nil {
+			err = os.Rename(tempPath, cp.path)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to save analysis checkpoint: %v", err)
+	}
+}
+
+// remove deletes the checkpoint once the analysis is complete.
+func (cp *analysisCheckpoint) remove() {
+	if cp != nil {
+		os.Remove(cp.path)
+	}
+}
+
+// promptHash returns the hex SHA-256 of the prompt and few-shot examples
+// each frame is sent with.
+func promptHash(sceneContext string) string {
+	h := sha256.New()
+	io.WriteString(h, analysisPrompt(sceneContext))
+	for _, example := range fewShotExamples {
+		io.WriteString(h, "\x00"+example.dataURL+"\x00"+example.answer)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashFile returns the hex SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash file: %v", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// analyzeWithCheckpoints analyzes videoPath in steps of CHECKPOINT_INTERVAL
+// seconds, saving the cursor after each one. It starts from the saved
+// checkpoint if there is one, and removes it once the video is done. A
+// failed analysis keeps the last checkpoint for the next attempt.
+func analyzeWithCheckpoints(ctx context.Context, videoPath string, opts AnalysisOptions) (AnalysisCursor, error) {
+	cp := newAnalysisCheckpoint(videoPath, opts)
+	if cp == nil {
+		return analyzeVideoFrom(ctx, videoPath, opts, AnalysisCursor{}, true)
+	}
+	fps, _, err := analysisFrameRate(videoPath)
+	if err != nil {
+		return AnalysisCursor{}, err
+	}
+
+	cursor := cp.load()
+	if cursor.NextFrame > 0 {
+		log.Printf("Resuming analysis of %s from %.2fs", videoPath, float64(cursor.NextFrame)/fps)
+	}
+	step := max(int(config.CheckpointInterval.Seconds()), 1) * int(fps)
+
+	for {
+		stepOpts := opts
+		stepOpts.stopFrame = cursor.NextFrame + step
+		next, err := analyzeVideoFrom(ctx, videoPath, stepOpts, cursor, true)
+		if err != nil {
+			return next, err
+		}
+		// A frame that could not be rated ends the analysis with an error
+		// rating; the checkpoint is left as it was.
+		if analysisFailed(next) {
+			return next, nil
+		}
+		if next.NextFrame < stepOpts.stopFrame {
+			cp.remove()
+			return next, nil
+		}
+		cp.save(next)
+		cursor = next
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// encodeChunked splits the video into ENCODE_CHUNKS frame ranges, censors
+// each range concurrently into its own temp file and concatenates the
+// results into outputPath with ffmpeg. Censor decisions use absolute frame
+// timestamps, so segments spanning a chunk boundary are handled the same
+// as in a single pass.
+func encodeChunked(ctx context.Context, videoPath, outputPath string, ratings []RatingResult, age int, videoType string, opts ConvertOptions, fps float64, width, height, totalFrames int) (int, error) {
+	start := time.Now()
+	chunks := config.EncodeChunks
+	base := strings.TrimSuffix(outputPath, ".mp4")
+
+	chunkPaths := make([]string, chunks)
+	chunkFrames := make([]int, chunks)
+	errs := make([]error, chunks)
+	defer func() {
+		for _, path := range chunkPaths {
+			os.Remove(path)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < chunks; i++ {
+		chunkPaths[i] = fmt.Sprintf("%s_chunk%d.mp4", base, i)
+		startFrame := i * totalFrames / chunks
+		endFrame := (i + 1) * totalFrames / chunks
+
+		wg.Add(1)
+		go func(i, startFrame, endFrame int) {
+			defer wg.Done()
+			chunkFrames[i], errs[i] = encodeChunk(ctx, videoPath, chunkPaths[i], ratings, age, videoType, opts, fps, width, height, startFrame, endFrame)
+		}(i, startFrame, endFrame)
+	}
+	wg.Wait()
+
+	written := 0
+	for i, err := range errs {
+		if err != nil {
+			return 0, fmt.Errorf("chunk %d failed: %v", i, err)
+		}
+		written += chunkFrames[i]
+	}
+
+	if err := concatChunks(chunkPaths, outputPath); err != nil {
+		return 0, err
+	}
+
+	log.Printf("Encoded %d frames in %d chunks in %v", totalFrames, chunks, time.Since(start))
+	return written, nil
+}
+
+func encodeChunk(ctx context.Context, videoPath, chunkPath string, ratings []RatingResult, age int, videoType string, opts ConvertOptions, fps float64, width, height, startFrame, endFrame int) (int, error) {
+	video, err := gocv.VideoCaptureFile(videoPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open video: %v", err)
+	}
+	defer video.Close()
+
+	if startFrame > 0 {
+		video.Set(gocv.VideoCapturePosFrames, float64(startFrame))
+	}
+
+	writer, err := newOutputWriter(chunkPath, fps/float64(opts.frameStep(videoType, fps)), width, height)
+	if err != nil {
+		return 0, err
+	}
+	defer writer.Close()
+	writer.progress = opts.Progress
+
+	if videoType == "blur" {
+		err = blurInappropriateContent(ctx, video, writer, ratings, age, opts, fps, startFrame, endFrame)
+	} else {
+		err = trimInappropriateContent(ctx, video, writer, ratings, age, opts, fps, startFrame, endFrame)
+	}
+	return writer.frames, err
+}
+
+// concatChunks joins chunk files in order using ffmpeg's concat demuxer,
+// without re-encoding. Empty chunks (fully trimmed ranges) are skipped.
+func concatChunks(chunkPaths []string, outputPath string) error {
+	listFile, err := os.CreateTemp("", "censorai_concat_*.txt")
+	if err != nil {
+		return fmt.Errorf("failed to create concat list: %v", err)
+	}
+	defer os.Remove(listFile.Name())
+
+	written := 0
+	for _, path := range chunkPaths {
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 || !hasVideoFrames(path) {
+			continue
+		}
+		absPath, err := absolutePath(path)
+		if err != nil {
+			listFile.Close()
+			return err
+		}
+		fmt.Fprintf(listFile, "file '%s'\n", strings.ReplaceAll(absPath, "'", `'\''`))
+		written++
+	}
+	listFile.Close()
+
+	if written == 0 {
+		return os.Rename(chunkPaths[0], outputPath)
+	}
+
+	cmd := exec.Command("ffmpeg",
+		"-y",
+		"-v", "error",
+		"-f", "concat",
+		"-safe", "0",
+		"-i", listFile.Name(),
+		"-c", "copy",
+		outputPath,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("failed to concatenate chunks: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// hasVideoFrames reports whether the file at path contains at least one
+// decodable frame.
+func hasVideoFrames(path string) bool {
+	video, err := gocv.VideoCaptureFile(path)
+	if err != nil {
+		return false
+	}
+	defer video.Close()
+
+	img := gocv.NewMat()
+	defer img.Close()
+	return video.Read(&img) && !img.Empty()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// outputClaims holds the output paths of renders in progress, so that two
+// jobs never write the same file even if their names collide.
+var outputClaims = struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+// claimOutputPaths reserves one output path in opts' output directory per
+// suffix, all named after the ID of the job whose processing context is
+// ctx, or a timestamp outside a job. If any of the paths is claimed by a
+// running job or already exists on disk, e.g. from a job ID reused by a
+// replayed request, a counter is appended until they are all free.
+// release frees the claims once the outputs are complete.
+func claimOutputPaths(ctx context.Context, opts ConvertOptions, suffixes ...string) (paths []string, release func()) {
+	outputClaims.mu.Lock()
+	defer outputClaims.mu.Unlock()
+
+	id, ok := ctx.Value(jobIDKey{}).(string)
+	if !ok {
+		id = strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	for attempt := 1; ; attempt++ {
+		unique := id
+		if attempt > 1 {
+			unique = fmt.Sprintf("%s_%d", id, attempt)
+		}
+		paths = paths[:0]
+		free := true
+		for _, suffix := range suffixes {
+			path := filepath.Join(opts.outputDir(), opts.outputBaseName(unique)+suffix)
+			if outputClaims.paths[path] || fileExists(path) {
+				free = false
+				break
+			}
+			paths = append(paths, path)
+		}
+		if free {
+			break
+		}
+	}
+
+	for _, path := range paths {
+		outputClaims.paths[path] = true
+	}
+	return paths, func() {
+		outputClaims.mu.Lock()
+		defer outputClaims.mu.Unlock()
+		for _, path := range paths {
+			delete(outputClaims.paths, path)
+		}
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
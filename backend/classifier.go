@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"sync"
+
+	"gocv.io/x/gocv"
+)
+
+// frameClassifier scores frames with a local OpenCV DNN model (e.g. an ONNX
+// NSFW/violence classifier). The score is the highest probability among the
+// configured unsafe output classes. gocv.Net isn't safe for concurrent use,
+// so calls are serialized.
+type frameClassifier struct {
+	mu            sync.Mutex
+	net           gocv.Net
+	inputSize     int
+	unsafeClasses []int
+}
+
+func newFrameClassifier(modelPath string, inputSize int, unsafeClasses []int) (*frameClassifier, error) {
+	if _, err := os.Stat(modelPath); err != nil {
+		return nil, fmt.Errorf("classifier model %s is missing: %v", modelPath, err)
+	}
+
+	net := gocv.ReadNet(modelPath, "")
+	if net.Empty() {
+		net.Close()
+		return nil, fmt.Errorf("failed to load classifier model %s", modelPath)
+	}
+
+	return &frameClassifier{
+		net:           net,
+		inputSize:     inputSize,
+		unsafeClasses: unsafeClasses,
+	}, nil
+}
+
+// score returns the unsafe-content probability for img, in [0, 1].
+func (fc *frameClassifier) score(img gocv.Mat) (float64, error) {
+	blob := gocv.BlobFromImage(img, 1.0/255.0, image.Point{X: fc.inputSize, Y: fc.inputSize}, gocv.NewScalar(0, 0, 0, 0), true, false)
+	defer blob.Close()
+
+	fc.mu.Lock()
+	fc.net.SetInput(blob, "")
+	output := fc.net.Forward("")
+	fc.mu.Unlock()
+	defer output.Close()
+
+	probs := output.Reshape(1, 1)
+	defer probs.Close()
+
+	var best float64
+	for _, class := range fc.unsafeClasses {
+		if class < 0 || class >= probs.Cols() {
+			return 0, fmt.Errorf("classifier has no output class %d", class)
+		}
+		if p := float64(probs.GetFloatAt(0, class)); p > best {
+			best = p
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,788 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// Config holds the runtime settings loaded from the environment (and .env).
+type Config struct {
+	// RatingScale lists the ratings the analysis uses, from least to most
+	// restrictive, with the value each is compared to the target age by.
+	// RatingGuidelinesFile optionally describes them to the model.
+	RatingScale          []RatingLevel
+	RatingGuidelinesFile string
+
+	// NoteRatingFloors maps a note keyword to the minimum rating a frame
+	// must receive when the model mentions it, e.g. "gore" -> "18+".
+	NoteRatingFloors map[string]string
+
+	// WarmupEnabled runs the gocv/model warmup before accepting requests.
+	WarmupEnabled bool
+	// WarmupModels lists DNN model files that must load at startup.
+	WarmupModels []string
+
+	// ProcessedRetention is how long the name of a produced output is
+	// remembered, so downloads of removed files can be reported as expired.
+	ProcessedRetention time.Duration
+	// ProcessedMaxEntries caps how many output names are remembered; the
+	// oldest are forgotten first.
+	ProcessedMaxEntries int
+	// ProcessedMetadataFile keeps the remembered names across restarts;
+	// "none" keeps them in memory only.
+	ProcessedMetadataFile string
+
+	// OutputPixFmt is the pixel format of outputs, e.g. yuv420p for the
+	// widest player support; "source" keeps what the encoder wrote.
+	OutputPixFmt string
+
+	// AudioMux copies the source audio into outputs with ffmpeg, cut to
+	// the kept frames of trim outputs.
+	AudioMux bool
+	// AudioCodec is "copy", a codec name (aac, opus, mp3, vorbis, flac), or
+	// empty to copy when the container allows it and transcode otherwise.
+	AudioCodec string
+	// AudioBitrate is passed to ffmpeg as -b:a when transcoding, e.g. "128k".
+	AudioBitrate string
+	// AudioChannelLayout downmixes/upmixes to mono, stereo or 5.1.
+	AudioChannelLayout string
+
+	// PrefilterModel is a local DNN classifier used to skip OpenAI analysis
+	// for videos whose sampled frames all score below PrefilterThreshold.
+	PrefilterModel     string
+	PrefilterSamples   int
+	PrefilterThreshold float64
+	// LocalModel is the DNN classifier of the "local" analyzer and the
+	// local fallback. It defaults to PrefilterModel.
+	LocalModel string
+	// ClassifierInputSize is the square input size of local classifiers.
+	ClassifierInputSize int
+	// ClassifierUnsafeClasses are the output indices treated as unsafe.
+	ClassifierUnsafeClasses []int
+
+	// OpenAITemperature is sent with every analysis request; 0 keeps ratings
+	// as stable as the provider allows.
+	OpenAITemperature float64
+	// OpenAISeed is sent as the request seed when set, for best-effort
+	// reproducible ratings.
+	OpenAISeed *int
+	// OpenAIOrganization and OpenAIProject are sent as the OpenAI-Organization
+	// and OpenAI-Project headers for billing attribution when set. With
+	// OpenAIBillingOverride, requests may set their own.
+	OpenAIOrganization    string
+	OpenAIProject         string
+	OpenAIBillingOverride bool
+
+	// OCREnabled runs Tesseract over sampled frames and rates frames showing
+	// a word from OCRBlocklist at least OCRRating, blurring just the text.
+	OCREnabled   bool
+	OCRBlocklist []string
+	OCRRating    string
+	OCRLanguage  string
+
+	// RegionBlur asks the model for boxes around the content that raised
+	// its rating, so only those boxes are blurred.
+	RegionBlur bool
+
+	// MinCensorDuration is the default length, in seconds, below which a
+	// flagged span is left uncensored. Requests can override it.
+	MinCensorDuration float64
+	// ConsolidateMinDuration is the default length, in seconds, below which
+	// an analyzed segment is folded into a stricter neighbour no more than
+	// ConsolidateMaxGap seconds away; see consolidateSegments. 0 disables it.
+	ConsolidateMinDuration float64
+	ConsolidateMaxGap      float64
+
+	// EncodeChunks splits blur/trim encodes into this many time ranges that
+	// are processed concurrently and concatenated with ffmpeg. 1 disables it.
+	EncodeChunks int
+
+	// BlurFrameStep keeps every BlurFrameStep-th source frame in blur
+	// outputs, lowering the output frame rate to match. 1 keeps every
+	// frame.
+	BlurFrameStep int
+	// MaxOutputFPS caps the frame rate of blur and trim outputs by keeping
+	// every Nth source frame of faster sources. 0 disables it.
+	MaxOutputFPS float64
+
+	// AnalyzerChain lists the analyzers ("openai", "azure", "local") each
+	// frame is tried with, in order, until one rates it. ANALYZER names a
+	// single analyzer and is used when ANALYZER_CHAIN is unset.
+	AnalyzerChain []string
+	// Azure OpenAI deployment used by the "azure" analyzer.
+	AzureOpenAIEndpoint   string
+	AzureOpenAIKey        string
+	AzureOpenAIDeployment string
+	AzureOpenAIAPIVersion string
+
+	// FallbackPolicy decides what happens when no analyzer can rate a frame:
+	// "fail", "local" (use the local classifier) or "conservative" (rate
+	// the rest of the video FallbackRating).
+	FallbackPolicy string
+	FallbackRating string
+
+	// Thumbnail sprite settings for the optional WebVTT thumbnail track.
+	ThumbnailInterval float64
+	ThumbnailWidth    int
+	ThumbnailColumns  int
+
+	// ResizeInterpolation is the default method for gocv.Resize calls.
+	ResizeInterpolation gocv.InterpolationFlags
+
+	// OpenAIMaxConcurrency caps concurrent OpenAI calls; 0 means no limit.
+	OpenAIMaxConcurrency int
+	// OpenAIRateLimit paces OpenAI calls to this many per second, in bursts
+	// of up to OpenAIRateBurst; 0 means no limit.
+	OpenAIRateLimit float64
+	OpenAIRateBurst int
+	// AnalysisWorkers is how many sampled frames of one video are analyzed
+	// at once. 1 analyzes them one after another.
+	AnalysisWorkers int
+	// MaxConcurrentAnalyses and MaxConcurrentEncodes cap how many requests
+	// analyze and encode at once; 0 means no limit.
+	MaxConcurrentAnalyses int
+	MaxConcurrentEncodes  int
+
+	// StorageBackend enables the pre-signed URL flow: "local" or "s3".
+	StorageBackend       string
+	StorageDir           string
+	StorageSigningSecret string
+	SignedURLExpiry      time.Duration
+	StorageTimeout       time.Duration
+	S3Bucket             string
+	S3Region             string
+	S3Endpoint           string
+	S3AccessKey          string
+	S3SecretKey          string
+	// MaxUploadSize caps bodies uploaded to locally signed URLs.
+	MaxUploadSize int64
+
+	// TrustedProxies are the CIDRs and addresses of reverse proxies whose
+	// forwarded headers are honored. Empty trusts none.
+	TrustedProxies []string
+
+	// OpenAIProxyURL overrides the proxy from HTTPS_PROXY/HTTP_PROXY.
+	OpenAIProxyURL string
+	// OpenAICAFile is an extra PEM CA bundle trusted for OpenAI calls.
+	OpenAICAFile string
+
+	// CopyMetadata carries the source's container metadata (creation date,
+	// title, ...) over to the output.
+	CopyMetadata bool
+
+	// AllowedVideoExtensions and AllowedVideoMIMETypes together decide which
+	// uploads are accepted as video.
+	AllowedVideoExtensions []string
+	AllowedVideoMIMETypes  []string
+
+	// ReviewConfidenceThreshold queues segments below this confidence for
+	// human review.
+	ReviewConfidenceThreshold float64
+
+	// VideoCodecs are the fourcc codes tried, in order, when creating the
+	// output writer.
+	VideoCodecs []string
+
+	// NoteStopwords are notes dropped from segment results, and
+	// NoteMinLength drops notes shorter than it.
+	NoteStopwords []string
+	NoteMinLength int
+	// MaxNotes caps the notes kept per segment, most frequent first; 0
+	// keeps them all.
+	MaxNotes int
+
+	// AllowedImageExtensions and AllowedImageMIMETypes decide which images
+	// /upload rates as a single frame.
+	AllowedImageExtensions []string
+	AllowedImageMIMETypes  []string
+
+	// ProcessingTimeout bounds each analysis or conversion; 0 disables it.
+	ProcessingTimeout time.Duration
+	// OpenAIImageDetail is the detail level ("low", "high" or "auto") of
+	// the frame sent for analysis.
+	OpenAIImageDetail string
+	// MinAnalysisResolution is the shorter side, in pixels, below which
+	// /upload warns that ratings may be unreliable; 0 disables the check.
+	MinAnalysisResolution int
+	// AnalysisImageFormat is the encoding ("jpg" or "webp") of frames sent
+	// for analysis.
+	AnalysisImageFormat string
+	// AnalysisResolution is the size, in pixels, frames are scaled to for
+	// analysis; /upload's analysis_resolution overrides it.
+	AnalysisResolution int
+	// OpenAICallTimeout bounds each frame's OpenAI call; 0 disables it.
+	OpenAICallTimeout time.Duration
+	// OpenAIRetries is how many times a rate-limited or failed analysis
+	// call is repeated, waiting OpenAIRetryBackoff, doubled each time.
+	OpenAIRetries      int
+	OpenAIRetryBackoff time.Duration
+	// OpenAIBreakerThreshold failed calls in a row stop calls to a provider
+	// for OpenAIBreakerCooldown; 0 disables the breaker.
+	OpenAIBreakerThreshold int
+	OpenAIBreakerCooldown  time.Duration
+	// CheckpointDir, if set, is where long analyses save their progress so
+	// a failed one can resume; see analysisCheckpoint.
+	CheckpointDir string
+	// CheckpointInterval is how much video is analyzed between checkpoints.
+	CheckpointInterval time.Duration
+	// SyncMaxDuration is the longest video /convert converts with
+	// mode=sync; longer ones must use mode=async. 0 means no limit.
+	SyncMaxDuration time.Duration
+	// JobRetention is how long finished jobs stay visible at /jobs/:jobid.
+	JobRetention time.Duration
+	// DatabaseURL is where jobs are persisted beyond JobRetention and
+	// restarts: a SQLite file, or a postgres:// URL. "none" keeps them in
+	// memory only.
+	DatabaseURL string
+	// InstanceID names this server in DATABASE_URL, so a restart only
+	// fails the unfinished jobs it was running itself and not those of
+	// other servers sharing the database.
+	InstanceID string
+
+	// OpenAICostPerCall is the estimated price, in USD, of one frame
+	// analysis call, used by /estimate.
+	OpenAICostPerCall float64
+
+	// SkipBlankFrames rates nearly uniform black or white frames
+	// the mildest rating without calling OpenAI; see isBlankFrame.
+	SkipBlankFrames     bool
+	BlankFrameMaxStdDev float64
+	BlankFrameMargin    float64
+
+	// FrameReadMaxFailures is how many consecutive unreadable frames the
+	// blur/trim loops skip before ending the output early.
+	FrameReadMaxFailures int
+
+	// TenantHeader names the request header carrying the tenant ID that
+	// scopes uploads and outputs; see tenantScope.
+	TenantHeader    string
+	TenantRequired  bool
+	TenantDiskQuota int64
+	TenantMaxJobs   int
+
+	// ShotSnapWindow, when positive, moves segment boundaries to a shot
+	// cut at most this many seconds away. ShotCutThreshold is the
+	// histogram distance between frames that counts as a cut.
+	ShotSnapWindow   float64
+	ShotCutThreshold float64
+
+	// TriageSamples is the default number of frames /triage checks.
+	TriageSamples int
+
+	// EmbedRatings is the default for /convert's embed_ratings option.
+	EmbedRatings bool
+
+	// SamplesPerSecond frames of each second are analyzed and combined by
+	// SampleVote (strictest or majority).
+	SamplesPerSecond int
+	SampleVote       string
+	// RangeSamplesPerSecond is the denser default sampling used when a
+	// request limits the analysis to given ranges.
+	RangeSamplesPerSecond int
+	// SamplingMode is the default of /upload's sampling option: "fixed"
+	// samples every second, "scene" samples each shot, at least every
+	// SceneMaxInterval seconds; see analyzeScenes.
+	SamplingMode     string
+	SceneMaxInterval float64
+
+	// PresetsFile is a JSON file of named /convert policy presets.
+	PresetsFile string
+	// FewShotFile is a JSON file of labeled example images shown to the
+	// model before every frame; see loadFewShotExamples.
+	FewShotFile string
+
+	// VerifyOutput re-reads each output before returning it and fails the
+	// request if it holds fewer than OutputMinFrameRatio of the frames
+	// written.
+	VerifyOutput        bool
+	OutputMinFrameRatio float64
+
+	// Transcript censoring: words of a /convert transcript on
+	// TranscriptBlocklist (unless the request sends its own) become
+	// TranscriptRating segments, padded by TranscriptPadding seconds.
+	TranscriptBlocklist []string
+	TranscriptRating    string
+	TranscriptPadding   float64
+
+	// HTTP server timeouts; 0 disables each. WriteTimeout runs from the
+	// end of the request headers, so it must cover upload, processing and
+	// response; streamed responses clear it.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// SkipProcessedUploads rejects /upload of files tagged as outputs of
+	// this tool, unless the request sets force.
+	SkipProcessedUploads bool
+
+	// BlurMode is the default for /convert's blur_mode. In motion mode a
+	// pixel counts as changed when it differs from the previous frame by
+	// more than MotionThreshold (0-255); changes smaller than MotionMinArea
+	// of the frame are ignored, and above MotionMaxArea the whole frame is
+	// blurred.
+	BlurMode        string
+	MotionThreshold float64
+	MotionMinArea   float64
+	MotionMaxArea   float64
+
+	// BlurRamp is how many seconds the blur takes to fade in before, and
+	// out after, a segment blurred in full; 0 switches it on and off.
+	BlurRamp float64
+
+	// BlurStreamCopy is the default for /convert's stream_copy.
+	BlurStreamCopy bool
+
+	// BlurFeather is the width, in pixels, over which region blurs fade
+	// into the rest of the frame; 0 keeps hard edges.
+	BlurFeather int
+
+	// SceneContextMaxLength bounds the scene_context clients can add to
+	// the analysis prompt.
+	SceneContextMaxLength int
+
+	// HLSSegmentDuration is the target length, in seconds, of the segments
+	// of output_format=hls outputs.
+	HLSSegmentDuration int
+
+	// LogLevel is debug, info, warn or error. Per-frame details, including
+	// the model's raw replies, are only logged at debug.
+	LogLevel string
+
+	// CorrectionsFile collects reviewer corrections from /corrections as
+	// JSON lines; uploaded frames go to CorrectionsFramesDir.
+	CorrectionsFile      string
+	CorrectionsFramesDir string
+
+	// FailSafe is the default for /convert's fail_safe. Frames that are
+	// unrated, rated with an error or refusal, or rated below
+	// FailSafeConfidence are then never shown: blur outputs show
+	// FailSafeImage (or a FailSafeColor frame) instead and trim outputs
+	// cut them.
+	FailSafe           bool
+	FailSafeConfidence float64
+	FailSafeColor      string
+	FailSafeImage      string
+
+	// LoudnessAnalysis is the default for /upload's loudness option, which
+	// reports the seconds whose momentary loudness exceeds LoudnessMaxLUFS
+	// or whose true peak exceeds LoudnessMaxPeak (dBTP).
+	LoudnessAnalysis bool
+	LoudnessMaxLUFS  float64
+	LoudnessMaxPeak  float64
+
+	// ProfanityDetection is the default for /upload's profanity option,
+	// which transcribes the audio with Whisper, per WhisperMode ("api" or
+	// "local"; empty disables it), and reports where TranscriptBlocklist
+	// words are spoken. WhisperCommand is run for local transcription.
+	ProfanityDetection bool
+	WhisperMode        string
+	WhisperModel       string
+	WhisperCommand     string
+	// AudioCensor is how /convert censors profanity segments: "bleep" or
+	// "mute".
+	AudioCensor string
+}
+
+// interpolationMethods maps accepted interpolation names to gocv flags.
+var interpolationMethods = map[string]gocv.InterpolationFlags{
+	"nearest": gocv.InterpolationNearestNeighbor,
+	"linear":  gocv.InterpolationLinear,
+	"cubic":   gocv.InterpolationCubic,
+	"area":    gocv.InterpolationArea,
+	"lanczos": gocv.InterpolationLanczos4,
+}
+
+var config Config
+
+func loadConfig() Config {
+	// The rating helpers used to validate the settings below read the
+	// scale from config, so it is loaded first.
+	scale, err := envRatingScale("RATING_SCALE", defaultRatingScale)
+	if err != nil {
+		log.Fatalf("Invalid RATING_SCALE: %v", err)
+	}
+	config.RatingScale = scale
+
+	return Config{
+		RatingScale:          config.RatingScale,
+		RatingGuidelinesFile: envString("RATING_GUIDELINES_FILE", ""),
+
+		NoteRatingFloors: envRatingMap("NOTE_RATING_FLOORS", nil),
+
+		WarmupEnabled: envBool("WARMUP_ENABLED", true),
+		WarmupModels:  envList("WARMUP_DNN_MODELS", nil),
+
+		ProcessedRetention:    envDuration("PROCESSED_METADATA_RETENTION", 7*24*time.Hour),
+		ProcessedMaxEntries:   envInt("PROCESSED_METADATA_MAX_ENTRIES", 50000),
+		ProcessedMetadataFile: envString("PROCESSED_METADATA_FILE", "processed_outputs.jsonl"),
+
+		OutputPixFmt: strings.ToLower(envString("OUTPUT_PIX_FMT", "yuv420p")),
+
+		AudioMux:           envBool("AUDIO_MUX", true),
+		AudioCodec:         strings.ToLower(os.Getenv("AUDIO_CODEC")),
+		AudioBitrate:       os.Getenv("AUDIO_BITRATE"),
+		AudioChannelLayout: strings.ToLower(os.Getenv("AUDIO_CHANNEL_LAYOUT")),
+
+		PrefilterModel:          os.Getenv("PREFILTER_MODEL"),
+		PrefilterSamples:        envInt("PREFILTER_SAMPLES", 8),
+		PrefilterThreshold:      envFloat("PREFILTER_THRESHOLD", 0.2),
+		LocalModel:              os.Getenv("LOCAL_MODEL"),
+		ClassifierInputSize:     envInt("CLASSIFIER_INPUT_SIZE", 224),
+		ClassifierUnsafeClasses: envIntList("CLASSIFIER_UNSAFE_CLASSES", []int{1}),
+
+		OpenAITemperature: envFloat("OPENAI_TEMPERATURE", 0),
+		OpenAISeed:        envOptionalInt("OPENAI_SEED"),
+
+		OpenAIOrganization:    os.Getenv("OPENAI_ORGANIZATION"),
+		OpenAIProject:         os.Getenv("OPENAI_PROJECT"),
+		OpenAIBillingOverride: envBool("OPENAI_BILLING_OVERRIDE", false),
+
+		OCREnabled:   envBool("OCR_ENABLED", false),
+		OCRBlocklist: envLowerList("OCR_BLOCKLIST", nil),
+		OCRRating:    envRating("OCR_RATING", strictestRating()),
+		OCRLanguage:  envString("OCR_LANGUAGE", "eng"),
+
+		RegionBlur: envBool("REGION_BLUR", false),
+
+		MinCensorDuration:      envFloat("MIN_CENSOR_DURATION", 0),
+		ConsolidateMinDuration: envFloat("CONSOLIDATE_MIN_DURATION", 0),
+		ConsolidateMaxGap:      envFloat("CONSOLIDATE_MAX_GAP", 1),
+
+		EncodeChunks: envInt("ENCODE_CHUNKS", 1),
+
+		BlurFrameStep: envInt("BLUR_FRAME_STEP", 1),
+		MaxOutputFPS:  envFloat("MAX_OUTPUT_FPS", 0),
+
+		AnalyzerChain:         envLowerList("ANALYZER_CHAIN", envLowerList("ANALYZER", []string{analyzerOpenAI})),
+		AzureOpenAIEndpoint:   os.Getenv("AZURE_OPENAI_ENDPOINT"),
+		AzureOpenAIKey:        os.Getenv("AZURE_OPENAI_API_KEY"),
+		AzureOpenAIDeployment: os.Getenv("AZURE_OPENAI_DEPLOYMENT"),
+		AzureOpenAIAPIVersion: envString("AZURE_OPENAI_API_VERSION", "2024-06-01"),
+
+		FallbackPolicy: envChoice("FALLBACK_POLICY", "fail", "fail", "local", "conservative"),
+		FallbackRating: envRating("FALLBACK_RATING", strictestRating()),
+
+		ThumbnailInterval: envFloat("THUMBNAIL_INTERVAL", 5),
+		ThumbnailWidth:    envInt("THUMBNAIL_WIDTH", 160),
+		ThumbnailColumns:  envInt("THUMBNAIL_COLUMNS", 10),
+
+		ResizeInterpolation: interpolationMethods[envChoice("RESIZE_INTERPOLATION", "linear", "nearest", "linear", "cubic", "area", "lanczos")],
+
+		OpenAIMaxConcurrency:  envInt("OPENAI_MAX_CONCURRENCY", 0),
+		OpenAIRateLimit:       envFloat("OPENAI_RATE_LIMIT", 0),
+		OpenAIRateBurst:       envInt("OPENAI_RATE_BURST", 1),
+		AnalysisWorkers:       envInt("ANALYSIS_WORKERS", 1),
+		MaxConcurrentAnalyses: envInt("MAX_CONCURRENT_ANALYSES", 0),
+		MaxConcurrentEncodes:  envInt("MAX_CONCURRENT_ENCODES", 0),
+
+		StorageBackend:       strings.ToLower(os.Getenv("STORAGE_BACKEND")),
+		StorageDir:           envString("STORAGE_DIR", "storage"),
+		StorageSigningSecret: os.Getenv("STORAGE_SIGNING_SECRET"),
+		SignedURLExpiry:      envDuration("SIGNED_URL_EXPIRY", 15*time.Minute),
+		StorageTimeout:       envDuration("STORAGE_TIMEOUT", 5*time.Minute),
+		S3Bucket:             os.Getenv("S3_BUCKET"),
+		S3Region:             envString("S3_REGION", "us-east-1"),
+		S3Endpoint:           os.Getenv("S3_ENDPOINT"),
+		S3AccessKey:          os.Getenv("AWS_ACCESS_KEY_ID"),
+		S3SecretKey:          os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		MaxUploadSize:        int64(envInt("MAX_UPLOAD_SIZE", maxFileSize)),
+
+		TrustedProxies: envList("TRUSTED_PROXIES", nil),
+
+		OpenAIProxyURL: os.Getenv("OPENAI_PROXY_URL"),
+		OpenAICAFile:   os.Getenv("OPENAI_CA_FILE"),
+
+		CopyMetadata: envBool("COPY_METADATA", true),
+
+		AllowedVideoExtensions: envLowerList("ALLOWED_VIDEO_EXTENSIONS", []string{"mp4", "mov", "mkv", "webm", "avi"}),
+		AllowedVideoMIMETypes: envLowerList("ALLOWED_VIDEO_MIME_TYPES", []string{
+			"video/mp4", "video/quicktime", "video/x-matroska", "video/webm", "video/x-msvideo",
+		}),
+
+		ReviewConfidenceThreshold: envFloat("REVIEW_CONFIDENCE_THRESHOLD", 0.6),
+
+		VideoCodecs: envList("VIDEO_CODECS", []string{"mp4v", "avc1", "H264", "XVID"}),
+
+		NoteStopwords: envLowerList("NOTE_STOPWORDS", nil),
+		NoteMinLength: envInt("NOTE_MIN_LENGTH", 0),
+		MaxNotes:      envInt("MAX_NOTES", 0),
+
+		AllowedImageExtensions: envLowerList("ALLOWED_IMAGE_EXTENSIONS", []string{"jpg", "jpeg", "png", "webp"}),
+		AllowedImageMIMETypes:  envLowerList("ALLOWED_IMAGE_MIME_TYPES", []string{"image/jpeg", "image/png", "image/webp"}),
+
+		ProcessingTimeout:     envDuration("PROCESSING_TIMEOUT", 30*time.Minute),
+		OpenAICallTimeout:     envDuration("OPENAI_CALL_TIMEOUT", time.Minute),
+		OpenAIImageDetail:     envChoice("OPENAI_IMAGE_DETAIL", "auto", "auto", "low", "high"),
+		MinAnalysisResolution: envInt("MIN_ANALYSIS_RESOLUTION", 240),
+		AnalysisImageFormat:   envChoice("ANALYSIS_IMAGE_FORMAT", "jpg", "jpg", "webp"),
+		AnalysisResolution:    envInt("ANALYSIS_RESOLUTION", 512),
+		JobRetention:          envDuration("JOB_RETENTION", time.Hour),
+		DatabaseURL:           envString("DATABASE_URL", "censorai.db"),
+		InstanceID:            envString("INSTANCE_ID", hostname()),
+		SyncMaxDuration:       envDuration("SYNC_MAX_DURATION", 0),
+		CheckpointDir:         os.Getenv("CHECKPOINT_DIR"),
+		CheckpointInterval:    envDuration("CHECKPOINT_INTERVAL", time.Minute),
+
+		OpenAIRetries:          envInt("OPENAI_RETRIES", 3),
+		OpenAIRetryBackoff:     envDuration("OPENAI_RETRY_BACKOFF", time.Second),
+		OpenAIBreakerThreshold: envInt("OPENAI_BREAKER_THRESHOLD", 5),
+		OpenAIBreakerCooldown:  envDuration("OPENAI_BREAKER_COOLDOWN", 30*time.Second),
+
+		OpenAICostPerCall: envFloat("OPENAI_COST_PER_CALL", 0.002),
+
+		SkipBlankFrames:     envBool("SKIP_BLANK_FRAMES", false),
+		BlankFrameMaxStdDev: envFloat("BLANK_FRAME_MAX_STDDEV", 8),
+		BlankFrameMargin:    envFloat("BLANK_FRAME_MARGIN", 24),
+
+		FrameReadMaxFailures: envInt("FRAME_READ_MAX_FAILURES", 5),
+
+		TenantHeader:    envString("TENANT_HEADER", "X-Tenant-ID"),
+		TenantRequired:  envBool("TENANT_REQUIRED", false),
+		TenantDiskQuota: int64(envInt("TENANT_DISK_QUOTA", 0)),
+		TenantMaxJobs:   envInt("TENANT_MAX_JOBS", 0),
+
+		ShotSnapWindow:   envFloat("SHOT_SNAP_WINDOW", 0),
+		ShotCutThreshold: envFloat("SHOT_CUT_THRESHOLD", 0.5),
+
+		TriageSamples: envInt("TRIAGE_SAMPLES", 16),
+
+		EmbedRatings: envBool("EMBED_RATINGS", false),
+
+		SamplesPerSecond:      envInt("SAMPLES_PER_SECOND", 1),
+		RangeSamplesPerSecond: envInt("RANGE_SAMPLES_PER_SECOND", 5),
+		SamplingMode:          envChoice("SAMPLING_MODE", samplingFixed, samplingFixed, samplingScene),
+		SceneMaxInterval:      envFloat("SCENE_MAX_INTERVAL", 5),
+		SampleVote:            envChoice("SAMPLE_VOTE", sampleVoteStrictest, sampleVoteStrictest, sampleVoteMajority),
+
+		PresetsFile: envString("PRESETS_FILE", ""),
+		FewShotFile: envString("FEW_SHOT_FILE", ""),
+
+		VerifyOutput:        envBool("VERIFY_OUTPUT", false),
+		OutputMinFrameRatio: envFloat("OUTPUT_MIN_FRAME_RATIO", 0.95),
+
+		TranscriptBlocklist: envLowerList("TRANSCRIPT_BLOCKLIST", nil),
+		TranscriptRating:    envRating("TRANSCRIPT_RATING", strictestRating()),
+		TranscriptPadding:   envFloat("TRANSCRIPT_PADDING", 0.25),
+
+		ReadHeaderTimeout: envDuration("READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       envDuration("READ_TIMEOUT", 10*time.Minute),
+		WriteTimeout:      envDuration("WRITE_TIMEOUT", 45*time.Minute),
+		IdleTimeout:       envDuration("IDLE_TIMEOUT", 2*time.Minute),
+
+		SkipProcessedUploads: envBool("SKIP_PROCESSED_UPLOADS", false),
+
+		BlurMode:        envChoice("BLUR_MODE", blurModeFrame, blurModeFrame, blurModeMotion),
+		MotionThreshold: envFloat("MOTION_THRESHOLD", 25),
+		MotionMinArea:   envFloat("MOTION_MIN_AREA", 0.001),
+		MotionMaxArea:   envFloat("MOTION_MAX_AREA", 0.5),
+
+		BlurFeather: envInt("BLUR_FEATHER", 0),
+
+		BlurStreamCopy: envBool("BLUR_STREAM_COPY", false),
+
+		BlurRamp: envFloat("BLUR_RAMP", 0),
+
+		SceneContextMaxLength: envInt("SCENE_CONTEXT_MAX_LENGTH", 500),
+
+		HLSSegmentDuration: envInt("HLS_SEGMENT_DURATION", 6),
+
+		LogLevel: envChoice("LOG_LEVEL", "info", "debug", "info", "warn", "error"),
+
+		CorrectionsFile:      envString("CORRECTIONS_FILE", "corrections.jsonl"),
+		CorrectionsFramesDir: envString("CORRECTIONS_FRAMES_DIR", "corrections"),
+
+		FailSafe:           envBool("FAIL_SAFE", false),
+		FailSafeConfidence: envFloat("FAIL_SAFE_CONFIDENCE", 0.5),
+		FailSafeColor:      envString("FAIL_SAFE_COLOR", "000000"),
+		FailSafeImage:      envString("FAIL_SAFE_IMAGE", ""),
+
+		LoudnessAnalysis: envBool("LOUDNESS_ANALYSIS", false),
+		LoudnessMaxLUFS:  envFloat("LOUDNESS_MAX_LUFS", -10),
+		LoudnessMaxPeak:  envFloat("LOUDNESS_MAX_PEAK", -1),
+
+		ProfanityDetection: envBool("PROFANITY_DETECTION", false),
+		WhisperMode:        envChoice("WHISPER_MODE", "", "", whisperAPI, whisperLocal),
+		WhisperModel:       envString("WHISPER_MODEL", "whisper-1"),
+		WhisperCommand:     os.Getenv("WHISPER_COMMAND"),
+		AudioCensor:        envChoice("AUDIO_CENSOR", audioCensorBleep, audioCensorBleep, audioCensorMute),
+	}
+}
+
+// hostname is the default INSTANCE_ID.
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+	return name
+}
+
+// envOptionalInt returns nil when the variable is unset or invalid.
+func envOptionalInt(key string) *int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("Ignoring invalid %s value %q", key, raw)
+		return nil
+	}
+	return &value
+}
+
+// envLowerList is envList with every entry lowercased.
+func envLowerList(key string, def []string) []string {
+	items := envList(key, def)
+	for i, item := range items {
+		items[i] = strings.ToLower(item)
+	}
+	return items
+}
+
+func envInt(key string, def int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("Ignoring invalid %s value %q, using %d", key, raw, def)
+		return def
+	}
+	return value
+}
+
+func envFloat(key string, def float64) float64 {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		log.Printf("Ignoring invalid %s value %q, using %v", key, raw, def)
+		return def
+	}
+	return value
+}
+
+func envIntList(key string, def []int) []int {
+	items := envList(key, nil)
+	if items == nil {
+		return def
+	}
+
+	var result []int
+	for _, item := range items {
+		value, err := strconv.Atoi(item)
+		if err != nil {
+			log.Printf("Ignoring invalid %s entry %q", key, item)
+			continue
+		}
+		result = append(result, value)
+	}
+	return result
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Printf("Ignoring invalid %s value %q, using %v", key, raw, def)
+		return def
+	}
+	return value
+}
+
+func envString(key, def string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return def
+}
+
+// envRating reads a rating such as "16+", falling back to def when the
+// value isn't on the rating scale.
+func envRating(key, def string) string {
+	value := envString(key, def)
+	if ratingLevel(value) < 0 {
+		log.Printf("Ignoring invalid %s value %q, using %s", key, value, def)
+		return def
+	}
+	return value
+}
+
+// envChoice reads a value that must be one of choices, falling back to def.
+func envChoice(key, def string, choices ...string) string {
+	value := strings.ToLower(envString(key, def))
+	for _, choice := range choices {
+		if value == choice {
+			return value
+		}
+	}
+	log.Printf("Ignoring invalid %s value %q, using %s", key, value, def)
+	return def
+}
+
+func envBool(key string, def bool) bool {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("Ignoring invalid %s value %q, using %v", key, raw, def)
+		return def
+	}
+	return value
+}
+
+// envList parses a comma-separated list, dropping empty entries.
+func envList(key string, def []string) []string {
+	raw, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+
+	var result []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// envRatingMap parses a "keyword=rating,keyword=rating" list. An empty
+// value disables the mapping entirely; an unset variable uses the default.
+func envRatingMap(key string, def map[string]string) map[string]string {
+	raw, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+
+	result := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || ratingLevel(strings.TrimSpace(parts[1])) < 0 {
+			log.Printf("Ignoring invalid %s entry: %q", key, pair)
+			continue
+		}
+		keyword := strings.ToLower(strings.TrimSpace(parts[0]))
+		result[keyword] = strings.TrimSpace(parts[1])
+	}
+	return result
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Modes of /upload and /convert. Sync answers with the result (or streams
+// progress towards it); async answers at once with a job to poll.
+const (
+	convertModeSync  = "sync"
+	convertModeAsync = "async"
+)
+
+// requestMode returns the request's mode, sync by default.
+func requestMode(c *gin.Context) (string, error) {
+	switch mode := c.PostForm("mode"); mode {
+	case "":
+		return convertModeSync, nil
+	case convertModeSync, convertModeAsync:
+		return mode, nil
+	}
+	return "", fmt.Errorf("mode must be 'sync' or 'async'")
+}
+
+// checkSyncDuration answers 413 USE_ASYNC and returns false if the video
+// at videoPath is longer than SYNC_MAX_DURATION, which a sync request
+// would wait on for too long.
+func checkSyncDuration(c *gin.Context, videoPath string) bool {
+	if config.SyncMaxDuration <= 0 {
+		return true
+	}
+	fps, totalFrames, err := analysisFrameRate(videoPath)
+	if err != nil || totalFrames <= 0 {
+		return true
+	}
+	duration := time.Duration(float64(totalFrames) / fps * float64(time.Second))
+	if duration <= config.SyncMaxDuration {
+		return true
+	}
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+		"error":          fmt.Sprintf("Video is %v long, over the %v limit of mode=sync; use mode=async", duration.Round(time.Second), config.SyncMaxDuration),
+		"code":           "USE_ASYNC",
+		"suggested_mode": convertModeAsync,
+	})
+	return false
+}
+
+// convertAsync starts the conversion of filename in the background and
+// answers 202 with its job ID at once. GET /jobs/:jobid reports the job
+// and, once it finishes, the response a sync request would have had. The
+// job keeps the request's tenant job slot until it finishes and removes
+// filename when done.
+func convertAsync(c *gin.Context, filename string, age int, ratings []RatingResult, videoType string, opts ConvertOptions) {
+	startAsyncJob(c, filename, func(ctx context.Context, request *gin.Context) (gin.H, error) {
+		result, err := processVideoByAge(ctx, filename, age, ratings, videoType, opts)
+		if err != nil {
+			return nil, err
+		}
+		return convertResponse(request, result), nil
+	})
+}
+
+// analyzeAsync is convertAsync for /upload: the job's result is the body a
+// sync JSON request would have had.
+func analyzeAsync(c *gin.Context, filename, kind string, opts AnalysisOptions, wantsLoudness bool, profanityBlocklist []string, lowRes bool) {
+	startAsyncJob(c, filename, func(ctx context.Context, _ *gin.Context) (gin.H, error) {
+		_, response, err := analyzeUpload(ctx, filename, kind, opts, wantsLoudness, profanityBlocklist, lowRes)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Timeline != nil {
+			response["timeline"] = opts.Timeline.list()
+		}
+		return response, nil
+	})
+}
+
+// startAsyncJob runs work as the request's job in the background and
+// answers 202 with the job ID at once. work gets a copy of the request,
+// which stays valid after the handler returns. The job keeps the request's
+// tenant job slot until it finishes and removes filename when done.
+func startAsyncJob(c *gin.Context, filename string, work func(ctx context.Context, request *gin.Context) (gin.H, error)) {
+	ctx, cancel, err := detachedProcessingContext(c, c.PostForm("timeout"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		os.Remove(filename)
+		return
+	}
+	id := c.Writer.Header().Get(jobIDHeader)
+	release := holdTenantJob(c)
+	request := c.Copy()
+
+	go func() {
+		defer release()
+		defer cancel()
+		defer os.Remove(filename)
+
+		result, err := work(ctx, request)
+		if err != nil {
+			_, failure := processingErrorResponse(err)
+			recordJobResult(id, nil, failure)
+			return
+		}
+		recordJobResult(id, result, nil)
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":     id,
+		"status":     jobQueued,
+		"status_url": absoluteURL(c, "/jobs/"+id),
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Correction is one reviewer correction of a model rating, stored as a
+// line of CORRECTIONS_FILE for building few-shot examples or fine-tuning
+// data later.
+type Correction struct {
+	Time            time.Time `json:"time"`
+	Tenant          string    `json:"tenant,omitempty"`
+	Video           string    `json:"video,omitempty"`
+	Start           float64   `json:"start"`
+	End             float64   `json:"end"`
+	OriginalRating  string    `json:"original_rating"`
+	OriginalNotes   string    `json:"original_notes,omitempty"`
+	CorrectedRating string    `json:"corrected_rating"`
+	CorrectedNotes  string    `json:"corrected_notes,omitempty"`
+	Reviewer        string    `json:"reviewer,omitempty"`
+	// FrameHash is the SHA-256 of the frame image; the image itself, if
+	// uploaded, is kept in CORRECTIONS_FRAMES_DIR under that name.
+	FrameHash string `json:"frame_hash,omitempty"`
+}
+
+var validFrameHash = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// correctionsMu serializes appends to CORRECTIONS_FILE.
+var correctionsMu sync.Mutex
+
+// submitCorrection handles POST /corrections. It takes the original
+// segment as rated by /upload, the corrected rating and a reference to the
+// frame: either the frame image itself or its SHA-256 hash.
+func submitCorrection(c *gin.Context) {
+	var original RatingResult
+	if err := json.Unmarshal([]byte(c.PostForm("original")), &original); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid original segment: %v", err)})
+		return
+	}
+	corrected := strings.TrimSpace(c.PostForm("corrected_rating"))
+	if ratingLevel(original.Rating) < 0 || ratingLevel(corrected) < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Ratings must be one of: %s", strings.Join(ratingNames(), ", "))})
+		return
+	}
+
+	correction := Correction{
+		Time:            time.Now().UTC(),
+		Tenant:          requestTenant(c),
+		Video:           c.PostForm("video"),
+		Start:           original.Start,
+		End:             original.End,
+		OriginalRating:  original.Rating,
+		OriginalNotes:   original.Notes,
+		CorrectedRating: corrected,
+		CorrectedNotes:  c.PostForm("corrected_notes"),
+		Reviewer:        c.PostForm("reviewer"),
+		FrameHash:       strings.ToLower(c.PostForm("frame_hash")),
+	}
+
+	if file, err := c.FormFile("frame"); err == nil {
+		correction.FrameHash, err = saveCorrectionFrame(file)
+		if err != nil {
+			respondCorrectionError(c, err)
+			return
+		}
+	} else if correction.FrameHash == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A frame image or frame_hash is required"})
+		return
+	} else if !validFrameHash.MatchString(correction.FrameHash) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "frame_hash must be a hex SHA-256"})
+		return
+	}
+
+	if err := appendCorrection(correction); err != nil {
+		respondCorrectionError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, correction)
+}
+
+func respondCorrectionError(c *gin.Context, err error) {
+	if isDiskFull(err) {
+		c.JSON(http.StatusInsufficientStorage, gin.H{"error": "Not enough disk space to store the correction", "code": "INSUFFICIENT_STORAGE"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to store correction: %v", err)})
+}
+
+// saveCorrectionFrame stores an uploaded frame image in
+// CORRECTIONS_FRAMES_DIR, named by its SHA-256 so repeated uploads of the
+// same frame are kept once, and returns the hash.
+func saveCorrectionFrame(file *multipart.FileHeader) (string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	if err := os.MkdirAll(config.CorrectionsFramesDir, os.ModePerm); err != nil {
+		return "", err
+	}
+	path := filepath.Join(config.CorrectionsFramesDir, hash+strings.ToLower(filepath.Ext(file.Filename)))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return hash, nil
+}
+
+// appendCorrection adds correction as one JSON line to CORRECTIONS_FILE.
+func appendCorrection(correction Correction) error {
+	line, err := json.Marshal(correction)
+	if err != nil {
+		return err
+	}
+
+	correctionsMu.Lock()
+	defer correctionsMu.Unlock()
+
+	f, err := os.OpenFile(config.CorrectionsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errJobTimeout is the cause of a processing context that ran past its
+// deadline. It is a context.DeadlineExceeded.
+var errJobTimeout = fmt.Errorf("job timeout: %w", context.DeadlineExceeded)
+
+// errFrameTimeout is returned for a single OpenAI analysis call that ran
+// past OPENAI_CALL_TIMEOUT, while the job itself still had time left.
+var errFrameTimeout = errors.New("frame timeout")
+
+// processingContext returns the context bounding one request's analysis or
+// conversion. It ends when the client goes away or PROCESSING_TIMEOUT
+// elapses. A per-request timeout (a Go duration such as "90s") can shorten
+// the deadline but never extend it past the configured limit.
+//
+// The context carries the request's OpenAI billing (see withOpenAIBilling)
+// and analyzers (see withAnalyzerChain).
+// The request is registered as a job that DELETE /jobs/:jobid can cancel,
+// and its ID is set in the jobIDHeader response header. The returned
+// cancel also marks the job done.
+func processingContext(c *gin.Context, requested string) (context.Context, context.CancelFunc, error) {
+	return newProcessingContext(c, c.Request.Context(), requested)
+}
+
+// detachedProcessingContext is processingContext for a job that outlives
+// its request: it does not end when the client goes away.
+func detachedProcessingContext(c *gin.Context, requested string) (context.Context, context.CancelFunc, error) {
+	return newProcessingContext(c, context.WithoutCancel(c.Request.Context()), requested)
+}
+
+func newProcessingContext(c *gin.Context, parent context.Context, requested string) (context.Context, context.CancelFunc, error) {
+	timeout := config.ProcessingTimeout
+	if requested != "" {
+		d, err := time.ParseDuration(requested)
+		if err != nil || d <= 0 {
+			return nil, nil, fmt.Errorf("invalid timeout: %s", requested)
+		}
+		if timeout <= 0 || d < timeout {
+			timeout = d
+		}
+	}
+
+	parent, err := withAnalyzerChain(withOpenAIBilling(parent, c), c)
+	if err != nil {
+		return nil, nil, err
+	}
+	jobCtx, cancelCause := context.WithCancelCause(parent)
+	id, err := registerJob(c, cancelCause)
+	if err != nil {
+		cancelCause(nil)
+		return nil, nil, err
+	}
+	c.Header(jobIDHeader, id)
+
+	ctx, cancelTimeout := context.WithValue(jobCtx, jobIDKey{}, id), context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, timeout, errJobTimeout)
+	}
+	cancel := func() {
+		finishJob(id)
+		cancelTimeout()
+		cancelCause(nil)
+	}
+	return ctx, cancel, nil
+}
+
+// checkContext returns a processing error once ctx is done, for the
+// per-frame loops to stop on.
+func checkContext(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("processing aborted: %w", context.Cause(ctx))
+	}
+	return nil
+}
+
+// respondProcessingError answers a failed analysis or conversion, with 504
+// TIMEOUT when it ran past its deadline, 504 FRAME_TIMEOUT when a single
+// analysis call did, 503 PROVIDER_UNAVAILABLE when the analysis provider's
+// circuit breaker is open, 409 CANCELLED when its job was cancelled and 507
+// when the disk filled up.
+func respondProcessingError(c *gin.Context, err error) {
+	c.JSON(processingErrorResponse(err))
+}
+
+// processingErrorResponse is the status and body respondProcessingError
+// answers err with.
+func processingErrorResponse(err error) (int, gin.H) {
+	if errors.Is(err, errJobCancelled) {
+		return http.StatusConflict, gin.H{"error": "Job was cancelled", "code": "CANCELLED"}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout, gin.H{"error": "Processing exceeded its deadline (job timeout)", "code": "TIMEOUT"}
+	}
+	if errors.Is(err, errFrameTimeout) {
+		return http.StatusGatewayTimeout, gin.H{"error": err.Error(), "code": "FRAME_TIMEOUT"}
+	}
+	if errors.Is(err, errProviderDown) {
+		return http.StatusServiceUnavailable, gin.H{"error": err.Error(), "code": "PROVIDER_UNAVAILABLE"}
+	}
+	if isDiskFull(err) {
+		return http.StatusInsufficientStorage, gin.H{"error": "Not enough disk space to write the output", "code": "INSUFFICIENT_STORAGE"}
+	}
+	return http.StatusInternalServerError, gin.H{"error": err.Error()}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"gocv.io/x/gocv"
+)
+
+// Colors of the debug output, which marks what a censor run would touch
+// instead of censoring it.
+var (
+	// debugBlurColor marks blurred frames and regions.
+	debugBlurColor = color.RGBA{R: 255, A: 255}
+	// debugRampColor marks frames blurred more lightly by BLUR_RAMP.
+	debugRampColor = color.RGBA{R: 255, G: 165, A: 255}
+	// debugUncertainColor marks frames replaced by the fail-safe frame.
+	debugUncertainColor = color.RGBA{R: 255, G: 255, A: 255}
+	// debugTrimColor marks frames a trim output would cut.
+	debugTrimColor = color.RGBA{B: 255, A: 255}
+)
+
+// debugBorder is the width, in pixels, of the border drawn around marked
+// frames and regions.
+const debugBorder = 8
+
+// markFrame copies img to dst with the whole frame tinted and framed in c,
+// and returns dst.
+func markFrame(img gocv.Mat, dst *gocv.Mat, c color.RGBA) gocv.Mat {
+	img.CopyTo(dst)
+	markRect(dst, image.Rect(0, 0, dst.Cols(), dst.Rows()), c)
+	return *dst
+}
+
+// markRegions tints and frames each of regions of img in debugBlurColor.
+func markRegions(img *gocv.Mat, regions []BlurRegion) {
+	for _, region := range regions {
+		if rect := region.rect(img.Cols(), img.Rows()); !rect.Empty() {
+			markRect(img, rect, debugBlurColor)
+		}
+	}
+}
+
+// markRect tints rect of img with c and draws a border around it.
+func markRect(img *gocv.Mat, rect image.Rectangle, c color.RGBA) {
+	roi := img.Region(rect)
+	tint := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(float64(c.B), float64(c.G), float64(c.R), 0), roi.Rows(), roi.Cols(), roi.Type())
+	gocv.AddWeighted(roi, 0.6, tint, 0.4, 0, &roi)
+	tint.Close()
+	roi.Close()
+	gocv.Rectangle(img, rect.Inset(debugBorder/2), c, debugBorder)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isDiskFull reports whether err comes from running out of disk space,
+// either directly (ENOSPC) or in the output of an ffmpeg run.
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) ||
+		strings.Contains(strings.ToLower(err.Error()), "no space left on device")
+}
+
+// respondSaveError answers a failed upload save, removing the partial file
+// at path. Running out of disk space is reported as 507.
+func respondSaveError(c *gin.Context, path string, err error) {
+	os.Remove(path)
+	if isDiskFull(err) {
+		c.JSON(http.StatusInsufficientStorage, gin.H{"error": "Not enough disk space to store the upload", "code": "INSUFFICIENT_STORAGE"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save video file"})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+const projectionEquirectangular = "equirectangular"
+
+// equirectBlurKernel matches the kernel used for flat frames; it is also
+// how far the frame is wrapped around the seam so the kernel sees the
+// pixels from the other edge.
+const equirectBlurKernel = 45
+
+// wrapSeam returns img with equirectBlurKernel columns from each edge
+// copied onto the opposite side, so blurs near the left/right seam of an
+// equirectangular frame take their neighbours from across the seam.
+func wrapSeam(img gocv.Mat) gocv.Mat {
+	padded := gocv.NewMat()
+	gocv.CopyMakeBorder(img, &padded, 0, 0, equirectBlurKernel, equirectBlurKernel, gocv.BorderWrap, color.RGBA{})
+	return padded
+}
+
+// blurEquirect blurs the whole equirectangular frame img into dst without
+// leaving a visible seam where the left and right edges meet.
+func blurEquirect(img gocv.Mat, dst *gocv.Mat) {
+	padded := wrapSeam(img)
+	defer padded.Close()
+
+	gocv.GaussianBlur(padded, &padded, image.Point{X: equirectBlurKernel, Y: equirectBlurKernel}, 0, 0, gocv.BorderDefault)
+	center := padded.Region(image.Rect(equirectBlurKernel, 0, equirectBlurKernel+img.Cols(), img.Rows()))
+	center.CopyTo(dst)
+	center.Close()
+}
+
+// blurEquirectRegions is blurRegions for equirectangular frames: regions
+// are widened towards the poles, split where they cross the seam and
+// blurred with context from the other side of it.
+func blurEquirectRegions(img *gocv.Mat, regions []BlurRegion) {
+	padded := wrapSeam(*img)
+	defer padded.Close()
+
+	offset := image.Point{X: equirectBlurKernel}
+	for _, region := range equirectRegions(regions) {
+		rect := region.rect(img.Cols(), img.Rows())
+		if rect.Empty() {
+			continue
+		}
+		roi := padded.Region(rect.Add(offset))
+		gocv.GaussianBlur(roi, &roi, image.Point{X: equirectBlurKernel, Y: equirectBlurKernel}, 0, 0, gocv.BorderDefault)
+		dst := img.Region(rect)
+		roi.CopyTo(&dst)
+		dst.Close()
+		roi.Close()
+	}
+}
+
+// equirectRegions adjusts regions detected on an equirectangular frame.
+// Content is stretched horizontally by 1/cos(latitude), so each region is
+// widened by that factor at its most polar edge (up to the full width).
+// Regions that then extend past the left or right edge wrap around to the
+// other side.
+func equirectRegions(regions []BlurRegion) []BlurRegion {
+	var result []BlurRegion
+	for _, r := range regions {
+		// Latitude of the edge nearest a pole, in radians.
+		edge := math.Max(math.Abs(r.Y-0.5), math.Abs(r.Y+r.Height-0.5))
+		stretch := 1 / math.Max(math.Cos(math.Min(edge, 0.5)*math.Pi), 0.01)
+
+		width := math.Min(r.Width*stretch, 1)
+		x := r.X + r.Width/2 - width/2
+		r.X, r.Width = x, width
+
+		switch {
+		case width >= 1:
+			r.X = 0
+			result = append(result, r)
+		case x < 0:
+			left := r
+			left.X, left.Width = 0, x+width
+			right := r
+			right.X, right.Width = 1+x, -x
+			result = append(result, left, right)
+		case x+width > 1:
+			left := r
+			left.X, left.Width = 0, x+width-1
+			right := r
+			right.Width = 1 - x
+			result = append(result, left, right)
+		default:
+			result = append(result, r)
+		}
+	}
+	return result
+}
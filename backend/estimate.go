@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// analysisSampleInterval is the spacing, in seconds, between frames
+// processVideo sends to OpenAI at SAMPLES_PER_SECOND=1.
+const analysisSampleInterval = 1.0
+
+type EstimateRequest struct {
+	// APIKey is checked instead of the server's OPENAI_API_KEY when set.
+	APIKey         string  `json:"api_key"`
+	Duration       float64 `json:"duration" binding:"required,gt=0"`
+	SampleInterval float64 `json:"sample_interval"`
+}
+
+// estimateAnalysis handles POST /estimate. It checks the API key with a
+// free model-list call and estimates how many frame calls, and how much,
+// analyzing a video of the given duration would cost.
+func estimateAnalysis(c *gin.Context) {
+	var request EstimateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request format: %v", err)})
+		return
+	}
+
+	interval := request.SampleInterval
+	if interval <= 0 {
+		interval = analysisSampleInterval / float64(max(config.SamplesPerSecond, 1))
+	}
+
+	apiKey := request.APIKey
+	if apiKey == "" {
+		apiKey = os.Getenv("OPENAI_API_KEY")
+	}
+
+	keyValid, keyError := true, ""
+	if err := checkOpenAIKey(c, apiKey); err != nil {
+		keyValid, keyError = false, err.Error()
+	}
+
+	calls := int(math.Ceil(request.Duration / interval))
+	response := gin.H{
+		"key_valid":       keyValid,
+		"duration":        request.Duration,
+		"sample_interval": interval,
+		"frame_calls":     calls,
+		"cost_per_call":   config.OpenAICostPerCall,
+		"estimated_cost":  float64(calls) * config.OpenAICostPerCall,
+		"currency":        "USD",
+	}
+	if keyError != "" {
+		response["key_error"] = keyError
+	}
+	if preFilter != nil {
+		response["note"] = "The local pre-filter can skip OpenAI entirely for clean videos, so this is an upper bound"
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// checkOpenAIKey confirms apiKey is accepted by OpenAI by listing models,
+// which is not billed.
+func checkOpenAIKey(c *gin.Context, apiKey string) error {
+	if apiKey == "" {
+		return fmt.Errorf("no API key provided")
+	}
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, "https://api.openai.com/v1/models", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := openAIClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach OpenAI: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("OpenAI rejected the key (status %d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"image"
+	"log"
+	"strconv"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// sampledSecond is how long a rating holds past its segment's End: /upload
+// ends a segment at the start of its last sampled second, so the rating
+// covers that whole second.
+const sampledSecond = 1.0
+
+// uncertainAt reports whether the ratings leave the moment at timestamp
+// unclassified: no segment covers it, or a covering segment has no valid
+// rating (an analysis error or refusal) or a confidence below threshold.
+func uncertainAt(ratings []RatingResult, timestamp, threshold float64) bool {
+	covered := false
+	for _, rating := range ratings {
+		if timestamp < rating.Start || timestamp >= rating.End+sampledSecond {
+			continue
+		}
+		covered = true
+		if ratingLevel(rating.Rating) < 0 {
+			return true
+		}
+		if rating.Confidence != nil && *rating.Confidence < threshold {
+			return true
+		}
+	}
+	return !covered
+}
+
+// placeholderFrame is the safe frame fail-safe renders show in place of
+// unclassified content: FAIL_SAFE_IMAGE scaled to the frame, or a solid
+// FAIL_SAFE_COLOR. It is built on first use, at the size of the video.
+type placeholderFrame struct {
+	mat   gocv.Mat
+	ready bool
+}
+
+// Close is safe on a nil placeholder, which is what non-fail-safe
+// renders use.
+func (p *placeholderFrame) Close() {
+	if p != nil && p.ready {
+		p.mat.Close()
+	}
+}
+
+// frame returns the placeholder at the size and type of img.
+func (p *placeholderFrame) frame(img gocv.Mat) gocv.Mat {
+	if p.ready {
+		return p.mat
+	}
+	p.ready = true
+
+	if config.FailSafeImage != "" {
+		card := gocv.IMRead(config.FailSafeImage, gocv.IMReadColor)
+		if !card.Empty() {
+			p.mat = gocv.NewMat()
+			gocv.Resize(card, &p.mat, image.Point{X: img.Cols(), Y: img.Rows()}, 0, 0, gocv.InterpolationArea)
+			card.Close()
+			return p.mat
+		}
+		card.Close()
+		log.Printf("Failed to read FAIL_SAFE_IMAGE %s, using FAIL_SAFE_COLOR", config.FailSafeImage)
+	}
+
+	r, g, b := parseHexColor(config.FailSafeColor)
+	p.mat = gocv.NewMatWithSizeFromScalar(gocv.NewScalar(b, g, r, 0), img.Rows(), img.Cols(), img.Type())
+	return p.mat
+}
+
+// parseHexColor parses an "RRGGBB" color, with or without a leading "#".
+// Invalid colors are black.
+func parseHexColor(hex string) (r, g, b float64) {
+	value, err := strconv.ParseUint(strings.TrimPrefix(hex, "#"), 16, 32)
+	if err != nil || len(strings.TrimPrefix(hex, "#")) != 6 {
+		return 0, 0, 0
+	}
+	return float64(value >> 16 & 0xff), float64(value >> 8 & 0xff), float64(value & 0xff)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"gocv.io/x/gocv"
+)
+
+// Fallback policies applied when OpenAI can't rate a frame.
+const (
+	fallbackFail         = "fail"
+	fallbackLocal        = "local"
+	fallbackConservative = "conservative"
+)
+
+var errOpenAIDown = errors.New("OpenAI is unavailable")
+
+// fallbackRating rates img according to FALLBACK_POLICY after an OpenAI
+// failure. With the "fail" policy the original error is returned.
+func fallbackRating(img gocv.Mat, analyzeErr error) (RatingData, error) {
+	switch config.FallbackPolicy {
+	case fallbackConservative:
+		return RatingData{Rating: config.FallbackRating, Notes: "unanalyzed"}, nil
+	case fallbackLocal:
+		score, err := localClassifier.score(img)
+		if err != nil {
+			return RatingData{}, fmt.Errorf("local fallback failed: %v (after %v)", err, analyzeErr)
+		}
+		return RatingData{Rating: classifierRating(score), Notes: "local classifier"}, nil
+	default:
+		return RatingData{}, analyzeErr
+	}
+}
+
+// classifierRating maps a local classifier's unsafe score to a rating.
+func classifierRating(score float64) string {
+	switch {
+	case score < config.PrefilterThreshold:
+		return mildestRating()
+	case score < 0.5:
+		return ratingAtMost(12)
+	case score < 0.8:
+		return ratingAtMost(16)
+	default:
+		return strictestRating()
+	}
+}
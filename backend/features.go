@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// features handles GET /features. It reports what this deployment has
+// enabled, derived from the effective config, so clients can hide options
+// the backend cannot fulfill. It never includes secrets or paths.
+func features(c *gin.Context) {
+	presetNames := make([]string, 0, len(presets))
+	for name := range presets {
+		presetNames = append(presetNames, name)
+	}
+	sort.Strings(presetNames)
+
+	c.JSON(http.StatusOK, gin.H{
+		"audio_mux":          config.AudioMux,
+		"ocr":                config.OCREnabled,
+		"profanity":          config.WhisperMode != "",
+		"prefilter":          preFilter != nil,
+		"analyzers":          config.AnalyzerChain,
+		"analyzer_providers": availableAnalyzers(),
+		"fallback_policy":    config.FallbackPolicy,
+		"object_storage":     config.StorageBackend != "",
+		"chunked_encoding":   config.EncodeChunks > 1,
+		"shot_snapping":      config.ShotSnapWindow > 0,
+		"skip_blank_frames":  config.SkipBlankFrames,
+		"verify_output":      config.VerifyOutput,
+		"copy_metadata":      config.CopyMetadata,
+		"embed_ratings":      config.EmbedRatings,
+		"skip_processed":     config.SkipProcessedUploads,
+		"tenant_required":    config.TenantRequired,
+		"image_input":        len(config.AllowedImageExtensions) > 0,
+		"processing_timeout": config.ProcessingTimeout.String(),
+		"samples_per_second": config.SamplesPerSecond,
+		"sampling_mode":      config.SamplingMode,
+		"video_types":        []string{"blur", "trim", "none"},
+		"blur_modes":         []string{blurModeFrame, blurModeMotion},
+		"result_formats":     []string{ratingsFormatJSON, ratingsFormatVTT, ratingsFormatSRT},
+		"ratings":            ratingNames(),
+		"ages":               allowedAges(),
+		"presets":            presetNames,
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gocv.io/x/gocv"
+)
+
+// fewShotExample is a labeled image shown to the model before each frame
+// to calibrate its ratings of borderline content.
+type fewShotExample struct {
+	Image  string `json:"image"`
+	Rating string `json:"rating"`
+	Notes  string `json:"notes"`
+
+	// dataURL is the image, encoded like an analysis frame.
+	dataURL string
+	// answer is the model response the example stands for.
+	answer string
+}
+
+// fewShotPrompt accompanies each example image.
+const fewShotPrompt = "Rate this example image."
+
+// fewShotExamples holds the examples loaded from FEW_SHOT_FILE.
+var fewShotExamples []fewShotExample
+
+// loadFewShotExamples reads a JSON array of examples from path and encodes
+// their images once. Image paths are relative to the file. An empty path
+// means no examples are configured.
+func loadFewShotExamples(path string) ([]fewShotExample, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read few-shot examples: %v", err)
+	}
+
+	var examples []fewShotExample
+	if err := json.Unmarshal(data, &examples); err != nil {
+		return nil, fmt.Errorf("failed to parse few-shot examples: %v", err)
+	}
+
+	for i := range examples {
+		example := &examples[i]
+		if ratingLevel(example.Rating) < 0 {
+			return nil, fmt.Errorf("few-shot example %d: invalid rating %q", i, example.Rating)
+		}
+
+		imagePath := example.Image
+		if !filepath.IsAbs(imagePath) {
+			imagePath = filepath.Join(filepath.Dir(path), imagePath)
+		}
+		img := gocv.IMRead(imagePath, gocv.IMReadColor)
+		if img.Empty() {
+			img.Close()
+			return nil, fmt.Errorf("few-shot example %d: failed to read image %s", i, imagePath)
+		}
+		example.dataURL, err = encodeSample(img, config.ResizeInterpolation, config.AnalysisResolution)
+		img.Close()
+		if err != nil {
+			return nil, fmt.Errorf("few-shot example %d: failed to encode image: %v", i, err)
+		}
+
+		answer, _ := json.Marshal(map[string]interface{}{
+			"rating":     example.Rating,
+			"notes":      example.Notes,
+			"confidence": 1,
+		})
+		example.answer = string(answer)
+	}
+	return examples, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ProbeStream describes one stream reported by ffprobe.
+type ProbeStream struct {
+	Index     int    `json:"index"`
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+
+	Disposition struct {
+		// AttachedPic marks cover art, such as an MP3's album image.
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+}
+
+// probeStreams lists the streams in a media file using ffprobe.
+func probeStreams(videoPath string) ([]ProbeStream, error) {
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-show_entries", "stream=index,codec_type,codec_name,width,height:stream_disposition=attached_pic",
+		"-of", "json",
+		videoPath,
+	)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe video: %v", err)
+	}
+
+	var probe struct {
+		Streams []ProbeStream `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %v", err)
+	}
+
+	return probe.Streams, nil
+}
+
+// probeFormatTags returns the container-level metadata tags of a media
+// file, with keys lowercased.
+func probeFormatTags(path string) (map[string]string, error) {
+	output, err := exec.Command("ffprobe",
+		"-v", "error",
+		"-show_entries", "format_tags",
+		"-of", "json",
+		path,
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe metadata: %v", err)
+	}
+
+	var probe struct {
+		Format struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %v", err)
+	}
+
+	tags := make(map[string]string, len(probe.Format.Tags))
+	for key, value := range probe.Format.Tags {
+		tags[strings.ToLower(key)] = value
+	}
+	return tags, nil
+}
+
+// videoStreams filters streams down to the video streams, in file order.
+func videoStreams(streams []ProbeStream) []ProbeStream {
+	var result []ProbeStream
+	for _, stream := range streams {
+		if stream.CodecType == "video" {
+			result = append(result, stream)
+		}
+	}
+	return result
+}
+
+// probeVideoCodec returns the codec and pixel format of the first video
+// stream of path.
+func probeVideoCodec(path string) (string, string, error) {
+	output, err := exec.Command("ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=codec_name,pix_fmt",
+		"-of", "default=noprint_wrappers=1",
+		path,
+	).Output()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to probe video codec: %v", err)
+	}
+
+	var codec, pixFmt string
+	for _, line := range strings.Split(string(output), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "codec_name":
+			codec = value
+		case "pix_fmt":
+			pixFmt = value
+		}
+	}
+	return codec, pixFmt, nil
+}
+
+// countVideoPackets counts the packets in the first video stream, which
+// for the formats accepted here is the number of frames.
+func countVideoPackets(path string) (int, error) {
+	output, err := exec.Command("ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-count_packets",
+		"-show_entries", "stream=nb_read_packets",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	).Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count frames: %v", err)
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse frame count %q", strings.TrimSpace(string(output)))
+	}
+	return count, nil
+}
+
+// extractVideoStream copies the streamIndex-th video stream (counting only
+// video streams) into its own file next to videoPath, since gocv always
+// opens the container's default stream. The first audio stream, if any, is
+// kept so it can be muxed back into the output. The caller removes the new
+// file.
+func extractVideoStream(videoPath string, streamIndex int) (string, error) {
+	streams, err := probeStreams(videoPath)
+	if err != nil {
+		return "", err
+	}
+
+	available := videoStreams(streams)
+	if streamIndex < 0 || streamIndex >= len(available) {
+		return "", fmt.Errorf("video stream %d not found, file has %d video stream(s)", streamIndex, len(available))
+	}
+
+	ext := filepath.Ext(videoPath)
+	outputPath := strings.TrimSuffix(videoPath, ext) + fmt.Sprintf("_stream%d%s", streamIndex, ext)
+
+	cmd := exec.Command("ffmpeg",
+		"-y",
+		"-v", "error",
+		"-i", videoPath,
+		"-map", fmt.Sprintf("0:v:%d", streamIndex),
+		"-map", "0:a:0?",
+		"-c", "copy",
+		outputPath,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("failed to extract video stream %d: %v: %s", streamIndex, err, strings.TrimSpace(string(output)))
+	}
+
+	return outputPath, nil
+}
+
+// absolutePath resolves path against the working directory, for ffmpeg
+// inputs such as concat lists that are read from another location.
+func absolutePath(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %v", path, err)
+	}
+	return absPath, nil
+}
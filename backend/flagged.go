@@ -0,0 +1,161 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gocv.io/x/gocv"
+)
+
+// Modes of /convert's export_frames.
+const (
+	exportFramesAll     = "all"
+	exportFramesSegment = "segment"
+)
+
+// frameExporter saves the censored source frames seen by a conversion's
+// decode pass as JPEGs, for reviewers to audit, and bundles them into a
+// zip next to the output. It is shared by every chunk of a chunked encode
+// and every style of a multi-style render. A nil *frameExporter ignores
+// frames.
+type frameExporter struct {
+	mu sync.Mutex
+	// dir holds the JPEGs until they are archived.
+	dir string
+	// perSegment keeps only the first frame of each censored segment.
+	perSegment bool
+	exported   map[float64]bool
+	zipPath    string
+}
+
+// newFrameExporter returns an exporter for mode, or nil if mode is empty.
+// Frames are kept in a temporary folder inside outputDir until archived.
+func newFrameExporter(mode, outputDir string) (*frameExporter, error) {
+	if mode == "" {
+		return nil, nil
+	}
+	dir, err := os.MkdirTemp(outputDir, "flagged_*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create flagged frames folder: %v", err)
+	}
+	return &frameExporter{dir: dir, perSegment: mode == exportFramesSegment, exported: make(map[float64]bool)}, nil
+}
+
+// add saves img, the source frame at timestamp, if it is censored for age.
+// The file name holds the timestamp and rating, e.g. 0012.500s_16+.jpg.
+func (e *frameExporter) add(img gocv.Mat, ratings []RatingResult, age int, timestamp float64) {
+	if e == nil {
+		return
+	}
+	rating, ok := censoredRatingAt(ratings, age, timestamp)
+	if !ok {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := timestamp
+	if e.perSegment {
+		key = rating.Start
+	}
+	if e.exported[key] {
+		return
+	}
+	e.exported[key] = true
+
+	name := fmt.Sprintf("%08.3fs_%s.jpg", timestamp, frameNameRating(rating.Rating))
+	if !gocv.IMWrite(filepath.Join(e.dir, name), img) {
+		log.Printf("Failed to export flagged frame at %.3fs", timestamp)
+	}
+}
+
+// archive bundles the saved frames into a zip named after outputPath and
+// returns its path. Later calls return the same zip.
+func (e *frameExporter) archive(outputPath string) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.zipPath != "" {
+		return e.zipPath, nil
+	}
+
+	entries, err := os.ReadDir(e.dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read flagged frames: %v", err)
+	}
+	zipPath := strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + "_flagged.zip"
+	f, err := os.Create(zipPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create flagged frames archive: %v", err)
+	}
+
+	// JPEGs are already compressed, so they are stored as is.
+	w := zip.NewWriter(f)
+	for _, entry := range entries {
+		if err = addZipFile(w, filepath.Join(e.dir, entry.Name()), entry.Name()); err != nil {
+			break
+		}
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(zipPath)
+		return "", fmt.Errorf("failed to write flagged frames archive: %v", err)
+	}
+
+	processedFiles.record(processedKey(zipPath))
+	e.zipPath = zipPath
+	return zipPath, nil
+}
+
+// Close removes the saved frames.
+func (e *frameExporter) Close() {
+	if e != nil {
+		os.RemoveAll(e.dir)
+	}
+}
+
+func addZipFile(w *zip.Writer, path, name string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// censoredRatingAt returns the segment that censors the frame at timestamp
+// for age, if any.
+func censoredRatingAt(ratings []RatingResult, age int, timestamp float64) (RatingResult, bool) {
+	for _, rating := range ratings {
+		if rating.covers(timestamp) && getRatingValue(rating.Rating) > age {
+			return rating, true
+		}
+	}
+	return RatingResult{}, false
+}
+
+// frameNameRating makes a rating safe for a file name, keeping the "+" of
+// names like 16+.
+func frameNameRating(rating string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '+' || r == '-' || r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' {
+			return r
+		}
+		return '_'
+	}, rating)
+}
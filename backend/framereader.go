@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+
+	"gocv.io/x/gocv"
+)
+
+// readFrame reads the next frame of video into img, tolerating transient
+// decode failures. frameIndex is the index of the frame being read and
+// endFrame the index to stop at (0 if unknown). A failed read before
+// endFrame is treated as a lost frame and the read is retried, up to
+// FRAME_READ_MAX_FAILURES consecutive failures. It returns how many frames
+// were lost before img, and false once no more frames can be read.
+//
+// endFrame usually comes from CAP_PROP_FRAME_COUNT, which can overestimate,
+// so failed reads that never move the capture past frameIndex are the end
+// of the stream rather than lost frames and are not reported as truncation.
+func readFrame(video *gocv.VideoCapture, img *gocv.Mat, frameIndex, endFrame int) (int, bool) {
+	lost := 0
+	start := video.Get(gocv.VideoCapturePosFrames)
+	for {
+		if ok := video.Read(img); ok && !img.Empty() {
+			if lost > 0 {
+				log.Printf("Skipped %d unreadable frame(s) at frame %d", lost, frameIndex)
+			}
+			return lost, true
+		}
+
+		if endFrame > 0 && frameIndex+lost+1 >= endFrame {
+			return lost, false
+		}
+		lost++
+		if lost > config.FrameReadMaxFailures {
+			if video.Get(gocv.VideoCapturePosFrames) <= start {
+				return lost, false
+			}
+			log.Printf("Giving up after %d consecutive unreadable frames at frame %d, output is truncated", lost, frameIndex)
+			return lost, false
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Output formats for /convert.
+const (
+	outputFormatMP4 = "mp4"
+	outputFormatHLS = "hls"
+)
+
+func init() {
+	mime.AddExtensionType(".m3u8", "application/vnd.apple.mpegurl")
+	mime.AddExtensionType(".ts", "video/mp2t")
+}
+
+// hlsSegments returns the segment files of the playlist at playlistPath.
+func hlsSegments(playlistPath string) []string {
+	base := strings.TrimSuffix(playlistPath, filepath.Ext(playlistPath))
+	matches, _ := filepath.Glob(base + "_[0-9][0-9][0-9][0-9][0-9].ts")
+	return matches
+}
+
+// segmentHLS turns the finished MP4 at outputPath into an HLS playlist and
+// MPEG-TS segments next to it, then removes the MP4. Segments are named
+// after the playlist and referenced relatively, so they download from the
+// same place. It returns the playlist path.
+func segmentHLS(outputPath string) (string, error) {
+	base := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
+	playlistPath := base + ".m3u8"
+	segmentPattern := base + "_%05d.ts"
+
+	// HLS players only play H.264 reliably, so other codecs from the
+	// VIDEO_CODECS fallback list are re-encoded.
+	codecArgs := []string{"-c", "copy"}
+	streams, err := probeStreams(outputPath)
+	if err != nil {
+		return "", err
+	}
+	for _, stream := range streams {
+		if stream.CodecType == "video" && stream.CodecName != "h264" {
+			codecArgs = []string{"-c:v", "libx264", "-preset", "veryfast", "-c:a", "aac"}
+			break
+		}
+	}
+
+	args := []string{"-y", "-v", "error", "-i", outputPath, "-map", "0"}
+	args = append(args, codecArgs...)
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(config.HLSSegmentDuration),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", segmentPattern,
+		playlistPath,
+	)
+
+	segments := func() []string {
+		return hlsSegments(playlistPath)
+	}
+	if output, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+		os.Remove(playlistPath)
+		for _, segment := range segments() {
+			os.Remove(segment)
+		}
+		return "", fmt.Errorf("failed to segment output for HLS: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	for _, segment := range segments() {
+		processedFiles.record(processedKey(segment))
+	}
+	os.Remove(outputPath)
+	return playlistPath, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// openAIClient is shared by all OpenAI calls so connections are reused and
+// proxy/TLS settings apply consistently.
+var openAIClient = &http.Client{}
+
+// newOpenAIClient builds the OpenAI HTTP client. Requests go through
+// OPENAI_PROXY_URL when set and otherwise honor HTTPS_PROXY/HTTP_PROXY/
+// NO_PROXY. OPENAI_CA_FILE adds a PEM bundle (e.g. a corporate TLS
+// inspection CA) to the system roots.
+func newOpenAIClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	if config.OpenAIProxyURL != "" {
+		proxyURL, err := url.Parse(config.OpenAIProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OPENAI_PROXY_URL: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.OpenAICAFile != "" {
+		pem, err := os.ReadFile(config.OpenAICAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OPENAI_CA_FILE: %v", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.OpenAICAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
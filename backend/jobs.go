@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jobIDHeader carries a processing request's job ID. Clients may set it to
+// choose the ID up front, so a request can be cancelled before it answers;
+// the server always echoes it on the response.
+const jobIDHeader = "X-Job-ID"
+
+// Statuses of a job. A job is queued until it gets a slot of its first
+// processing phase (see phaseLimit), then analyzing or encoding until it
+// finishes.
+const (
+	jobQueued    = "queued"
+	jobAnalyzing = "analyzing"
+	jobEncoding  = "encoding"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// errJobCancelled is the cause of a processing context stopped through
+// DELETE /jobs/:jobid.
+var errJobCancelled = errors.New("job was cancelled")
+
+var validJobID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// job is one in-flight or recently finished processing request.
+type job struct {
+	tenant   string
+	status   string
+	started  time.Time
+	finished time.Time
+	cancel   context.CancelCauseFunc
+	progress *jobProgress
+	// result and failure hold the response of a job run in the
+	// background, reported by GET /jobs/:jobid.
+	result  gin.H
+	failure gin.H
+	// ratings are the ratings the job produced or applied, and outputs
+	// the names of the files it wrote.
+	ratings []RatingResult
+	outputs []string
+}
+
+// running reports whether j has not finished yet.
+func (j *job) running() bool {
+	return j.status == jobQueued || j.status == jobAnalyzing || j.status == jobEncoding
+}
+
+// jobs holds every running job and finished jobs for JOB_RETENTION.
+var jobs = struct {
+	mu   sync.Mutex
+	byID map[string]*job
+}{byID: make(map[string]*job)}
+
+// registerJob starts tracking a processing request under the job ID from
+// its jobIDHeader, or a new one, and returns the ID. cancel stops the
+// request's processing context. The ID is rejected if a job still running,
+// or a job of another tenant, already uses it.
+func registerJob(c *gin.Context, cancel context.CancelCauseFunc) (string, error) {
+	id := c.GetHeader(jobIDHeader)
+	if id == "" {
+		id = newJobID()
+	} else if !validJobID.MatchString(id) {
+		return "", fmt.Errorf("invalid %s: %s", jobIDHeader, id)
+	}
+
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	pruneJobs()
+	existing, ok := jobs.byID[id]
+	if ok && existing.running() {
+		return "", fmt.Errorf("job %s is already running", id)
+	}
+	owner, owned := "", ok
+	if ok {
+		owner = existing.tenant
+	} else {
+		owner, owned = jobDB.tenant(id)
+	}
+	if owned && owner != requestTenant(c) {
+		return "", fmt.Errorf("job %s belongs to another tenant", id)
+	}
+	j := &job{
+		tenant:   requestTenant(c),
+		status:   jobQueued,
+		started:  time.Now(),
+		cancel:   cancel,
+		progress: &jobProgress{},
+	}
+	jobs.byID[id] = j
+	jobDB.create(id, j)
+	return id, nil
+}
+
+// finishJob marks a job done unless it was cancelled first.
+func finishJob(id string) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	if j, ok := jobs.byID[id]; ok && j.running() {
+		j.status = jobDone
+		j.finished = time.Now()
+		jobDB.update(id, j)
+	}
+}
+
+// recordJobResult finishes a background job with the response its
+// request would have answered: result on success, failure otherwise. A
+// cancelled job keeps its status.
+func recordJobResult(id string, result, failure gin.H) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	j, ok := jobs.byID[id]
+	if !ok || !j.running() {
+		return
+	}
+	j.status, j.result = jobDone, result
+	if failure != nil {
+		j.status, j.failure = jobFailed, failure
+	}
+	j.finished = time.Now()
+	jobDB.update(id, j)
+}
+
+type jobIDKey struct{}
+
+// setJobStatus moves the job whose processing context is ctx to status,
+// unless it has already finished. Contexts of no job are ignored.
+func setJobStatus(ctx context.Context, status string) {
+	id, ok := ctx.Value(jobIDKey{}).(string)
+	if !ok {
+		return
+	}
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	if j, ok := jobs.byID[id]; ok && j.running() {
+		j.status = status
+		jobDB.update(id, j)
+	}
+}
+
+// recordJobRatings keeps the ratings the job whose processing context is
+// ctx produced or applied. Contexts of no job are ignored.
+func recordJobRatings(ctx context.Context, ratings []RatingResult) {
+	id, ok := ctx.Value(jobIDKey{}).(string)
+	if !ok {
+		return
+	}
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	if j, ok := jobs.byID[id]; ok {
+		j.ratings = ratings
+		jobDB.saveRatings(id, ratings)
+	}
+}
+
+// recordJobOutputs keeps the files of result, written by the job whose
+// processing context is ctx.
+func recordJobOutputs(ctx context.Context, result *ConvertResult) {
+	id, ok := ctx.Value(jobIDKey{}).(string)
+	if !ok {
+		return
+	}
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	j, ok := jobs.byID[id]
+	if !ok {
+		return
+	}
+	for _, path := range result.paths() {
+		filename := filepath.Base(path)
+		j.outputs = append(j.outputs, filename)
+		jobDB.addOutput(id, filename)
+	}
+}
+
+// contextProgress returns the progress of the job whose processing context
+// is ctx, or a fresh one for a context of no job.
+func contextProgress(ctx context.Context) *jobProgress {
+	if id, ok := ctx.Value(jobIDKey{}).(string); ok {
+		jobs.mu.Lock()
+		defer jobs.mu.Unlock()
+		if j, ok := jobs.byID[id]; ok {
+			return j.progress
+		}
+	}
+	return &jobProgress{}
+}
+
+// pruneJobs forgets finished jobs older than JOB_RETENTION. Callers hold
+// jobs.mu.
+func pruneJobs() {
+	for id, j := range jobs.byID {
+		if !j.running() && time.Since(j.finished) > config.JobRetention {
+			delete(jobs.byID, id)
+		}
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestJob looks up the job named in the route for the request's tenant,
+// in memory or else in the store. Jobs of other tenants are reported as
+// not found.
+func requestJob(c *gin.Context) (*job, bool) {
+	j, ok := jobs.byID[c.Param("jobid")]
+	if !ok {
+		j, ok = jobDB.load(c.Param("jobid"))
+	}
+	if !ok || j.tenant != requestTenant(c) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found", "code": "NOT_FOUND"})
+		return nil, false
+	}
+	return j, true
+}
+
+func jobResponse(c *gin.Context, j *job) gin.H {
+	return jobSummary(c, c.Param("jobid"), j)
+}
+
+// jobSummary describes job id. Jobs read back from the store have no
+// progress.
+func jobSummary(c *gin.Context, id string, j *job) gin.H {
+	response := gin.H{
+		"job_id":     id,
+		"status":     j.status,
+		"started_at": j.started.UTC().Format(time.RFC3339),
+	}
+	if j.progress != nil {
+		response["progress"] = j.progress.report()
+	}
+	if !j.finished.IsZero() {
+		response["finished_at"] = j.finished.UTC().Format(time.RFC3339)
+	}
+	if j.result != nil {
+		response["result"] = j.result
+	}
+	if j.failure != nil {
+		response["failure"] = j.failure
+	}
+	if j.ratings != nil {
+		response["ratings"] = j.ratings
+	}
+	if len(j.outputs) > 0 {
+		outputs := make([]gin.H, len(j.outputs))
+		for i, filename := range j.outputs {
+			outputs[i] = gin.H{"filename": filename, "download_url": downloadURL(c, filename)}
+		}
+		response["outputs"] = outputs
+	}
+	return response
+}
+
+// getJob reports whether a job is queued, analyzing, encoding, done, failed
+// or cancelled, with the outcome of a background job once it has one.
+func getJob(c *gin.Context) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	j, ok := requestJob(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, jobResponse(c, j))
+}
+
+// Bounds of GET /jobs's limit.
+const (
+	defaultJobListLimit = 50
+	maxJobListLimit     = 500
+)
+
+// listJobs lists the request tenant's jobs, newest first, optionally only
+// those with the status query parameter. With a store, finished jobs are
+// listed for as long as they are stored; without one, for JOB_RETENTION.
+func listJobs(c *gin.Context) {
+	status := c.Query("status")
+	limit := defaultJobListLimit
+	if raw := c.Query("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > maxJobListLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid limit: %s (1 to %d)", raw, maxJobListLimit)})
+			return
+		}
+	}
+	tenant := requestTenant(c)
+
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	pruneJobs()
+
+	var found []storedJob
+	if jobDB != nil {
+		var err error
+		if found, err = jobDB.list(tenant, status, limit); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to list jobs: %v", err)})
+			return
+		}
+	} else {
+		for id, j := range jobs.byID {
+			if j.tenant == tenant && (status == "" || j.status == status) {
+				found = append(found, storedJob{id: id, job: j})
+			}
+		}
+		sort.Slice(found, func(a, b int) bool { return found[a].started.After(found[b].started) })
+		found = found[:min(len(found), limit)]
+	}
+
+	list := make([]gin.H, len(found))
+	for i, stored := range found {
+		// Jobs still in memory report their live progress.
+		j := stored.job
+		if live, ok := jobs.byID[stored.id]; ok {
+			j = live
+		}
+		list[i] = jobSummary(c, stored.id, j)
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": list})
+}
+
+// cancelJob stops a running job. Its processing loops return at the next
+// frame, partial outputs are removed as for any failed render, and the
+// request that started it answers 409 CANCELLED. Cancelling a finished job
+// is a conflict.
+func cancelJob(c *gin.Context) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	j, ok := requestJob(c)
+	if !ok {
+		return
+	}
+	if !j.running() {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Job is already %s", j.status), "code": "JOB_FINISHED"})
+		return
+	}
+	// A running job read back from the store is run by another server.
+	if j.cancel == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job is running on another server", "code": "JOB_ELSEWHERE"})
+		return
+	}
+	j.cancel(errJobCancelled)
+	j.status = jobCancelled
+	j.finished = time.Now()
+	jobDB.update(c.Param("jobid"), j)
+	c.JSON(http.StatusOK, jobResponse(c, j))
+}
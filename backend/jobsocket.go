@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// watchJob streams a job over a WebSocket: its status and progress every
+// progressInterval, as GET /jobs/:jobid reports them, until it finishes.
+// The last message is the finished job, with its result or failure, after
+// which the socket is closed. A job another server runs is read back from
+// the store every progressInterval instead.
+func watchJob(c *gin.Context) {
+	id := c.Param("jobid")
+	jobs.mu.Lock()
+	j, ok := requestJob(c)
+	stored := jobs.byID[id] != j
+	jobs.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	// The socket lasts as long as the job, so READ_TIMEOUT and
+	// WRITE_TIMEOUT do not apply to it.
+	controller := http.NewResponseController(c.Writer)
+	controller.SetReadDeadline(time.Time{})
+	controller.SetWriteDeadline(time.Time{})
+
+	// Origins are not checked, matching the CORS policy of the other
+	// routes.
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		// Clients send nothing; reading only notices when they go away.
+		gone := make(chan struct{})
+		go func() {
+			io.Copy(io.Discard, ws)
+			close(gone)
+		}()
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			if stored {
+				if reloaded, ok := jobDB.load(id); ok {
+					j = reloaded
+				}
+			}
+			jobs.mu.Lock()
+			update, running := jobResponse(c, j), j.running()
+			jobs.mu.Unlock()
+			if err := websocket.JSON.Send(ws, update); err != nil || !running {
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-gone:
+				return
+			}
+		}
+	}}
+	server.ServeHTTP(c.Writer, c.Request)
+}
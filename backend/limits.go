@@ -0,0 +1,57 @@
+package main
+
+import "context"
+
+// phaseLimit caps how many requests run one processing phase at once.
+// Analysis mostly waits on the network while encoding keeps CPU cores busy,
+// so each phase has its own limit. A request that gets a slot moves its job
+// to the phase's status. A nil *phaseLimit has no cap and leaves the job
+// status alone.
+type phaseLimit struct {
+	// status is the job status of requests in this phase.
+	status string
+	// slots is nil when the phase has no cap.
+	slots chan struct{}
+}
+
+// Limits of the analysis and encode phases, from MAX_CONCURRENT_ANALYSES
+// and MAX_CONCURRENT_ENCODES.
+var analysisLimit, encodeLimit *phaseLimit
+
+func newPhaseLimit(status string, n int) *phaseLimit {
+	l := &phaseLimit{status: status}
+	if n > 0 {
+		l.slots = make(chan struct{}, n)
+	}
+	return l
+}
+
+// analyzeStep is analyzeVideoFrom for one incremental /upload step, run
+// within the analysis limit.
+func analyzeStep(ctx context.Context, videoPath string, opts AnalysisOptions, cursor AnalysisCursor, final bool) (AnalysisCursor, error) {
+	release, err := analysisLimit.acquire(ctx)
+	if err != nil {
+		return cursor, err
+	}
+	defer release()
+	return analyzeVideoFrom(ctx, videoPath, opts, cursor, final)
+}
+
+// acquire waits for a free slot, or until ctx is done, and returns the
+// function that frees the slot again.
+func (l *phaseLimit) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	if l.slots == nil {
+		setJobStatus(ctx, l.status)
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		setJobStatus(ctx, l.status)
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, checkContext(ctx)
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// setupLogging installs a slog handler at level as the default logger.
+// The standard log package writes through it too, at info level, so
+// existing log calls are filtered by the same setting.
+func setupLogging(level string) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		l = slog.LevelInfo
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: l})))
+}
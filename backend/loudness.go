@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+// LoudSegment is a stretch of audio louder than the configured limits, for
+// young-audience standards that restrict sudden loud sound.
+type LoudSegment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	// MaxLUFS is the highest momentary (400ms) loudness in the segment.
+	MaxLUFS float64 `json:"max_lufs"`
+	// MaxPeak is the highest true peak in the segment, in dBTP.
+	MaxPeak float64 `json:"max_peak"`
+}
+
+// loudnessFloor stands in for the meter's -inf readings of silence, which
+// JSON cannot carry.
+const loudnessFloor = -120.0
+
+// ebur128Line matches the per-frame lines of ffmpeg's ebur128 filter, e.g.
+// "t: 1.2  TARGET:-23 LUFS    M: -18.3 S: ... FTPK: -3.1 -2.9 dBFS".
+var (
+	ebur128Time      = regexp.MustCompile(`\bt:\s*([0-9.]+)`)
+	ebur128Momentary = regexp.MustCompile(`\bM:\s*(-?[0-9.]+|-inf)`)
+	ebur128Peak      = regexp.MustCompile(`\bFTPK:((?:\s*-?[0-9.]+|\s*-inf)+)\s*dBFS`)
+)
+
+// analyzeLoudness measures the first audio stream of videoPath with
+// ffmpeg's EBU R128 meter and returns the stretches whose momentary
+// loudness exceeds LOUDNESS_MAX_LUFS or whose true peak exceeds
+// LOUDNESS_MAX_PEAK, merged at one-second granularity. A file without
+// audio has no loud segments.
+func analyzeLoudness(videoPath string) ([]LoudSegment, error) {
+	streams, err := probeStreams(videoPath)
+	if err != nil {
+		return nil, err
+	}
+	hasAudio := false
+	for _, stream := range streams {
+		if stream.CodecType == "audio" {
+			hasAudio = true
+			break
+		}
+	}
+	if !hasAudio {
+		return []LoudSegment{}, nil
+	}
+
+	output, err := exec.Command("ffmpeg",
+		"-nostats", "-v", "info",
+		"-i", videoPath,
+		"-map", "0:a:0",
+		"-filter:a", "ebur128=peak=true",
+		"-f", "null", "-",
+	).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure loudness: %v", err)
+	}
+
+	// Loudest momentary loudness and peak per whole second.
+	type second struct{ lufs, peak float64 }
+	seconds := map[int]*second{}
+	last := -1
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		t := ebur128Time.FindStringSubmatch(line)
+		m := ebur128Momentary.FindStringSubmatch(line)
+		if t == nil || m == nil {
+			continue
+		}
+		ts, err := strconv.ParseFloat(t[1], 64)
+		if err != nil {
+			continue
+		}
+		index := int(ts)
+		s, ok := seconds[index]
+		if !ok {
+			s = &second{lufs: loudnessFloor, peak: loudnessFloor}
+			seconds[index] = s
+		}
+		if lufs, err := strconv.ParseFloat(m[1], 64); err == nil {
+			s.lufs = math.Max(s.lufs, lufs)
+		}
+		if p := ebur128Peak.FindStringSubmatch(line); p != nil {
+			for _, field := range bytes.Fields([]byte(p[1])) {
+				if peak, err := strconv.ParseFloat(string(field), 64); err == nil {
+					s.peak = math.Max(s.peak, peak)
+				}
+			}
+		}
+		last = max(last, index)
+	}
+
+	segments := []LoudSegment{}
+	for i := 0; i <= last; i++ {
+		s, ok := seconds[i]
+		if !ok || (s.lufs <= config.LoudnessMaxLUFS && s.peak <= config.LoudnessMaxPeak) {
+			continue
+		}
+		if n := len(segments); n > 0 && segments[n-1].End == float64(i) {
+			segments[n-1].End = float64(i + 1)
+			segments[n-1].MaxLUFS = math.Max(segments[n-1].MaxLUFS, s.lufs)
+			segments[n-1].MaxPeak = math.Max(segments[n-1].MaxPeak, s.peak)
+			continue
+		}
+		segments = append(segments, LoudSegment{Start: float64(i), End: float64(i + 1), MaxLUFS: s.lufs, MaxPeak: s.peak})
+	}
+	return segments, nil
+}
@@ -2,12 +2,16 @@ package main
 
 import (
 	"bytes"
-	"encoding/base64"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"io"
 	"log"
+	"log/slog"
+	"math"
+	"mime"
 	"net/http"
 	"os"
 	"os/exec"
@@ -30,19 +34,36 @@ const (
 )
 
 type RatingResult struct {
-	Start  float64 `json:"start"`
-	End    float64 `json:"end"`
-	Rating string  `json:"rating"`
-	Notes  string  `json:"notes"`
+	Start   float64      `json:"start"`
+	End     float64      `json:"end"`
+	Rating  string       `json:"rating"`
+	Notes   string       `json:"notes"`
+	Regions []BlurRegion `json:"regions,omitempty"`
+	// RegionKeyframes are the regions of each analyzed frame, which the
+	// blur follows between samples; see regionsAt.
+	RegionKeyframes []RegionKeyframe `json:"region_keyframes,omitempty"`
+	// Confidence is the lowest model confidence among the segment's samples.
+	Confidence *float64 `json:"confidence,omitempty"`
+	// Samples are the analyzed frames that make up the segment, kept only
+	// for verbose analyses.
+	Samples []SampleDetail `json:"samples,omitempty"`
 }
 
 type ConvertRequest struct {
-	Age       string         `json:"age" binding:"required,oneof=6 12 16"`
+	Age       string         `json:"age" binding:"required"`
 	Ratings   []RatingResult `json:"ratings" binding:"required"`
 	VideoType string         `json:"video_type" binding:"required,oneof=blur trim"`
 	VideoPath string         `json:"video_path" binding:"required"`
 }
 
+// openAISemaphore caps the number of in-flight OpenAI requests across all
+// handlers and workers. It is nil when OPENAI_MAX_CONCURRENCY is unset.
+var openAISemaphore chan struct{}
+
+// openAIRateLimit paces OpenAI requests across all handlers and workers. It
+// is nil when OPENAI_RATE_LIMIT is unset.
+var openAIRateLimit *tokenBucket
+
 type OpenAIResponse struct {
 	Choices []struct {
 		Message struct {
@@ -52,8 +73,12 @@ type OpenAIResponse struct {
 }
 
 type RatingData struct {
-	Rating string `json:"rating"`
-	Notes  string `json:"notes"`
+	Rating     string   `json:"rating"`
+	Notes      string   `json:"notes"`
+	Confidence *float64 `json:"confidence"`
+	// Regions locate what raised the rating, when REGION_BLUR asks for
+	// them.
+	Regions []BlurRegion `json:"regions,omitempty"`
 }
 
 type GPTOSSInput struct {
@@ -79,29 +104,158 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
+	config = loadConfig()
+	setupLogging(config.LogLevel)
+
+	presets, err = loadPresets(config.PresetsFile)
+	if err != nil {
+		log.Fatalf("Failed to load presets: %v", err)
+	}
+
+	if config.WarmupEnabled {
+		if err := warmup(); err != nil {
+			log.Fatalf("Startup warmup failed: %v", err)
+		}
+	}
+
+	checkAnalysisImageFormat()
+
+	if err := checkAudioConfig(); err != nil {
+		log.Fatalf("Invalid audio settings: %v", err)
+	}
+
+	fewShotExamples, err = loadFewShotExamples(config.FewShotFile)
+	if err != nil {
+		log.Fatalf("Failed to load few-shot examples: %v", err)
+	}
+
+	if config.PrefilterModel != "" {
+		if config.PrefilterSamples < 1 {
+			log.Fatal("PREFILTER_SAMPLES must be at least 1")
+		}
+		preFilter, err = newFrameClassifier(config.PrefilterModel, config.ClassifierInputSize, config.ClassifierUnsafeClasses)
+		if err != nil {
+			log.Fatalf("Failed to load pre-filter: %v", err)
+		}
+	}
+	localClassifier = preFilter
+	if config.LocalModel != "" {
+		localClassifier, err = newFrameClassifier(config.LocalModel, config.ClassifierInputSize, config.ClassifierUnsafeClasses)
+		if err != nil {
+			log.Fatalf("Failed to load local model: %v", err)
+		}
+	}
+	if config.FallbackPolicy == fallbackLocal && localClassifier == nil {
+		log.Fatal("FALLBACK_POLICY=local requires LOCAL_MODEL or PREFILTER_MODEL")
+	}
+
+	openAIClient, err = newOpenAIClient()
+	if err != nil {
+		log.Fatalf("Failed to configure OpenAI client: %v", err)
+	}
+
+	if config.WhisperMode == whisperLocal && config.WhisperCommand == "" {
+		log.Fatal("WHISPER_MODE=local requires WHISPER_COMMAND")
+	}
+	if config.ProfanityDetection && (config.WhisperMode == "" || len(config.TranscriptBlocklist) == 0) {
+		log.Fatal("PROFANITY_DETECTION requires WHISPER_MODE and TRANSCRIPT_BLOCKLIST")
+	}
+
+	analyzers, err = newAnalyzerChain(config.AnalyzerChain)
+	if err != nil {
+		log.Fatalf("Invalid ANALYZER_CHAIN: %v", err)
+	}
+
+	if config.OpenAIMaxConcurrency > 0 {
+		openAISemaphore = make(chan struct{}, config.OpenAIMaxConcurrency)
+	}
+	openAIRateLimit = newTokenBucket(config.OpenAIRateLimit, config.OpenAIRateBurst)
+	analysisLimit = newPhaseLimit(jobAnalyzing, config.MaxConcurrentAnalyses)
+	encodeLimit = newPhaseLimit(jobEncoding, config.MaxConcurrentEncodes)
+
 	os.MkdirAll(uploadFolder, os.ModePerm)
 	os.MkdirAll(processedFolder, os.ModePerm)
+	if config.ProcessedMaxEntries < 1 {
+		log.Fatal("PROCESSED_METADATA_MAX_ENTRIES must be at least 1")
+	}
+	if config.ProcessedMetadataFile != "none" {
+		if err := processedFiles.open(config.ProcessedMetadataFile); err != nil {
+			log.Fatalf("Failed to load PROCESSED_METADATA_FILE: %v", err)
+		}
+	}
+	processedFiles.seed(processedFolder)
+	go processedFiles.sweep()
+
+	if config.DatabaseURL != "none" {
+		jobDB, err = openJobStore(config.DatabaseURL)
+		if err != nil {
+			log.Fatalf("Failed to open DATABASE_URL: %v", err)
+		}
+	}
+
+	trustedProxies, err = parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	router := gin.Default()
+	if err := router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"POST"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", config.TenantHeader, jobIDHeader, openAIOrganizationHeader, openAIProjectHeader},
+		ExposeHeaders:    []string{"Content-Length", jobIDHeader},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
 	router.MaxMultipartMemory = maxFileSize
 
-	router.POST("/upload", uploadVideo)
-	router.POST("/convert", convertVideo)
+	router.POST("/upload", tenantScope(true), uploadVideo)
+	router.POST("/convert", tenantScope(true), convertVideo)
+	router.POST("/triage", tenantScope(true), triageUpload)
+	router.POST("/poster", tenantScope(true), posterUpload)
 	router.POST("/classify", classifyContent) // New GPT-OSS endpoint
-	router.GET("/download/:filename", downloadVideo)
+	router.POST("/review-queue", reviewQueue)
+	router.POST("/estimate", estimateAnalysis)
+	router.GET("/features", features)
+	router.POST("/corrections", tenantScope(false), submitCorrection)
+	router.GET("/download/:filename", tenantScope(false), downloadVideo)
+	router.GET("/jobs", tenantScope(false), listJobs)
+	router.GET("/jobs/:jobid", tenantScope(false), getJob)
+	router.DELETE("/jobs/:jobid", tenantScope(false), cancelJob)
+	router.GET("/ws/jobs/:jobid", tenantFromQuery, tenantScope(false), watchJob)
+
+	if config.StorageBackend != "" {
+		storage, err = newStorage()
+		if err != nil {
+			log.Fatalf("Failed to configure storage: %v", err)
+		}
+		router.POST("/objects/sign-upload", tenantScope(false), signUpload)
+		router.POST("/objects/analyze", tenantScope(true), analyzeObject)
+		router.POST("/objects/convert", tenantScope(true), convertObject)
+		if _, ok := storage.(*localStorage); ok {
+			router.PUT("/storage/*key", putStorageObject)
+			router.GET("/storage/*key", getStorageObject)
+		}
+	}
+
+	server := &http.Server{
+		Addr:              ":8000",
+		Handler:           router,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+	}
 
 	log.Println("Starting server on port 8000...")
-	router.Run(":8000")
+	if err := server.ListenAndServe(); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
 }
 
 func uploadVideo(c *gin.Context) {
@@ -110,20 +264,309 @@ func uploadVideo(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No video file provided"})
 		return
 	}
+	if file.Size == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Video file is empty", "code": "INVALID_VIDEO"})
+		return
+	}
+	kind, err := checkAnalysisUploadType(file)
+	if err == errAudioOnly {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "AUDIO_ONLY"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error(), "code": "UNSUPPORTED_MEDIA_TYPE"})
+		return
+	}
+
+	streamIndex, err := parseStreamIndex(c.PostForm("video_stream"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format, err := ratingsFormat(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	filename := filepath.Join(uploadFolder, file.Filename)
+	// A cursor from a previous response continues the analysis of a file
+	// that is still growing, instead of starting over.
+	var resume *AnalysisCursor
+	if raw := c.PostForm("cursor"); raw != "" {
+		resume = &AnalysisCursor{}
+		if err := json.Unmarshal([]byte(raw), resume); err != nil || resume.NextFrame < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid cursor: %s", raw)})
+			return
+		}
+	}
+
+	opts := AnalysisOptions{Interpolation: config.ResizeInterpolation, SamplesPerSecond: config.SamplesPerSecond, ConsolidateMinDuration: config.ConsolidateMinDuration}
+	if raw := c.PostForm("consolidate_min_duration"); raw != "" {
+		opts.ConsolidateMinDuration, err = strconv.ParseFloat(raw, 64)
+		if err != nil || opts.ConsolidateMinDuration < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid consolidate_min_duration: %s", raw)})
+			return
+		}
+	}
+	opts.Ranges, err = parseRanges(c.PostForm("ranges"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if resume != nil && opts.Ranges != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ranges cannot be combined with cursor"})
+		return
+	}
+	if opts.Ranges != nil {
+		opts.SamplesPerSecond = config.RangeSamplesPerSecond
+	}
+	if raw := c.PostForm("samples_per_second"); raw != "" {
+		opts.SamplesPerSecond, err = strconv.Atoi(raw)
+		if err != nil || opts.SamplesPerSecond < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid samples_per_second: %s", raw)})
+			return
+		}
+	}
+	if raw := c.PostForm("sample_interval_seconds"); raw != "" {
+		interval, err := strconv.ParseFloat(raw, 64)
+		if err != nil || interval <= 0 || interval > maxSampleInterval {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid sample_interval_seconds: %s", raw)})
+			return
+		}
+		if c.PostForm("samples_per_second") != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sample_interval_seconds cannot be combined with samples_per_second"})
+			return
+		}
+		opts.SamplesPerSecond = 1
+		if interval < 1 {
+			opts.SamplesPerSecond = int(math.Round(1 / interval))
+		} else {
+			opts.SampleInterval = interval
+		}
+	}
+	if raw := c.PostForm("analysis_resolution"); raw != "" {
+		opts.Resolution, err = strconv.Atoi(raw)
+		if err != nil || opts.Resolution < minAnalysisSize || opts.Resolution > maxAnalysisSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid analysis_resolution: %s (%d to %d)", raw, minAnalysisSize, maxAnalysisSize)})
+			return
+		}
+	}
+	// Scene sampling plans its frames over the whole file, so it doesn't
+	// apply to ranges or to a file analyzed in steps.
+	opts.Sampling = samplingFixed
+	if resume == nil && opts.Ranges == nil {
+		opts.Sampling = config.SamplingMode
+	}
+	if raw := c.PostForm("sampling"); raw != "" {
+		if raw != samplingFixed && raw != samplingScene {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid sampling: %s", raw)})
+			return
+		}
+		if raw == samplingScene && (resume != nil || opts.Ranges != nil) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sampling=scene cannot be combined with cursor or ranges"})
+			return
+		}
+		opts.Sampling = raw
+	}
+	wantsLoudness := config.LoudnessAnalysis
+	if raw := c.PostForm("loudness"); raw != "" {
+		wantsLoudness = raw == "true"
+	}
+	// Profanity detection transcribes the audio and reports where words of
+	// the blocklist are spoken.
+	var profanityBlocklist []string
+	wantsProfanity := config.ProfanityDetection
+	if raw := c.PostForm("profanity"); raw != "" {
+		wantsProfanity = raw == "true"
+	}
+	if wantsProfanity {
+		if config.WhisperMode == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Profanity detection requires WHISPER_MODE"})
+			return
+		}
+		profanityBlocklist = config.TranscriptBlocklist
+		if raw := c.PostForm("blocklist"); raw != "" {
+			profanityBlocklist = strings.Split(raw, ",")
+		}
+		if len(profanityBlocklist) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A blocklist is required for profanity detection"})
+			return
+		}
+	}
+	opts.SceneContext, err = parseSceneContext(c.PostForm("scene_context"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if raw := c.PostForm("interpolation"); raw != "" {
+		interpolation, ok := interpolationMethods[strings.ToLower(raw)]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid interpolation: %s", raw)})
+			return
+		}
+		opts.Interpolation = interpolation
+	}
+	opts.Transform, err = parseSidecar(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if c.PostForm("timeline") == "true" || format == ratingsFormatCSV {
+		opts.Timeline = &analysisTimeline{}
+	}
+	opts.Verbose = c.PostForm("verbose") == "true"
+
+	mode, err := requestMode(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if mode == convertModeAsync && (resume != nil || format != ratingsFormatJSON) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode=async cannot be combined with cursor and answers JSON only"})
+		return
+	}
+
+	filename := uploadPath(c, file.Filename)
 	if err := c.SaveUploadedFile(file, filename); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save video file"})
+		respondSaveError(c, filename, err)
 		return
 	}
 
-	// Process video with existing OpenAI vision analysis
-	ratings, err := processVideo(filename)
+	if streamIndex >= 0 && kind == mediaVideo {
+		streamPath, err := extractVideoStream(filename, streamIndex)
+		os.Remove(filename)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filename = streamPath
+	}
+
+	if kind == mediaVideo {
+		kind, err = detectMediaKind(filename)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": mediaErrorCode(err)})
+			os.Remove(filename)
+			return
+		}
+	}
+
+	// Outputs of this tool are already censored; analyzing them again
+	// would only cost another round of API calls.
+	if kind == mediaVideo && config.SkipProcessedUploads && c.PostForm("force") != "true" {
+		if own, tags := isOwnOutput(filename); own {
+			os.Remove(filename)
+			response := gin.H{"error": "File is already a censored output of this service; set force=true to analyze it anyway", "code": "ALREADY_PROCESSED"}
+			for _, key := range []string{"content_rating", "censored_for_age"} {
+				if value, ok := tags[key]; ok {
+					response[key] = value
+				}
+			}
+			c.JSON(http.StatusConflict, response)
+			return
+		}
+	}
+
+	if kind == mediaVideo {
+		if err := checkReadableVideo(filename); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "INVALID_VIDEO"})
+			os.Remove(filename)
+			return
+		}
+	}
+
+	// Low-resolution sources are analyzed as usual, but the response warns
+	// that their ratings may be less reliable.
+	lowRes := lowResolution(filename, opts.Transform)
+
+	if mode == convertModeAsync {
+		analyzeAsync(c, filename, kind, opts, wantsLoudness, profanityBlocklist, lowRes)
+		return
+	}
+
+	ctx, cancel, err := processingContext(c, c.PostForm("timeout"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		os.Remove(filename)
 		return
 	}
+	defer cancel()
+
+	// Incremental steps skip the whole-file passes (pre-filter, shot
+	// snapping and GPT-OSS), which would repeat on every step.
+	if resume != nil {
+		cursor, err := analyzeStep(ctx, filename, opts, *resume, c.PostForm("final") == "true")
+		os.Remove(filename)
+		if err != nil {
+			respondProcessingError(c, err)
+			return
+		}
+		response := gin.H{"ratings": cursor.Ratings, "cursor": cursor}
+		if lowRes {
+			response["low_resolution"] = true
+		}
+		respondRatings(c, format, cursor.Ratings, opts.Timeline, response)
+		return
+	}
+
+	ratings, response, err := analyzeUpload(ctx, filename, kind, opts, wantsLoudness, profanityBlocklist, lowRes)
+	if err != nil {
+		respondProcessingError(c, err)
+		return
+	}
+	respondRatings(c, format, ratings, opts.Timeline, response)
+}
+
+// analyzeUpload rates the whole of an uploaded image or video and returns
+// its ratings and the /upload response body. Videos are checked for
+// profanity too if profanityBlocklist is set. It removes filename when
+// done.
+func analyzeUpload(ctx context.Context, filename, kind string, opts AnalysisOptions, wantsLoudness bool, profanityBlocklist []string, lowRes bool) ([]RatingResult, gin.H, error) {
+	defer os.Remove(filename)
+
+	// Single images get one rating and skip the video-level GPT-OSS pass.
+	if kind == mediaImage {
+		ratings, err := processImage(ctx, filename, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		recordJobRatings(ctx, ratings)
+		response := gin.H{"ratings": ratings, "media_type": mediaImage}
+		if lowRes {
+			response["low_resolution"] = true
+		}
+		return ratings, response, nil
+	}
+
+	// Process video with existing OpenAI vision analysis
+	ratings, err := processVideo(ctx, filename, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	recordJobRatings(ctx, ratings)
+
+	// Audio loudness is an optional second, audio-safety dimension.
+	var loudSegments []LoudSegment
+	if wantsLoudness {
+		loudSegments, err = analyzeLoudness(filename)
+		if err != nil {
+			log.Printf("Loudness analysis failed: %v", err)
+		}
+	}
+
+	// Profanity is returned next to the visual ratings, for /convert to
+	// bleep or mute.
+	var profanity []ProfanitySegment
+	if profanityBlocklist != nil {
+		profanity, err = detectProfanity(ctx, filename, profanityBlocklist)
+		if ctx.Err() != nil {
+			return nil, nil, checkContext(ctx)
+		}
+		if err != nil {
+			log.Printf("Profanity detection failed: %v", err)
+		}
+	}
 
 	// Also run GPT-OSS classification
 	gptOSSResult, err := classifyVideoContent(filename)
@@ -131,136 +574,444 @@ func uploadVideo(c *gin.Context) {
 		log.Printf("GPT-OSS classification failed: %v", err)
 		// Continue without GPT-OSS result rather than failing the entire request
 		gptOSSResult = &GPTOSSResponse{
-			Rating: "12+",
+			Rating: ratingAtMost(12),
 			Reason: "GPT-OSS classification unavailable",
 		}
 	}
 
-	os.Remove(filename)
-
 	// Return both the frame-by-frame ratings and the overall GPT-OSS classification
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"ratings": ratings,
 		"gpt_oss": gptOSSResult,
-	})
+	}
+	if wantsLoudness {
+		response["loud_segments"] = loudSegments
+	}
+	if profanityBlocklist != nil {
+		response["profanity"] = profanity
+	}
+	if lowRes {
+		response["low_resolution"] = true
+	}
+	return ratings, response, nil
+}
+
+// AnalysisOptions holds optional per-request settings for processVideo.
+type AnalysisOptions struct {
+	// Interpolation is used when downscaling frames for analysis.
+	Interpolation gocv.InterpolationFlags
+	// SamplesPerSecond is how many frames of each second are analyzed.
+	SamplesPerSecond int
+	// SampleInterval, if over 1, analyzes one frame every SampleInterval
+	// seconds instead.
+	SampleInterval float64
+	// Resolution is the size frames are scaled to for analysis; 0 means
+	// ANALYSIS_RESOLUTION.
+	Resolution int
+	// SceneContext describes the video to the model; see parseSceneContext.
+	SceneContext string
+	// Transform, if set, corrects each frame before it is analyzed.
+	Transform *FrameTransform
+	// Timeline, if set, records the rating of every analyzed second.
+	Timeline *analysisTimeline
+	// Verbose keeps every analyzed frame of a segment in its Samples.
+	Verbose bool
+	// Ranges, if set, limits the analysis to these spans of the video.
+	Ranges []TimeRange
+	// ConsolidateMinDuration folds shorter segments into stricter
+	// neighbours once the analysis is done; see consolidateSegments.
+	ConsolidateMinDuration float64
+	// Progress, if set, is updated as source frames are analyzed.
+	Progress *jobProgress
+
+	// Sampling is samplingFixed or samplingScene; see analyzeScenes.
+	Sampling string
+
+	// stopFrame, if positive, ends an analysis step before that frame.
+	stopFrame int
+	// sampleFrames, if set, are the frames to analyze instead of
+	// SamplesPerSecond frames of each second. Seconds without one repeat
+	// the last sample before them.
+	sampleFrames map[int]bool
+	// sampleCache, if set, supplies and keeps the samples of analyzed
+	// frames.
+	sampleCache *sampleCache
 }
 
-func processVideo(videoPath string) ([]RatingResult, error) {
+func processVideo(ctx context.Context, videoPath string, opts AnalysisOptions) ([]RatingResult, error) {
+	release, err := analysisLimit.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if opts.Progress == nil {
+		opts.Progress = contextProgress(ctx)
+	}
+
+	// A targeted re-check analyzes only what was asked for, so the
+	// whole-file pre-filter and shot snapping are skipped.
+	if len(opts.Ranges) > 0 {
+		results, err := analyzeRanges(ctx, videoPath, opts)
+		if err != nil {
+			return nil, err
+		}
+		return consolidateSegments(results, opts.ConsolidateMinDuration, config.ConsolidateMaxGap), nil
+	}
+
+	if preFilter != nil {
+		clean, duration, err := prefilterVideo(videoPath)
+		if err != nil {
+			log.Printf("Pre-filter failed, running full analysis: %v", err)
+		} else if clean {
+			log.Printf("Pre-filter found no suspicious frames, skipping OpenAI analysis")
+			return []RatingResult{{
+				Start:  0,
+				End:    duration,
+				Rating: mildestRating(),
+			}}, nil
+		}
+	}
+
+	var cursor AnalysisCursor
+	if opts.Sampling == samplingScene {
+		cursor, err = analyzeScenes(ctx, videoPath, opts)
+	} else {
+		cursor, err = analyzeWithCheckpoints(ctx, videoPath, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	results := cursor.Ratings
+
+	if config.ShotSnapWindow > 0 && len(results) > 1 {
+		cuts, err := detectShotCuts(videoPath)
+		if err != nil {
+			log.Printf("Shot detection failed, keeping sample boundaries: %v", err)
+		} else {
+			results = snapToShotCuts(results, cuts, config.ShotSnapWindow)
+		}
+	}
+
+	return consolidateSegments(results, opts.ConsolidateMinDuration, config.ConsolidateMaxGap), nil
+}
+
+// AnalysisCursor records how far the analysis of a video got, so a file
+// that is still being written can be analyzed in steps as it grows.
+type AnalysisCursor struct {
+	// NextFrame is the first frame the next step analyzes. It is always
+	// the first frame of a second.
+	NextFrame int `json:"next_frame"`
+	// Ratings are the segments found so far. The last one is still open
+	// and is extended by the next step if its rating continues.
+	Ratings []RatingResult `json:"ratings"`
+}
+
+// analyzeVideoFrom continues the analysis of videoPath from cursor and
+// returns the cursor to continue from. Unless final is set, only whole
+// seconds are analyzed, and a trailing partial second is left for the next
+// step to pick up once more of the file is written.
+func analyzeVideoFrom(ctx context.Context, videoPath string, opts AnalysisOptions, cursor AnalysisCursor, final bool) (AnalysisCursor, error) {
 	video, err := gocv.VideoCaptureFile(videoPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open video: %v", err)
+		return cursor, fmt.Errorf("failed to open video: %v", err)
 	}
 	defer video.Close()
 
+	if _, _, err := opts.Transform.size(int(video.Get(gocv.VideoCaptureFrameWidth)), int(video.Get(gocv.VideoCaptureFrameHeight))); err != nil {
+		return cursor, err
+	}
+
 	fps := video.Get(gocv.VideoCaptureFPS)
 	if fps <= 0 {
 		fps = 30 // Default to 30fps if unable to determine
 	}
 
-	var results []RatingResult
-	frameIndex := 0
+	frameIndex := cursor.NextFrame
+	if frameIndex > 0 {
+		video.Set(gocv.VideoCapturePosFrames, float64(frameIndex))
+	}
+	opts.Progress.setTotal(int(video.Get(gocv.VideoCaptureFrameCount)), fps)
+	opts.Progress.at(frameIndex)
+
+	results := append([]RatingResult(nil), cursor.Ratings...)
 
 	var lastRating string
 	var startTime float64
-	combinedNotes := make(map[string]bool)
-
-	img := gocv.NewMat()
-	defer img.Close()
-
-	for {
-		if ok := video.Read(&img); !ok || img.Empty() {
-			break
+	combinedNotes := make(map[string]int)
+
+	// A segment is blurred by region only when every sample in it located
+	// what raised its rating: on-screen text or, with REGION_BLUR, boxes
+	// from the model. Otherwise the whole frame is blurred.
+	var segmentRegions []BlurRegion
+	var segmentKeyframes []RegionKeyframe
+	regionOnly := false
+	var segmentConfidence *float64
+	var segmentSamples []SampleDetail
+
+	// The last segment of a previous step is reopened so it can continue.
+	if n := len(results); n > 0 && ratingLevel(results[n-1].Rating) >= 0 {
+		open := results[n-1]
+		results = results[:n-1]
+		lastRating = open.Rating
+		startTime = open.Start
+		segmentConfidence = open.Confidence
+		segmentSamples = open.Samples
+		segmentRegions = open.Regions
+		segmentKeyframes = open.RegionKeyframes
+		regionOnly = len(open.Regions) > 0
+		for _, note := range strings.Split(open.Notes, ",") {
+			if note = strings.TrimSpace(note); note != "" && note != notesEllipsis {
+				combinedNotes[note]++
+			}
 		}
+	}
 
-		if frameIndex%int(fps) == 0 {
-			timestamp := float64(frameIndex) / fps
-
-			resized := gocv.NewMat()
-			gocv.Resize(img, &resized, image.Point{X: 512, Y: 512}, 0, 0, gocv.InterpolationLinear)
-
-			buf, err := gocv.IMEncode(".jpg", resized)
-			resized.Close()
-			if err != nil {
-				frameIndex++
-				continue
+	// addSample feeds the combined sample for the second starting at
+	// timestamp, voted from the frames in details, into the current
+	// segment, or starts a new one.
+	addSample := func(timestamp float64, sample frameSample, details []SampleDetail) {
+		opts.Timeline.record(timestamp, sample)
+		rating, notes, regions := sample.Rating, sample.Notes, sample.Regions
+
+		if rating == lastRating {
+			segmentConfidence = minConfidence(segmentConfidence, sample.Confidence)
+			segmentSamples = append(segmentSamples, details...)
+			if regions == nil {
+				regionOnly = false
+			}
+			segmentRegions = append(segmentRegions, regions...)
+			segmentKeyframes = append(segmentKeyframes, sample.Keyframes...)
+			for _, note := range strings.Split(notes, ",") {
+				note = strings.TrimSpace(strings.ToLower(note))
+				if keepNote(note) {
+					combinedNotes[note]++
+				}
+			}
+		} else {
+			if lastRating != "" {
+				notesStr := joinNotes(combinedNotes)
+
+				result := RatingResult{
+					Start:      startTime,
+					End:        timestamp - 1,
+					Rating:     lastRating,
+					Notes:      notesStr,
+					Confidence: segmentConfidence,
+					Samples:    segmentSamples,
+				}
+				if regionOnly {
+					result.Regions = segmentRegions
+					result.RegionKeyframes = segmentKeyframes
+				}
+				results = append(results, result)
+				opts.Progress.segmentDone()
 			}
 
-			base64Img := base64.StdEncoding.EncodeToString(buf.GetBytes())
-			dataURL := fmt.Sprintf("data:image/jpeg;base64,%s", base64Img)
-			rating, notes, err := analyzeFrameWithOpenAI(dataURL)
-			if err != nil {
-				return []RatingResult{{
-					Start:  timestamp,
-					Rating: fmt.Sprintf("Error: %v", err),
-				}}, nil
+			startTime = timestamp
+			lastRating = rating
+			segmentConfidence = sample.Confidence
+			segmentSamples = details
+			segmentRegions = regions
+			segmentKeyframes = sample.Keyframes
+			regionOnly = regions != nil
+			combinedNotes = make(map[string]int)
+			for _, note := range strings.Split(notes, ",") {
+				note = strings.TrimSpace(strings.ToLower(note))
+				if keepNote(note) {
+					combinedNotes[note]++
+				}
 			}
+		}
+	}
 
-			if rating == lastRating {
-				for _, note := range strings.Split(notes, ",") {
-					note = strings.TrimSpace(strings.ToLower(note))
-					if note != "" && note != "none" {
-						combinedNotes[note] = true
-					}
+	// Each second is sampled opts.SamplesPerSecond times. Samples are
+	// analyzed by a samplePool while reading goes on, and each second is
+	// combined by voteSamples and added, in order, once all of its samples
+	// are back.
+	offsets, _ := sampleOffsets(int(fps), opts.SamplesPerSecond)
+	// Sparse sampling leaves seconds without a sample, which repeat the
+	// last sample before them. A SampleInterval step starts with its first
+	// frame, so it always has one to repeat.
+	sparse := opts.sampleFrames != nil || opts.SampleInterval > 1
+	interval := max(1, int(math.Round(opts.SampleInterval*fps)))
+	pool := newSamplePool(ctx, opts)
+	defer pool.wait()
+	var second []*pendingSample
+	var seconds []pendingSecond
+
+	// addSeconds adds the queued seconds that are ready, or with wait set
+	// every queued second. It stops at the first sample that failed and
+	// returns it.
+	var last *frameSample
+	addSeconds := func(wait bool) *pendingSample {
+		for len(seconds) > 0 && (wait || seconds[0].ready()) {
+			var samples []frameSample
+			var details []SampleDetail
+			for _, pending := range seconds[0].samples {
+				<-pending.done
+				if pending.err != nil {
+					return pending
 				}
-			} else {
-				if lastRating != "" {
-					var notesList []string
-					for note := range combinedNotes {
-						notesList = append(notesList, note)
+				if pending.sample != nil {
+					samples = append(samples, *pending.sample)
+					last = pending.sample
+					if opts.Verbose {
+						details = append(details, newSampleDetail(pending.timestamp, *pending.sample))
 					}
-					sort.Strings(notesList)
-					notesStr := strings.Join(notesList, ", ")
-
-					results = append(results, RatingResult{
-						Start:  startTime,
-						End:    timestamp - 1,
-						Rating: lastRating,
-						Notes:  notesStr,
-					})
 				}
+			}
+			if len(samples) > 0 {
+				addSample(seconds[0].start, voteSamples(samples), details)
+			} else if seconds[0].carry && last != nil {
+				// The shot of the last sample goes on; its regions were
+				// timed already.
+				carried := *last
+				carried.Keyframes = nil
+				addSample(seconds[0].start, carried, nil)
+			}
+			seconds = seconds[1:]
+		}
+		return nil
+	}
+	failedAt := func(pending *pendingSample) (AnalysisCursor, error) {
+		if ctx.Err() != nil {
+			return cursor, checkContext(ctx)
+		}
+		if errors.Is(pending.err, errProviderDown) {
+			return cursor, pending.err
+		}
+		return AnalysisCursor{NextFrame: pending.frameIndex, Ratings: []RatingResult{{
+			Start:  pending.timestamp,
+			Rating: fmt.Sprintf("Error: %v", pending.err),
+		}}}, nil
+	}
 
-				startTime = timestamp
-				lastRating = rating
-				combinedNotes = make(map[string]bool)
-				for _, note := range strings.Split(notes, ",") {
-					note = strings.TrimSpace(strings.ToLower(note))
-					if note != "" && note != "none" {
-						combinedNotes[note] = true
-					}
-				}
+	img := gocv.NewMat()
+	defer img.Close()
+
+	for {
+		if err := checkContext(ctx); err != nil {
+			return cursor, err
+		}
+		// Once a sample fails no more are submitted; addSeconds below
+		// reaches the failure after the seconds before it.
+		if pool.failed.Load() {
+			seconds = append(seconds, pendingSecond{samples: second})
+			break
+		}
+		if opts.stopFrame > 0 && frameIndex >= opts.stopFrame {
+			break
+		}
+		if ok := video.Read(&img); !ok || img.Empty() {
+			break
+		}
+
+		offset := frameIndex % int(fps)
+		sampled := offsets[offset]
+		if opts.sampleFrames != nil {
+			sampled = opts.sampleFrames[frameIndex]
+		} else if opts.SampleInterval > 1 {
+			sampled = (frameIndex-cursor.NextFrame)%interval == 0
+		}
+		if sampled {
+			opts.Transform.apply(&img)
+			second = append(second, pool.submit(img, frameIndex, float64(frameIndex)/fps))
+		}
+		if offset == int(fps)-1 && (len(second) > 0 || sparse) {
+			seconds = append(seconds, pendingSecond{start: float64(frameIndex-offset) / fps, samples: second, carry: sparse})
+			second = nil
+			if failed := addSeconds(false); failed != nil {
+				return failedAt(failed)
 			}
 		}
 
 		frameIndex++
+		opts.Progress.at(frameIndex)
+	}
+	if failed := addSeconds(true); failed != nil {
+		return failedAt(failed)
+	}
+
+	// The video may end partway through its final second. A step that is
+	// not final leaves it to the next one, as more frames may follow.
+	if !final {
+		frameIndex -= frameIndex % int(fps)
+	} else if len(second) > 0 || sparse && frameIndex%int(fps) > 0 {
+		secondStart := (frameIndex - 1) / int(fps) * int(fps)
+		seconds = append(seconds, pendingSecond{start: float64(secondStart) / fps, samples: second, carry: sparse})
+		if failed := addSeconds(true); failed != nil {
+			return failedAt(failed)
+		}
 	}
 
 	if lastRating != "" {
-		var notesList []string
-		for note := range combinedNotes {
-			notesList = append(notesList, note)
-		}
-		sort.Strings(notesList)
-		notesStr := strings.Join(notesList, ", ")
-
-		results = append(results, RatingResult{
-			Start:  startTime,
-			End:    float64(frameIndex) / fps,
-			Rating: lastRating,
-			Notes:  notesStr,
-		})
+		notesStr := joinNotes(combinedNotes)
+
+		result := RatingResult{
+			Start:      startTime,
+			End:        float64(frameIndex) / fps,
+			Rating:     lastRating,
+			Notes:      notesStr,
+			Confidence: segmentConfidence,
+			Samples:    segmentSamples,
+		}
+		if regionOnly {
+			result.Regions = segmentRegions
+			result.RegionKeyframes = segmentKeyframes
+		}
+		results = append(results, result)
 	}
 
-	return results, nil
+	return AnalysisCursor{NextFrame: frameIndex, Ratings: results}, nil
+}
+
+// openAIModel is the chat model frames are rated with.
+const openAIModel = "gpt-4o"
+
+// analyzeFrameWithOpenAI rates one frame. sceneContext, if set, describes
+// the video the frame comes from and is prepended to the prompt.
+func analyzeFrameWithOpenAI(ctx context.Context, dataURL, sceneContext string) (RatingData, error) {
+	return analyzeFrameWithChat(ctx, "OpenAI", "https://api.openai.com/v1/chat/completions", func(header http.Header) {
+		header.Set("Authorization", "Bearer "+os.Getenv("OPENAI_API_KEY"))
+		setOpenAIBillingHeaders(ctx, header)
+	}, dataURL, sceneContext)
+}
+
+// analysisPrompt is the text sent with each frame to rate.
+func analysisPrompt(sceneContext string) string {
+	names := ratingNames()
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	responseFields := `three fields:
+{
+  "rating": "one of ` + strings.Join(names, ", ") + `",
+  "notes": "comma-separated keywords describing content (e.g. 'blood, nude')",
+  "confidence": "a number from 0 to 1 for how certain you are of the rating"`
+	if config.RegionBlur {
+		responseFields = strings.Replace(responseFields, "three", "four", 1) + `,
+  "regions": "boxes around the content that raised the rating, as [{"x": 0.1, "y": 0.2, "width": 0.3, "height": 0.4}] in fractions of the image size, or [] if the whole image should be blurred"`
+	}
+	return scenePrompt(sceneContext) + `Analyze the image and rate it for violence and nudity based on these guidelines:
+
+` + ratingGuidelines() + `
+
+Return a valid JSON object with ` + responseFields + `
+}`
 }
 
-func analyzeFrameWithOpenAI(dataURL string) (string, string, error) {
+// analyzeFrameWithChat rates one frame with the OpenAI-compatible chat
+// completions API at url, named provider in errors. auth adds the
+// provider's credentials to the request headers.
+func analyzeFrameWithChat(ctx context.Context, provider, url string, auth func(http.Header), dataURL, sceneContext string) (RatingData, error) {
 	type Message struct {
 		Role    string      `json:"role"`
 		Content interface{} `json:"content"`
 	}
 
 	type ImageURL struct {
-		URL string `json:"url"`
+		URL    string `json:"url"`
+		Detail string `json:"detail,omitempty"`
 	}
 
 	type ContentItem struct {
@@ -269,24 +1020,14 @@ func analyzeFrameWithOpenAI(dataURL string) (string, string, error) {
 		ImageURL *ImageURL `json:"image_url,omitempty"`
 	}
 
-	promptText := `Analyze the image and rate it for violence and nudity based on these guidelines:
-
-- **6+**: Minimal, non-detailed violence. No nudity.
-- **12+**: Moderate violence without injury detail. Brief, non-sexual nudity.
-- **16+**: Intense but non-gratuitous violence. Partial nudity and implied sexual content allowed.
-- **18+**: Explicit violence with gore. Nudity, including sexual content, allowed.
-
-Return a valid JSON object with two fields:
-{
-  "rating": "one of 18+, 16+, 12+, 6+",
-  "notes": "comma-separated keywords describing content (e.g. 'blood, nude')"
-}`
+	promptText := analysisPrompt(sceneContext)
 
 	contentItems := []ContentItem{
 		{
 			Type: "image_url",
 			ImageURL: &ImageURL{
-				URL: dataURL,
+				URL:    dataURL,
+				Detail: config.OpenAIImageDetail,
 			},
 		},
 		{
@@ -295,187 +1036,727 @@ Return a valid JSON object with two fields:
 		},
 	}
 
-	requestBody := map[string]interface{}{
-		"model": "gpt-4o",
-		"messages": []Message{
-			{
-				Role:    "user",
-				Content: contentItems,
+	// Few-shot examples come first, each as an exchange the model already
+	// answered, so the final message is still the only one to rate.
+	var messages []Message
+	for _, example := range fewShotExamples {
+		messages = append(messages,
+			Message{
+				Role: "user",
+				Content: []ContentItem{
+					{Type: "image_url", ImageURL: &ImageURL{URL: example.dataURL, Detail: config.OpenAIImageDetail}},
+					{Type: "text", Text: fewShotPrompt},
+				},
 			},
-		},
+			Message{Role: "assistant", Content: example.answer},
+		)
+	}
+	messages = append(messages, Message{
+		Role:    "user",
+		Content: contentItems,
+	})
+
+	requestBody := map[string]interface{}{
+		"model":       openAIModel,
+		"messages":    messages,
+		"temperature": config.OpenAITemperature,
+	}
+	if config.OpenAISeed != nil {
+		requestBody["seed"] = *config.OpenAISeed
 	}
 
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to marshal request: %v", err)
+		return RatingData{}, fmt.Errorf("failed to marshal request: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+	return withRetries(ctx, provider, func() (RatingData, error) {
+		return sendChatRequest(ctx, provider, url, auth, jsonData)
+	})
+}
+
+// sendChatRequest makes one chat completions call with the request body
+// jsonData, within the OpenAI concurrency and rate limits.
+func sendChatRequest(ctx context.Context, provider, url string, auth func(http.Header), jsonData []byte) (RatingData, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create request: %v", err)
+		return RatingData{}, fmt.Errorf("failed to create request: %v", err)
 	}
 
-	apiKey := os.Getenv("OPENAI_API_KEY")
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	auth(req.Header)
+
+	if openAISemaphore != nil {
+		select {
+		case openAISemaphore <- struct{}{}:
+			defer func() { <-openAISemaphore }()
+		case <-ctx.Done():
+			return RatingData{}, ctx.Err()
+		}
+	}
+	if err := openAIRateLimit.wait(ctx); err != nil {
+		return RatingData{}, err
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	// The call timeout starts once a concurrency slot and a rate token are
+	// free, and only bounds this call; the job's own deadline still applies.
+	if config.OpenAICallTimeout > 0 {
+		callCtx, cancel := context.WithTimeoutCause(ctx, config.OpenAICallTimeout, errFrameTimeout)
+		defer cancel()
+		req = req.WithContext(callCtx)
+	}
+	callError := func(action string, err error) error {
+		if context.Cause(req.Context()) == errFrameTimeout {
+			return fmt.Errorf("%w: %s call exceeded %v", errFrameTimeout, provider, config.OpenAICallTimeout)
+		}
+		return fmt.Errorf("failed to %s: %w", action, err)
+	}
+
+	resp, err := openAIClient.Do(req)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to send request: %v", err)
+		return RatingData{}, callError("send request", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to read response: %v", err)
+		return RatingData{}, callError("read response", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return RatingData{}, newProviderStatusError(provider, resp, body)
 	}
 
 	var openAIResp OpenAIResponse
 	if err := json.Unmarshal(body, &openAIResp); err != nil {
-		return "", "", fmt.Errorf("failed to parse response: %v", err)
+		return RatingData{}, fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	if len(openAIResp.Choices) == 0 {
+		return RatingData{}, fmt.Errorf("no choices in response")
+	}
+
+	content := openAIResp.Choices[0].Message.Content
+	slog.Debug("Analysis response", "provider", provider, "content", content)
+
+	return parseRatingContent(content)
+}
+
+func convertVideo(c *gin.Context) {
+	age := c.PostForm("age")
+	videoType := c.PostForm("video_type")
+	ratingsStr := c.PostForm("ratings")
+
+	// A preset supplies defaults; explicit fields take precedence.
+	var preset Preset
+	if name := c.PostForm("preset"); name != "" {
+		var ok bool
+		if preset, ok = presets[name]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown preset: %s", name)})
+			return
+		}
+		if age == "" {
+			age = preset.Age
+		}
+		if videoType == "" {
+			videoType = preset.VideoType
+		}
+	}
+
+	slog.Debug("Raw ratings", "ratings", ratingsStr)
+
+	file, err := c.FormFile("video_path")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No video file provided"})
+		return
+	}
+	if file.Size == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Video file is empty", "code": "INVALID_VIDEO"})
+		return
+	}
+	if err := checkUploadType(file); err != nil {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error(), "code": "UNSUPPORTED_MEDIA_TYPE"})
+		return
+	}
+
+	var ratings []RatingResult
+
+	if ratingsStr != "" {
+		if err := json.Unmarshal([]byte(ratingsStr), &ratings); err != nil {
+			log.Printf("Error parsing ratings: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid ratings format: %v", err)})
+			return
+		}
+		log.Printf("Parsed %d rating segments", len(ratings))
+		for i, r := range ratings {
+			slog.Debug("Rating segment", "index", i, "start", r.Start, "end", r.End, "rating", r.Rating, "notes", r.Notes)
+		}
+	}
+
+	// A time-coded transcript censors the moments blocklisted words are
+	// spoken, on top of the visual ratings.
+	var transcriptRatings []RatingResult
+	if raw := c.PostForm("transcript"); raw != "" {
+		words, err := parseTranscript(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		blocklist := config.TranscriptBlocklist
+		if raw := c.PostForm("blocklist"); raw != "" {
+			blocklist = strings.Split(raw, ",")
+		}
+		if len(blocklist) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A blocklist is required with a transcript"})
+			return
+		}
+		transcriptRatings = transcriptSegments(words, blocklist, config.TranscriptRating, config.TranscriptPadding)
+		log.Printf("Transcript matched %d blocklisted segments", len(transcriptRatings))
+	}
+
+	if age == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Age is required"})
+		return
+	}
+
+	if videoType == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Video type is required"})
+		return
+	}
+
+	ageInt, err := parseAge(age)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A comma-separated video_type renders each style from one decode.
+	videoTypes := strings.Split(videoType, ",")
+	for i, t := range videoTypes {
+		videoTypes[i] = strings.TrimSpace(t)
+		if videoTypes[i] != "blur" && videoTypes[i] != "trim" && (videoTypes[i] != "none" || len(videoTypes) > 1) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Video type must be one of: blur, trim, none, or a comma-separated list of blur and trim"})
+			return
+		}
+	}
+
+	streamIndex, err := parseStreamIndex(c.PostForm("video_stream"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mode, err := requestMode(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if mode == convertModeAsync && (len(videoTypes) > 1 || wantsProgressStream(c)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode=async takes a single video_type and cannot be streamed"})
+		return
+	}
+
+	filename := uploadPath(c, file.Filename)
+	if err := c.SaveUploadedFile(file, filename); err != nil {
+		respondSaveError(c, filename, err)
+		return
+	}
+
+	if streamIndex >= 0 {
+		streamPath, err := extractVideoStream(filename, streamIndex)
+		os.Remove(filename)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filename = streamPath
+	}
+
+	kind, err := detectMediaKind(filename)
+	if err == nil && kind == mediaImage {
+		err = fmt.Errorf("file is a single image, only videos can be converted")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": mediaErrorCode(err)})
+		os.Remove(filename)
+		return
+	}
+
+	if err := checkReadableVideo(filename); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "INVALID_VIDEO"})
+		os.Remove(filename)
+		return
+	}
+
+	log.Printf("Received convert request: Age=%s, VideoType=%s, VideoFile=%q", age, videoType, file.Filename)
+
+	slog.Debug("Parsed age", "age", age, "value", ageInt)
+
+	minCensorDuration := config.MinCensorDuration
+	if raw := c.PostForm("min_censor_duration"); raw != "" {
+		minCensorDuration, err = strconv.ParseFloat(raw, 64)
+		if err != nil || minCensorDuration < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid min_censor_duration: %s", raw)})
+			os.Remove(filename)
+			return
+		}
+	}
+	ratings = ignoreBriefCensorSpans(preset.apply(ratings), ageInt, minCensorDuration)
+	// Spoken words are censored however brief they are.
+	ratings = overlayRatings(ratings, transcriptRatings)
+
+	// video_type=none is a dry run: report what would be censored without
+	// rendering an output.
+	if videoType == "none" {
+		os.Remove(filename)
+		segments := censoredSegments(ratings, ageInt)
+		c.JSON(http.StatusOK, gin.H{
+			"message":           "Dry run, no video produced",
+			"censored_segments": segments,
+			"censored_duration": segmentsDuration(segments),
+			"censored_count":    len(segments),
+		})
+		return
+	}
+
+	opts := defaultConvertOptions(c)
+	opts.Thumbnails = c.PostForm("thumbnails") == "true"
+	opts.Projection = c.PostForm("projection")
+	opts.RedactionLog = c.PostForm("redaction_log") == "true"
+	opts.ExportFrames = c.PostForm("export_frames")
+	opts.OutputName = c.PostForm("output_name")
+	opts.Debug = c.PostForm("debug") == "true"
+	if opts.Debug && len(videoTypes) > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "debug takes a single video_type"})
+		os.Remove(filename)
+		return
+	}
+	if raw := c.PostForm("embed_ratings"); raw != "" {
+		opts.EmbedRatings = raw == "true"
+	}
+	if raw := c.PostForm("fail_safe"); raw != "" {
+		opts.FailSafe = raw == "true"
+	}
+	if raw := c.PostForm("stream_copy"); raw != "" {
+		opts.StreamCopy = raw == "true"
+		// Several outputs are rendered in one pass, which cannot stream-copy.
+		if opts.StreamCopy && len(videoTypes) > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "stream_copy takes a single video_type"})
+			os.Remove(filename)
+			return
+		}
+	}
+	if raw := c.PostForm("profanity"); raw != "" {
+		opts.Profanity, err = parseProfanity(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			os.Remove(filename)
+			return
+		}
 	}
-
-	if len(openAIResp.Choices) == 0 {
-		return "", "", fmt.Errorf("no choices in response")
+	if raw := c.PostForm("audio_censor"); raw != "" {
+		if raw != audioCensorBleep && raw != audioCensorMute {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "audio_censor must be 'bleep' or 'mute'"})
+			os.Remove(filename)
+			return
+		}
+		opts.AudioCensor = raw
 	}
-
-	content := openAIResp.Choices[0].Message.Content
-	fmt.Println("Raw OpenAI Response:", content) // Debug print
-
-	jsonStart := strings.Index(content, "{")
-	if jsonStart == -1 {
-		return "", "", fmt.Errorf("no JSON object found in response")
+	if raw := c.PostForm("frame_step"); raw != "" {
+		opts.FrameStep, err = strconv.Atoi(raw)
+		if err != nil || opts.FrameStep < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid frame_step: %s", raw)})
+			os.Remove(filename)
+			return
+		}
 	}
-
-	jsonText := content[jsonStart:]
-	jsonText = strings.TrimSpace(jsonText)
-	jsonText = strings.TrimSuffix(jsonText, "```")
-
-	var ratingData RatingData
-	if err := json.Unmarshal([]byte(jsonText), &ratingData); err != nil {
-		return "", "", fmt.Errorf("failed to parse rating data: %v", err)
+	if raw := c.PostForm("output_format"); raw != "" {
+		if raw != outputFormatMP4 && raw != outputFormatHLS {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "output_format must be 'mp4' or 'hls'"})
+			os.Remove(filename)
+			return
+		}
+		opts.OutputFormat = raw
 	}
-
-	return ratingData.Rating, ratingData.Notes, nil
-}
-
-func convertVideo(c *gin.Context) {
-	age := c.PostForm("age")
-	videoType := c.PostForm("video_type")
-	ratingsStr := c.PostForm("ratings")
-
-	log.Printf("Raw ratings string: %s", ratingsStr)
-
-	file, err := c.FormFile("video_path")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No video file provided"})
+	if opts.ExportFrames != "" && opts.ExportFrames != exportFramesAll && opts.ExportFrames != exportFramesSegment {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "export_frames must be 'all' or 'segment'"})
+		os.Remove(filename)
 		return
 	}
-
-	var ratings []RatingResult
-
-	if ratingsStr != "" {
-		if err := json.Unmarshal([]byte(ratingsStr), &ratings); err != nil {
-			log.Printf("Error parsing ratings: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid ratings format: %v", err)})
+	if raw := c.PostForm("blur_mode"); raw != "" {
+		if raw != blurModeFrame && raw != blurModeMotion {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "blur_mode must be 'frame' or 'motion'"})
+			os.Remove(filename)
 			return
 		}
-		log.Printf("Parsed %d rating segments", len(ratings))
-		for i, r := range ratings {
-			log.Printf("Rating %d: Start=%.2f, End=%.2f, Rating=%s, Notes=%s",
-				i, r.Start, r.End, r.Rating, r.Notes)
-		}
+		opts.BlurMode = raw
 	}
-
-	if age == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Age is required"})
+	opts.Transform, err = parseSidecar(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		os.Remove(filename)
 		return
 	}
-
-	if videoType == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Video type is required"})
+	if opts.Projection != "" && opts.Projection != projectionEquirectangular {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "projection must be 'equirectangular' if set"})
+		os.Remove(filename)
+		return
+	}
+	// Only blur outputs without censored speech can copy the audio as is.
+	edited := len(opts.Profanity) > 0 || !opts.Debug && !contains(videoTypes, "blur")
+	if err := checkOutputAudio(filename, edited); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		os.Remove(filename)
 		return
 	}
 
-	if age != "6" && age != "12" && age != "16" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Age must be one of: 6, 12, 16"})
+	if mode == convertModeAsync {
+		convertAsync(c, filename, ageInt, ratings, videoType, opts)
+		return
+	}
+	if !checkSyncDuration(c, filename) {
+		os.Remove(filename)
 		return
 	}
 
-	if videoType != "blur" && videoType != "trim" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Video type must be one of: blur, trim"})
+	ctx, cancel, err := processingContext(c, c.PostForm("timeout"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		os.Remove(filename)
 		return
 	}
+	defer cancel()
 
-	filename := filepath.Join(uploadFolder, file.Filename)
-	if err := c.SaveUploadedFile(file, filename); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save video file"})
+	if len(videoTypes) > 1 {
+		convertVariants(ctx, c, filename, ageInt, ratings, videoTypes, opts)
 		return
 	}
 
-	log.Printf("Received convert request: Age=%s, VideoType=%s, VideoFile=%s", age, videoType, file.Filename)
+	if wantsProgressStream(c) {
+		streamConvert(ctx, c, filename, ageInt, ratings, videoType, opts)
+		return
+	}
 
-	ageInt, err := strconv.Atoi(age)
+	result, err := processVideoByAge(ctx, filename, ageInt, ratings, videoType, opts)
 	if err != nil {
-		log.Printf("Error converting age '%s' to integer: %v", age, err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid age format: %s", age)})
+		respondProcessingError(c, err)
 		os.Remove(filename)
 		return
 	}
 
-	log.Printf("Converting age string '%s' to integer: %d", age, ageInt)
+	os.Remove(filename)
+
+	c.JSON(http.StatusOK, convertResponse(c, result))
+}
+
+// convertResponse builds the /convert success body for result.
+func convertResponse(c *gin.Context, result *ConvertResult) gin.H {
+	baseFilename := filepath.Base(result.OutputPath)
+
+	response := gin.H{
+		"message":      "Video processed successfully",
+		"filename":     baseFilename,
+		"download_url": downloadURL(c, baseFilename),
+	}
+	if filepath.Ext(baseFilename) == ".m3u8" {
+		response["playlist_url"] = response["download_url"]
+	}
+	if result.ThumbnailsPath != "" {
+		response["thumbnails_url"] = downloadURL(c, filepath.Base(result.ThumbnailsPath))
+	}
+	if result.RedactionLogPath != "" {
+		response["redaction_log_url"] = downloadURL(c, filepath.Base(result.RedactionLogPath))
+	}
+	if result.FlaggedFramesPath != "" {
+		response["flagged_frames_url"] = downloadURL(c, filepath.Base(result.FlaggedFramesPath))
+	}
+	return response
+}
+
+// convertVariants answers a /convert request for several video types with
+// one output per type.
+func convertVariants(ctx context.Context, c *gin.Context, filename string, age int, ratings []RatingResult, videoTypes []string, opts ConvertOptions) {
+	defer os.Remove(filename)
 
-	outputPath, err := processVideoByAge(filename, ageInt, ratings, videoType)
+	results, err := processVideoVariants(ctx, filename, age, ratings, videoTypes, opts)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		os.Remove(filename)
+		respondProcessingError(c, err)
 		return
 	}
 
-	os.Remove(filename)
+	outputs := make([]gin.H, len(results))
+	for i, result := range results {
+		outputs[i] = convertResponse(c, result.ConvertResult)
+		outputs[i]["video_type"] = result.VideoType
+		delete(outputs[i], "message")
+	}
+
+	response := gin.H{
+		"message": "Video processed successfully",
+		"outputs": outputs,
+	}
+	// The outputs are rendered in one pass over the source, so these
+	// server settings did not apply.
+	var ignored []string
+	if opts.StreamCopy && contains(videoTypes, "blur") {
+		ignored = append(ignored, "BLUR_STREAM_COPY")
+	}
+	if config.EncodeChunks > 1 {
+		ignored = append(ignored, "ENCODE_CHUNKS")
+	}
+	if ignored != nil {
+		response["ignored_settings"] = ignored
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// downloadURL builds the absolute /download URL for a processed file.
+func downloadURL(c *gin.Context, filename string) string {
+	return absoluteURL(c, "/download/"+filename)
+}
 
-	baseFilename := filepath.Base(outputPath)
+// absoluteURL prefixes a server-relative path with the request's scheme and
+// host, as forwarded by a trusted proxy if there is one. Absolute URLs are
+// returned unchanged.
+func absoluteURL(c *gin.Context, path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return path
+	}
 
 	host := c.Request.Host
 	scheme := "http"
 	if c.Request.TLS != nil {
 		scheme = "https"
 	}
+	if forwarded := forwardedHeader(c, "X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+	if forwarded := strings.ToLower(forwardedHeader(c, "X-Forwarded-Proto")); forwarded == "http" || forwarded == "https" {
+		scheme = forwarded
+	}
 
-	downloadURL := fmt.Sprintf("%s://%s/download/%s", scheme, host, baseFilename)
+	return fmt.Sprintf("%s://%s%s", scheme, host, path)
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":      "Video processed successfully",
-		"filename":     baseFilename,
-		"download_url": downloadURL,
-	})
+// parseStreamIndex parses the optional video_stream form field. It returns
+// -1 when no stream was requested, meaning the default stream is used.
+func parseStreamIndex(raw string) (int, error) {
+	if raw == "" {
+		return -1, nil
+	}
+	index, err := strconv.Atoi(raw)
+	if err != nil || index < 0 {
+		return 0, fmt.Errorf("invalid video_stream: %s", raw)
+	}
+	return index, nil
 }
 
 func downloadVideo(c *gin.Context) {
 	filename := c.Param("filename")
-	filePath := filepath.Join(processedFolder, filename)
+	filePath := filepath.Join(tenantProcessedDir(c), filename)
 
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+	// Tenant folders live inside the shared folder, so anything but a
+	// regular file, such as another tenant's folder, is not found.
+	if info, err := os.Stat(filePath); err != nil || !info.Mode().IsRegular() {
+		if os.IsNotExist(err) && processedFiles.known(processedKey(filePath)) {
+			c.JSON(http.StatusGone, gin.H{"error": "File has expired", "code": "EXPIRED"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found", "code": "NOT_FOUND"})
 		return
 	}
 
 	c.Header("Content-Description", "File Transfer")
 	c.Header("Content-Transfer-Encoding", "binary")
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-	c.Header("Content-Type", "video/mp4")
+	contentType := mime.TypeByExtension(filepath.Ext(filename))
+	if contentType == "" {
+		contentType = "video/mp4"
+	}
+	c.Header("Content-Type", contentType)
 	c.File(filePath)
 }
 
-func processVideoByAge(videoPath string, age int, ratings []RatingResult, videoType string) (string, error) {
-	timestamp := time.Now().UnixNano()
-	outputFilename := fmt.Sprintf("processed_%d.mp4", timestamp)
-	outputPath := filepath.Join(processedFolder, outputFilename)
+// ConvertOptions holds optional per-request settings for processVideoByAge.
+type ConvertOptions struct {
+	// Thumbnails also produces a sprite sheet and WebVTT thumbnail track.
+	Thumbnails bool
+	// Projection is "equirectangular" for 360 video, which blurs across the
+	// left/right seam and tags the output; empty for flat video.
+	Projection string
+	// Progress, if set, is updated as source frames are processed.
+	Progress *jobProgress
+	// RedactionLog also writes a CSV of every censored segment.
+	RedactionLog bool
+	// Debug marks what would be censored instead of censoring it: blurred
+	// frames and regions are tinted, and trim keeps the frames it would
+	// cut, tinted in another color.
+	Debug bool
+	// ExportFrames, "all" or "segment", also saves every censored source
+	// frame, or the first of each censored segment, to a zip of JPEGs.
+	ExportFrames string
+	// FlaggedFrames collects those frames during the render; it is set by
+	// processVideoByAge and processVideoVariants.
+	FlaggedFrames *frameExporter
+	// OutputDir is where outputs are written; empty means processedFolder.
+	OutputDir string
+	// OutputName is the client's base name for the output, replacing
+	// "processed". It is sanitized by outputBaseName.
+	OutputName string
+	// EmbedRatings tags the output with its ratings and, for blur, adds a
+	// chapter per rated segment.
+	EmbedRatings bool
+	// FrameStep keeps every FrameStep-th frame in blur outputs to speed
+	// up encoding; 0 or 1 keeps every frame. Trim outputs ignore it.
+	FrameStep int
+	// BlurMode "motion" limits whole-frame blurs to the regions that
+	// changed since the previous frame; empty or "frame" blurs it all.
+	BlurMode string
+	// OutputFormat "hls" delivers the output as an HLS playlist and
+	// segments instead of one MP4.
+	OutputFormat string
+	// FailSafe replaces unclassified frames (see uncertainAt) with a
+	// placeholder in blur outputs and cuts them from trim outputs.
+	FailSafe bool
+	// Profanity spans of the source audio are censored per AudioCensor,
+	// "bleep" or "mute".
+	Profanity   []ProfanitySegment
+	AudioCensor string
+	// StreamCopy re-encodes only the parts of a blur output around blurred
+	// frames and stream-copies the rest; see encodeStreamCopy.
+	StreamCopy bool
+	// Transform, if set, corrects each frame before it is censored and
+	// encoded. Ratings must come from an analysis with the same transform.
+	Transform *FrameTransform
+}
+
+// defaultConvertOptions returns the options every conversion starts from:
+// the server's settings, with outputs in the request's tenant folder.
+func defaultConvertOptions(c *gin.Context) ConvertOptions {
+	return ConvertOptions{
+		OutputDir:    tenantProcessedDir(c),
+		EmbedRatings: config.EmbedRatings,
+		FrameStep:    config.BlurFrameStep,
+		BlurMode:     config.BlurMode,
+		FailSafe:     config.FailSafe,
+		StreamCopy:   config.BlurStreamCopy,
+		AudioCensor:  config.AudioCensor,
+	}
+}
+
+func (o ConvertOptions) outputDir() string {
+	if o.OutputDir == "" {
+		return processedFolder
+	}
+	return o.OutputDir
+}
+
+// frameStep returns the frame decimation step for a videoType output of
+// a source at fps: FrameStep for blur, raised for any type as needed to
+// keep the output at or below MAX_OUTPUT_FPS.
+func (o ConvertOptions) frameStep(videoType string, fps float64) int {
+	step := 1
+	if videoType == "blur" && o.FrameStep > 1 {
+		step = o.FrameStep
+	}
+	if config.MaxOutputFPS > 0 && fps/float64(step) > config.MaxOutputFPS {
+		step = int(math.Ceil(fps / config.MaxOutputFPS))
+	}
+	return step
+}
+
+// motionMasker returns the masker for a blur render, nil unless BlurMode
+// is motion.
+func (o ConvertOptions) motionMasker() *motionMasker {
+	if o.BlurMode != blurModeMotion {
+		return nil
+	}
+	return newMotionMasker()
+}
+
+// failSafeFrame returns the placeholder for a blur render, nil unless
+// FailSafe is set.
+func (o ConvertOptions) failSafeFrame() *placeholderFrame {
+	if !o.FailSafe {
+		return nil
+	}
+	return &placeholderFrame{}
+}
+
+// outputBaseName returns the stem of output file names, ending in unique,
+// the job ID. A client-supplied name is reduced to a safe file name and
+// unique is always appended, so names cannot traverse directories or
+// collide with another job's output.
+func (o ConvertOptions) outputBaseName(unique string) string {
+	name := strings.TrimSuffix(filepath.Base(o.OutputName), filepath.Ext(o.OutputName))
+	name = strings.Trim(unsafeKeyChars.ReplaceAllString(name, "_"), "._")
+	if name == "" {
+		name = "processed"
+	}
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return name + "_" + unique
+}
+
+// ConvertResult lists the files produced by processVideoByAge.
+type ConvertResult struct {
+	OutputPath       string
+	ThumbnailsPath   string
+	RedactionLogPath string
+	// FlaggedFramesPath is the zip of censored frames, if requested.
+	FlaggedFramesPath string
+}
+
+// paths returns the output and every by-product that was written,
+// including the thumbnail sprite and HLS segments they refer to.
+func (r *ConvertResult) paths() []string {
+	var paths []string
+	for _, path := range []string{r.OutputPath, r.ThumbnailsPath, r.RedactionLogPath, r.FlaggedFramesPath} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if r.ThumbnailsPath != "" {
+		paths = append(paths, thumbnailSpritePath(r.ThumbnailsPath))
+	}
+	if filepath.Ext(r.OutputPath) == ".m3u8" {
+		paths = append(paths, hlsSegments(r.OutputPath)...)
+	}
+	return paths
+}
+
+// remove deletes every file of r, for an output that is not handed out.
+func (r *ConvertResult) remove() {
+	for _, path := range r.paths() {
+		os.Remove(path)
+		processedFiles.forget(processedKey(path))
+	}
+}
+
+func processVideoByAge(ctx context.Context, videoPath string, age int, ratings []RatingResult, videoType string, opts ConvertOptions) (*ConvertResult, error) {
+	release, err := encodeLimit.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if opts.Progress == nil {
+		opts.Progress = contextProgress(ctx)
+	}
+
+	claimed, release := claimOutputPaths(ctx, opts, ".mp4")
+	defer release()
+	outputPath := claimed[0]
+
+	exporter, err := newFrameExporter(opts.ExportFrames, opts.outputDir())
+	if err != nil {
+		return nil, err
+	}
+	defer exporter.Close()
+	opts.FlaggedFrames = exporter
 
 	video, err := gocv.VideoCaptureFile(videoPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to open video: %v", err)
+		return nil, fmt.Errorf("failed to open video: %v", err)
 	}
 	defer video.Close()
 
@@ -483,165 +1764,449 @@ func processVideoByAge(videoPath string, age int, ratings []RatingResult, videoT
 	if fps <= 0 {
 		fps = 30 // Default to 30fps if unable to determine
 	}
-	width := int(video.Get(gocv.VideoCaptureFrameWidth))
-	height := int(video.Get(gocv.VideoCaptureFrameHeight))
+	width, height, err := opts.Transform.size(int(video.Get(gocv.VideoCaptureFrameWidth)), int(video.Get(gocv.VideoCaptureFrameHeight)))
+	if err != nil {
+		return nil, err
+	}
 	totalFrames := int(video.Get(gocv.VideoCaptureFrameCount))
+	opts.Progress.setTotal(totalFrames, fps)
+	ratings = clampEdgeSegments(ratings, fps, totalFrames)
 
-	writer, err := gocv.VideoWriterFile(
-		outputPath,
-		"mp4v", // codec
-		fps,
-		width,
-		height,
-		true,
-	)
-	if err != nil {
-		return "", fmt.Errorf("failed to create video writer: %v", err)
+	writerPath := strings.TrimSuffix(outputPath, ".mp4") + "_video.mp4"
+
+	var thumbnails *thumbnailSheet
+	if opts.Thumbnails {
+		thumbnails = newThumbnailSheet(width, height)
+		defer thumbnails.Close()
+	}
+
+	var writtenFrames int
+	copied := false
+	if videoType == "blur" && opts.StreamCopy {
+		writtenFrames, err = encodeStreamCopy(ctx, videoPath, writerPath, ratings, age, opts, fps, width, height, totalFrames)
+		if errors.Is(err, errStreamCopyUnsupported) {
+			log.Printf("%v, encoding the whole video", err)
+		} else if err != nil {
+			os.Remove(writerPath)
+			return nil, err
+		} else {
+			copied = true
+		}
 	}
-	defer writer.Close()
 
-	if videoType == "blur" {
-		err = blurInappropriateContent(video, writer, ratings, age, fps, totalFrames)
+	if copied {
+		if thumbnails != nil {
+			if err := thumbnails.addFromFile(writerPath); err != nil {
+				return nil, err
+			}
+		}
+	} else if config.EncodeChunks > 1 && totalFrames >= config.EncodeChunks {
+		video.Close()
+		writtenFrames, err = encodeChunked(ctx, videoPath, writerPath, ratings, age, videoType, opts, fps, width, height, totalFrames)
+		if err != nil {
+			os.Remove(writerPath)
+			return nil, err
+		}
+		if thumbnails != nil {
+			if err := thumbnails.addFromFile(writerPath); err != nil {
+				return nil, err
+			}
+		}
 	} else {
-		err = trimInappropriateContent(video, writer, ratings, age, fps, totalFrames) // trim
+		writer, err := newOutputWriter(writerPath, fps/float64(opts.frameStep(videoType, fps)), width, height)
+		if err != nil {
+			return nil, err
+		}
+		defer writer.Close()
+		writer.thumbnails = thumbnails
+		writer.progress = opts.Progress
+
+		if videoType == "blur" {
+			err = blurInappropriateContent(ctx, video, writer, ratings, age, opts, fps, 0, totalFrames)
+		} else {
+			err = trimInappropriateContent(ctx, video, writer, ratings, age, opts, fps, 0, totalFrames) // trim
+		}
+
+		if err != nil {
+			writer.Close()
+			os.Remove(writerPath)
+			return nil, err
+		}
+		writer.Close()
+		writtenFrames = writer.frames
 	}
 
-	if err != nil {
-		return "", err
+	return completeOutput(ctx, videoPath, writerPath, outputPath, ratings, age, videoType, opts, thumbnails, writtenFrames, fps, totalFrames)
+}
+
+// completeOutput finalizes the video-only file at writerPath, into which
+// frames frames were written, into outputPath and writes the requested
+// by-products next to it. fps and totalFrames describe the source. The
+// files and ratings are recorded on the job of ctx.
+func completeOutput(ctx context.Context, videoPath, writerPath, outputPath string, ratings []RatingResult, age int, videoType string, opts ConvertOptions, thumbnails *thumbnailSheet, frames int, fps float64, totalFrames int) (*ConvertResult, error) {
+	// gocv writes video only; ffmpeg then adds audio and metadata. Blur
+	// keeps the source timing, so its audio is muxed back in as is. Trim
+	// cuts the audio to the frames it keeps, except in debug outputs,
+	// which keep every frame.
+	audio := audioEdit{censored: opts.Profanity, censorMode: opts.AudioCensor}
+	if videoType == "trim" && !opts.Debug {
+		audio.keep = trimmedAudioRanges(ratings, age, opts.FailSafe, fps, totalFrames)
+	}
+
+	var tags []string
+	if opts.Projection != "" {
+		tags = append(tags, "projection="+opts.Projection)
+	}
+	if opts.Debug {
+		tags = append(tags, debugOutputTag+"=true")
+	}
+
+	// Chapters use source timestamps, so they only fit blur outputs.
+	var chaptersPath string
+	if opts.EmbedRatings {
+		tags = append(tags, ratingTags(ratings, age)...)
+		if videoType == "blur" && len(ratings) > 0 {
+			chaptersPath = strings.TrimSuffix(writerPath, filepath.Ext(writerPath)) + "_chapters.txt"
+			if err := writeChapters(chaptersPath, ratings, age); err != nil {
+				return nil, err
+			}
+			defer os.Remove(chaptersPath)
+		}
+	}
+
+	if err := finalizeOutput(videoPath, writerPath, outputPath, config.AudioMux, audio, chaptersPath, tags...); err != nil {
+		os.Remove(writerPath)
+		return nil, err
+	}
+
+	if config.VerifyOutput {
+		if err := verifyOutput(outputPath, frames); err != nil {
+			os.Remove(outputPath)
+			return nil, fmt.Errorf("output failed integrity check: %v", err)
+		}
+	}
+
+	if opts.OutputFormat == outputFormatHLS {
+		playlistPath, err := segmentHLS(outputPath)
+		if err != nil {
+			os.Remove(outputPath)
+			return nil, err
+		}
+		outputPath = playlistPath
+	}
+
+	processedFiles.record(processedKey(outputPath))
+	result := &ConvertResult{OutputPath: outputPath}
+
+	var err error
+	if thumbnails != nil {
+		result.ThumbnailsPath, err = thumbnails.write(outputPath)
+		if err != nil {
+			log.Printf("Failed to generate thumbnails: %v", err)
+		}
+	}
+
+	if opts.RedactionLog {
+		result.RedactionLogPath, err = writeRedactionLog(outputPath, ratings, age, videoType)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return outputPath, nil
+	if opts.FlaggedFrames != nil {
+		result.FlaggedFramesPath, err = opts.FlaggedFrames.archive(outputPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	recordJobRatings(ctx, ratings)
+	recordJobOutputs(ctx, result)
+	return result, nil
 }
 
-func blurInappropriateContent(video *gocv.VideoCapture, writer *gocv.VideoWriter, ratings []RatingResult, age int, fps float64, totalFrames int) error {
+// blurInappropriateContent writes frames [startFrame, endFrame) of video,
+// blurring those in over-age segments and keeping only every
+// opts.FrameStep-th frame. video must be positioned at startFrame.
+func blurInappropriateContent(ctx context.Context, video *gocv.VideoCapture, writer *outputWriter, ratings []RatingResult, age int, opts ConvertOptions, fps float64, startFrame, endFrame int) error {
 	img := gocv.NewMat()
 	defer img.Close()
 
 	blurred := gocv.NewMat()
 	defer blurred.Close()
 
-	frameIndex := 0
+	motion := opts.motionMasker()
+	defer motion.Close()
+	placeholder := opts.failSafeFrame()
+	defer placeholder.Close()
+
+	step := opts.frameStep("blur", fps)
+
+	frameIndex := startFrame
 	for {
-		if ok := video.Read(&img); !ok || img.Empty() || frameIndex >= totalFrames {
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+		if frameIndex >= endFrame {
+			break
+		}
+		lost, ok := readFrame(video, &img, frameIndex, endFrame)
+		if !ok {
 			break
 		}
 
-		timestamp := float64(frameIndex) / fps
-		shouldBlur := false
+		// Lost frames are filled with the next readable one so the output
+		// keeps the source timing. Only every step-th frame is kept,
+		// counted from the start of the video so chunks line up.
+		var frame gocv.Mat
+		rendered := false
+		for i := 0; i <= lost; i++ {
+			if (frameIndex+i)%step == 0 {
+				if !rendered {
+					opts.Transform.apply(&img)
+					opts.FlaggedFrames.add(img, ratings, age, float64(frameIndex+lost)/fps)
+					frame = blurFrame(img, &blurred, ratings, age, opts.Projection, motion, placeholder, opts.Debug, float64(frameIndex+lost)/fps)
+					rendered = true
+				}
+				writer.Write(frame)
+			}
+			writer.progress.advance()
+		}
+		frameIndex += lost + 1
+	}
+
+	return nil
+}
+
+// blurFrame returns the blurred output frame for img at timestamp: img
+// itself when no over-age segment covers it, otherwise dst holding a
+// fully or region-blurred copy. With a motion masker, whole-frame blurs
+// cover only what changed since the previous frame. With a placeholder,
+// unclassified frames are replaced by it. img is left unchanged.
+func blurFrame(img gocv.Mat, dst *gocv.Mat, ratings []RatingResult, age int, projection string, motion *motionMasker, placeholder *placeholderFrame, debug bool, timestamp float64) gocv.Mat {
+	if motion != nil {
+		defer motion.remember(img)
+	}
+	if placeholder != nil && uncertainAt(ratings, timestamp, config.FailSafeConfidence) {
+		if debug {
+			return markFrame(img, dst, debugUncertainColor)
+		}
+		return placeholder.frame(img)
+	}
 
-		for _, rating := range ratings {
-			if timestamp >= rating.Start && timestamp <= rating.End {
-				ratingValue := getRatingValue(rating.Rating)
-				if ratingValue > age {
+	shouldBlur := false
+	var regions []BlurRegion
+
+	for _, rating := range ratings {
+		if rating.covers(timestamp) {
+			ratingValue := getRatingValue(rating.Rating)
+			if ratingValue > age {
+				if len(rating.Regions) == 0 {
 					shouldBlur = true
 					break
 				}
+				regions = append(regions, rating.regionsAt(timestamp)...)
 			}
 		}
+	}
 
-		if shouldBlur {
-			gocv.GaussianBlur(img, &blurred, image.Point{X: 45, Y: 45}, 0, 0, gocv.BorderDefault)
-			writer.Write(blurred)
-		} else {
-			writer.Write(img)
+	if motion != nil && shouldBlur {
+		if changed := motion.changedRegions(img); changed != nil {
+			shouldBlur = false
+			regions = append(regions, changed...)
 		}
-
-		frameIndex++
 	}
 
-	return nil
+	if shouldBlur {
+		if debug {
+			return markFrame(img, dst, debugBlurColor)
+		}
+		if projection == projectionEquirectangular {
+			blurEquirect(img, dst)
+		} else {
+			gocv.GaussianBlur(img, dst, image.Point{X: blurKernel, Y: blurKernel}, 0, 0, gocv.BorderDefault)
+		}
+		return *dst
+	}
+	if len(regions) > 0 {
+		img.CopyTo(dst)
+		if debug {
+			markRegions(dst, regions)
+		} else if projection == projectionEquirectangular {
+			blurEquirectRegions(dst, regions)
+		} else {
+			blurRegions(dst, regions)
+		}
+		return *dst
+	}
+	if kernel := rampKernel(ratings, age, timestamp); kernel > 0 {
+		if debug {
+			return markFrame(img, dst, debugRampColor)
+		}
+		gocv.GaussianBlur(img, dst, image.Point{X: kernel, Y: kernel}, 0, 0, gocv.BorderDefault)
+		return *dst
+	}
+	return img
 }
 
-func trimInappropriateContent(video *gocv.VideoCapture, writer *gocv.VideoWriter, ratings []RatingResult, age int, fps float64, totalFrames int) error {
+// trimInappropriateContent writes the frames in [startFrame, endFrame) of
+// video that fall in age-appropriate segments. video must be positioned at
+// startFrame.
+func trimInappropriateContent(ctx context.Context, video *gocv.VideoCapture, writer *outputWriter, ratings []RatingResult, age int, opts ConvertOptions, fps float64, startFrame, endFrame int) error {
 	img := gocv.NewMat()
 	defer img.Close()
 
-	frameIndex := 0
+	step := opts.frameStep("trim", fps)
+
+	marked := gocv.NewMat()
+	defer marked.Close()
+
+	frameIndex := startFrame
 	includedFrames := 0
-	log.Printf("Starting trim process: Age=%d, FPS=%f, TotalFrames=%d", age, fps, totalFrames)
-	log.Printf("Ratings data: %+v", ratings)
+	log.Printf("Starting trim process: Age=%d, FPS=%f, Frames=%d-%d", age, fps, startFrame, endFrame)
+	slog.Debug("Trim ratings", "ratings", ratings)
 
-	// Sort ratings by start time to ensure we process them in order
-	sort.Slice(ratings, func(i, j int) bool {
-		return ratings[i].Start < ratings[j].Start
-	})
+	ratings = sortedRatings(ratings)
 
 	for {
-		if ok := video.Read(&img); !ok || img.Empty() || frameIndex >= totalFrames {
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+		if frameIndex >= endFrame {
 			break
 		}
-
-		timestamp := float64(frameIndex) / fps
-		shouldInclude := false // Default to not including the frame
-		matchedRating := "none"
-		inRatedSegment := false
-
-		// Check if this frame is in any rated segment
-		for _, rating := range ratings {
-			// Use a small epsilon for floating point comparison to avoid rounding issues
-			const epsilon = 0.001
-			isAfterStart := timestamp >= (rating.Start - epsilon)
-			isBeforeEnd := timestamp <= (rating.End + epsilon)
-
-			if isAfterStart && isBeforeEnd {
-				inRatedSegment = true
-				matchedRating = rating.Rating
-
-				// Only include if the rating is appropriate for the age
-				ratingValue := getRatingValue(rating.Rating)
-				if ratingValue <= age {
-					shouldInclude = true
-					break
-				} else {
-					// If we found a segment but it's not appropriate, don't include
-					shouldInclude = false
-					break
-				}
-			}
+		lost, ok := readFrame(video, &img, frameIndex, endFrame)
+		if !ok {
+			break
+		}
+		for i := 0; i < lost; i++ {
+			writer.progress.advance()
 		}
+		frameIndex += lost
 
-		// If frame is not in any rated segment, don't include it
-		if !inRatedSegment {
-			matchedRating = "unrated"
+		timestamp := float64(frameIndex) / fps
+		shouldInclude, matchedRating := trimKeepsFrame(ratings, age, timestamp)
+		if shouldInclude && opts.FailSafe && uncertainAt(ratings, timestamp, config.FailSafeConfidence) {
 			shouldInclude = false
 		}
 
 		if frameIndex%int(fps) == 0 { // Log once per second
-			log.Printf("Frame %d (%.2fs): Rating=%s, InRatedSegment=%v, Include=%v",
-				frameIndex, timestamp, matchedRating, inRatedSegment, shouldInclude)
+			slog.Debug("Trim frame", "frame", frameIndex, "timestamp", timestamp, "rating", matchedRating, "include", shouldInclude)
 		}
 
-		if shouldInclude {
-			writer.Write(img)
-			includedFrames++
+		// Only every step-th frame is kept, counted from the start of the
+		// video so chunks line up. Debug outputs keep cut frames too,
+		// marked, so the timeline is unchanged.
+		if frameIndex%step == 0 && (shouldInclude || opts.FlaggedFrames != nil || opts.Debug) {
+			opts.Transform.apply(&img)
+			opts.FlaggedFrames.add(img, ratings, age, timestamp)
+			if shouldInclude {
+				writer.Write(img)
+				includedFrames++
+			} else if opts.Debug {
+				writer.Write(markFrame(img, &marked, debugTrimColor))
+			}
 		}
 
+		writer.progress.advance()
 		frameIndex++
 	}
 
 	log.Printf("Trim complete: Processed %d frames, Included %d frames (%.2f seconds)",
-		frameIndex, includedFrames, float64(includedFrames)/fps)
+		frameIndex-startFrame, includedFrames, float64(includedFrames*step)/fps)
 	return nil
 }
 
+// sortedRatings returns a copy of ratings sorted by start time, as
+// trimKeepsFrame expects. Chunked encodes share the caller's slice, so it
+// is not sorted in place.
+func sortedRatings(ratings []RatingResult) []RatingResult {
+	ratings = append([]RatingResult(nil), ratings...)
+	sort.SliceStable(ratings, func(i, j int) bool {
+		return ratings[i].Start < ratings[j].Start
+	})
+	return ratings
+}
+
+// trimKeepsFrame reports whether the frame at timestamp belongs in a trim
+// output, along with the rating of the segment covering it ("unrated" if
+// none does). Only frames in a segment appropriate for age are kept.
+// ratings must be sorted by start time.
+func trimKeepsFrame(ratings []RatingResult, age int, timestamp float64) (bool, string) {
+	for _, rating := range ratings {
+		if rating.covers(timestamp) {
+			return getRatingValue(rating.Rating) <= age, rating.Rating
+		}
+	}
+	return false, "unrated"
+}
+
 func getRatingValue(rating string) int {
-	var value int
-	switch rating {
-	case "6+":
-		value = 6
-	case "12+":
-		value = 12
-	case "16+":
-		value = 16
-	case "18+":
-		value = 18
-	default:
-		value = 0
-	}
-	log.Printf("Converting rating '%s' to value: %d", rating, value)
+	value := 0
+	if level := ratingLevel(rating); level >= 0 {
+		value = config.RatingScale[level].Value
+	}
+	slog.Debug("Rating value", "rating", rating, "value", value)
 	return value
 }
 
+// applyNoteRatingFloors escalates a model rating when its notes mention a
+// keyword configured with a stricter minimum rating.
+func applyNoteRatingFloors(rating, notes string) string {
+	effective := rating
+	for _, note := range strings.Split(notes, ",") {
+		note = strings.TrimSpace(strings.ToLower(note))
+		if note == "" {
+			continue
+		}
+		for keyword, floor := range config.NoteRatingFloors {
+			if strings.Contains(note, keyword) && getRatingValue(floor) > getRatingValue(effective) {
+				slog.Debug("Escalating rating for note", "from", effective, "to", floor, "note", note)
+				effective = floor
+			}
+		}
+	}
+	return effective
+}
+
+// keepNote reports whether a normalized note belongs in a segment's notes.
+// Empty and "none" notes, configured stopwords and notes shorter than
+// NOTE_MIN_LENGTH are dropped. Rating floors still see every note.
+func keepNote(note string) bool {
+	if note == "" || note == "none" || len(note) < config.NoteMinLength {
+		return false
+	}
+	return !contains(config.NoteStopwords, note)
+}
+
+// notesEllipsis marks a segment whose notes were cut to MAX_NOTES.
+const notesEllipsis = "..."
+
+// joinNotes formats a segment's note counts as a sorted, comma-separated
+// list. With MAX_NOTES set, only the most frequent notes are kept and
+// notesEllipsis is appended in place of the rest.
+func joinNotes(counts map[string]int) string {
+	notes := make([]string, 0, len(counts))
+	for note := range counts {
+		notes = append(notes, note)
+	}
+	truncated := config.MaxNotes > 0 && len(notes) > config.MaxNotes
+	if truncated {
+		sort.Slice(notes, func(i, j int) bool {
+			if counts[notes[i]] != counts[notes[j]] {
+				return counts[notes[i]] > counts[notes[j]]
+			}
+			return notes[i] < notes[j]
+		})
+		notes = notes[:config.MaxNotes]
+	}
+	sort.Strings(notes)
+	if truncated {
+		notes = append(notes, notesEllipsis)
+	}
+	return strings.Join(notes, ", ")
+}
+
 // callGPTOSSClassifier calls the Python GPT-OSS classifier
 func callGPTOSSClassifier(metadata map[string]interface{}, transcript string, visionLabels []string) (*GPTOSSResponse, error) {
 	input := GPTOSSInput{
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"gocv.io/x/gocv"
+)
+
+// Kinds of media accepted by /upload.
+const (
+	mediaVideo = "video"
+	mediaImage = "image"
+)
+
+// errAudioOnly reports an upload with sound but no pictures, which
+// handlers answer with 400 AUDIO_ONLY.
+var errAudioOnly = errors.New("file contains only audio, a video stream is required")
+
+// mediaErrorCode is the error code for a detectMediaKind failure.
+func mediaErrorCode(err error) string {
+	if err == errAudioOnly {
+		return "AUDIO_ONLY"
+	}
+	return "INVALID_VIDEO"
+}
+
+// detectMediaKind uses ffprobe to tell real videos from single images and
+// audio-only files, which gocv otherwise opens unpredictably. Cover art
+// attached to audio files is not counted as video. If ffprobe cannot read
+// the file it is assumed to be a video and left to checkReadableVideo.
+func detectMediaKind(path string) (string, error) {
+	streams, err := probeStreams(path)
+	if err != nil {
+		log.Printf("Could not probe %s, assuming video: %v", path, err)
+		return mediaVideo, nil
+	}
+
+	hasVideo, hasAudio := false, false
+	for _, stream := range streams {
+		switch {
+		case stream.CodecType == "video" && stream.Disposition.AttachedPic == 0:
+			hasVideo = true
+		case stream.CodecType == "audio":
+			hasAudio = true
+		}
+	}
+	if !hasVideo {
+		if hasAudio {
+			return "", errAudioOnly
+		}
+		return "", fmt.Errorf("file contains no video stream")
+	}
+
+	frames, err := countVideoPackets(path)
+	if err != nil {
+		log.Printf("Could not count frames in %s, assuming video: %v", path, err)
+		return mediaVideo, nil
+	}
+	if frames <= 1 {
+		return mediaImage, nil
+	}
+	return mediaVideo, nil
+}
+
+// analysisImageTypes maps the ANALYSIS_IMAGE_FORMAT choices to the MIME
+// types of their data URLs.
+var analysisImageTypes = map[string]string{
+	"jpg":  "image/jpeg",
+	"webp": "image/webp",
+}
+
+// encodeSample downscales img for the vision model and returns it as a
+// data URL in ANALYSIS_IMAGE_FORMAT.
+func encodeSample(img gocv.Mat, interpolation gocv.InterpolationFlags, size int) (string, error) {
+	resized := gocv.NewMat()
+	defer resized.Close()
+	gocv.Resize(img, &resized, image.Point{X: size, Y: size}, 0, 0, interpolation)
+
+	format := config.AnalysisImageFormat
+	buf, err := gocv.IMEncode(gocv.FileExt("."+format), resized)
+	if err != nil {
+		return "", err
+	}
+	defer buf.Close()
+
+	return "data:" + analysisImageTypes[format] + ";base64," + base64.StdEncoding.EncodeToString(buf.GetBytes()), nil
+}
+
+// Bounds of the size, in pixels, frames are scaled to for analysis.
+const (
+	minAnalysisSize = 128
+	maxAnalysisSize = 2048
+)
+
+// lowResolution reports whether the pictures of path, once corrected by
+// transform, are shorter than MIN_ANALYSIS_RESOLUTION pixels on their
+// shorter side. Scaled to the analysis resolution, such frames are too soft for
+// reliable ratings. Files that cannot be probed are not flagged.
+func lowResolution(path string, transform *FrameTransform) bool {
+	if config.MinAnalysisResolution <= 0 {
+		return false
+	}
+	streams, err := probeStreams(path)
+	if err != nil {
+		return false
+	}
+	for _, stream := range streams {
+		if stream.CodecType != "video" || stream.Disposition.AttachedPic != 0 || stream.Width <= 0 {
+			continue
+		}
+		width, height, err := transform.size(stream.Width, stream.Height)
+		return err == nil && min(width, height) < config.MinAnalysisResolution
+	}
+	return false
+}
+
+// checkAnalysisImageFormat falls back to JPEG analysis frames if the
+// installed OpenCV cannot encode ANALYSIS_IMAGE_FORMAT.
+func checkAnalysisImageFormat() {
+	if config.AnalysisImageFormat == "jpg" {
+		return
+	}
+	frame := gocv.NewMatWithSize(16, 16, gocv.MatTypeCV8UC3)
+	defer frame.Close()
+	buf, err := gocv.IMEncode(gocv.FileExt("."+config.AnalysisImageFormat), frame)
+	if err == nil {
+		defer buf.Close()
+		if buf.Len() > 0 {
+			return
+		}
+		err = fmt.Errorf("empty output")
+	}
+	log.Printf("OpenCV cannot encode %s analysis frames, using jpg instead: %v", config.AnalysisImageFormat, err)
+	config.AnalysisImageFormat = "jpg"
+}
+
+// processImage rates a single image the same way processVideo rates one
+// sampled frame, returning one rating at timestamp 0.
+func processImage(ctx context.Context, imagePath string, opts AnalysisOptions) ([]RatingResult, error) {
+	release, err := analysisLimit.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	img := gocv.IMRead(imagePath, gocv.IMReadColor)
+	defer img.Close()
+	if img.Empty() {
+		return nil, fmt.Errorf("failed to read image")
+	}
+	if _, _, err := opts.Transform.size(img.Cols(), img.Rows()); err != nil {
+		return nil, err
+	}
+	opts.Transform.apply(&img)
+
+	var openAIDown atomic.Bool
+	sample, err := analyzeSample(ctx, img, 0, opts, &openAIDown)
+	if err != nil {
+		return nil, err
+	}
+	if sample == nil {
+		return nil, fmt.Errorf("failed to encode image")
+	}
+	opts.Timeline.record(0, *sample)
+
+	result := RatingResult{
+		Rating:     sample.Rating,
+		Confidence: sample.Confidence,
+		Regions:    sample.Regions,
+	}
+	if opts.Verbose {
+		result.Samples = []SampleDetail{newSampleDetail(0, *sample)}
+	}
+
+	var kept []string
+	for _, note := range strings.Split(sample.Notes, ",") {
+		note = strings.TrimSpace(strings.ToLower(note))
+		if keepNote(note) && !contains(kept, note) {
+			kept = append(kept, note)
+		}
+	}
+	result.Notes = strings.Join(kept, ", ")
+
+	return []RatingResult{result}, nil
+}
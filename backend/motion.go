@@ -0,0 +1,92 @@
+package main
+
+import (
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// Blur modes for /convert. blurModeMotion limits whole-frame blurs to the
+// parts of the frame that changed since the previous one.
+const (
+	blurModeFrame  = "frame"
+	blurModeMotion = "motion"
+)
+
+// motionMasker finds the regions of a frame that differ from the frame
+// before it, a cheap stand-in for bounding boxes when only an overlay or
+// a small element moves in a flagged scene.
+type motionMasker struct {
+	prev   gocv.Mat
+	gray   gocv.Mat
+	diff   gocv.Mat
+	kernel gocv.Mat
+}
+
+func newMotionMasker() *motionMasker {
+	return &motionMasker{
+		prev:   gocv.NewMat(),
+		gray:   gocv.NewMat(),
+		diff:   gocv.NewMat(),
+		kernel: gocv.GetStructuringElement(gocv.MorphRect, image.Point{X: 15, Y: 15}),
+	}
+}
+
+// Close is safe on a nil masker, which is what blur mode "frame" uses.
+func (m *motionMasker) Close() {
+	if m == nil {
+		return
+	}
+	m.prev.Close()
+	m.gray.Close()
+	m.diff.Close()
+	m.kernel.Close()
+}
+
+// changedRegions returns the regions of img that differ from the previous
+// frame passed to remember. It returns nil when there is no previous frame,
+// nothing changed, or so much changed that blurring the whole frame is the
+// better choice; callers then fall back to a full blur.
+func (m *motionMasker) changedRegions(img gocv.Mat) []BlurRegion {
+	if m.prev.Empty() {
+		return nil
+	}
+
+	gocv.CvtColor(img, &m.gray, gocv.ColorBGRToGray)
+	gocv.GaussianBlur(m.gray, &m.gray, image.Point{X: 5, Y: 5}, 0, 0, gocv.BorderDefault)
+	gocv.AbsDiff(m.gray, m.prev, &m.diff)
+	gocv.Threshold(m.diff, &m.diff, float32(config.MotionThreshold), 255, gocv.ThresholdBinary)
+	// Dilating merges nearby changes and pads the regions.
+	gocv.Dilate(m.diff, &m.diff, m.kernel)
+
+	width, height := img.Cols(), img.Rows()
+	frameArea := float64(width * height)
+	if float64(gocv.CountNonZero(m.diff)) > frameArea*config.MotionMaxArea {
+		return nil
+	}
+
+	contours := gocv.FindContours(m.diff, gocv.RetrievalExternal, gocv.ChainApproxSimple)
+	defer contours.Close()
+
+	var regions []BlurRegion
+	for i := 0; i < contours.Size(); i++ {
+		rect := gocv.BoundingRect(contours.At(i))
+		if float64(rect.Dx()*rect.Dy()) < frameArea*config.MotionMinArea {
+			continue
+		}
+		regions = append(regions, BlurRegion{
+			X:      float64(rect.Min.X) / float64(width),
+			Y:      float64(rect.Min.Y) / float64(height),
+			Width:  float64(rect.Dx()) / float64(width),
+			Height: float64(rect.Dy()) / float64(height),
+		})
+	}
+	return regions
+}
+
+// remember stores img as the previous frame for the next changedRegions.
+// It must see every frame, flagged or not.
+func (m *motionMasker) remember(img gocv.Mat) {
+	gocv.CvtColor(img, &m.prev, gocv.ColorBGRToGray)
+	gocv.GaussianBlur(m.prev, &m.prev, image.Point{X: 5, Y: 5}, 0, 0, gocv.BorderDefault)
+}
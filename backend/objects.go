@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SignUploadRequest struct {
+	Filename string `json:"filename" binding:"required"`
+}
+
+type ObjectAnalyzeRequest struct {
+	ObjectKey    string `json:"object_key" binding:"required"`
+	Timeout      string `json:"timeout"`
+	SceneContext string `json:"scene_context"`
+}
+
+type ObjectConvertRequest struct {
+	ObjectKey  string         `json:"object_key" binding:"required"`
+	Age        string         `json:"age" binding:"required"`
+	Ratings    []RatingResult `json:"ratings" binding:"required"`
+	VideoType  string         `json:"video_type" binding:"required,oneof=blur trim"`
+	Timeout    string         `json:"timeout"`
+	OutputName string         `json:"output_name"`
+}
+
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// tenantObjectPrefix is the prefix of the request's object keys in folder,
+// e.g. "uploads/<tenant>/", or "uploads/" without a tenant.
+func tenantObjectPrefix(c *gin.Context, folder string) string {
+	return path.Join(folder, requestTenant(c)) + "/"
+}
+
+// tenantObjectKey reports whether key was minted for the request's tenant
+// in folder. Tenant keys sit one level deeper than shared ones, so the
+// shared namespace does not reach into them.
+func tenantObjectKey(c *gin.Context, folder, key string) bool {
+	prefix := tenantObjectPrefix(c, folder)
+	return strings.HasPrefix(key, prefix) && !strings.Contains(key[len(prefix):], "/")
+}
+
+// signUpload mints a pre-signed URL the client uploads a source video to.
+func signUpload(c *gin.Context) {
+	var request SignUploadRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	name := unsafeKeyChars.ReplaceAllString(filepath.Base(request.Filename), "_")
+	key := fmt.Sprintf("%s%d_%s", tenantObjectPrefix(c, "uploads"), time.Now().UnixNano(), name)
+
+	uploadURL, err := storage.SignedUploadURL(key, config.SignedURLExpiry)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"object_key": key,
+		"upload_url": absoluteURL(c, uploadURL),
+		"method":     http.MethodPut,
+		"expires_in": int(config.SignedURLExpiry.Seconds()),
+	})
+}
+
+// fetchObject downloads an object uploaded by the request's tenant into its
+// upload folder.
+func fetchObject(c *gin.Context, key string) (string, error) {
+	if !validObjectKey(key) || !tenantObjectKey(c, "uploads", key) {
+		return "", fmt.Errorf("invalid object key")
+	}
+
+	localPath := filepath.Join(tenantUploadDir(c), fmt.Sprintf("object_%d%s", time.Now().UnixNano(), filepath.Ext(key)))
+	if err := storage.Fetch(c.Request.Context(), key, localPath); err != nil {
+		return "", err
+	}
+	if err := checkFileType(key, localPath); err != nil {
+		os.Remove(localPath)
+		return "", err
+	}
+	if err := checkReadableVideo(localPath); err != nil {
+		os.Remove(localPath)
+		return "", err
+	}
+	return localPath, nil
+}
+
+func respondFetchError(c *gin.Context, err error) {
+	var typeErr *unsupportedTypeError
+	if errors.As(err, &typeErr) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error(), "code": "UNSUPPORTED_MEDIA_TYPE"})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+func analyzeObject(c *gin.Context) {
+	var request ObjectAnalyzeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	sceneContext, err := parseSceneContext(request.SceneContext)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename, err := fetchObject(c, request.ObjectKey)
+	if err != nil {
+		respondFetchError(c, err)
+		return
+	}
+	defer os.Remove(filename)
+
+	ctx, cancel, err := processingContext(c, request.Timeout)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer cancel()
+
+	ratings, err := processVideo(ctx, filename, AnalysisOptions{Interpolation: config.ResizeInterpolation, SamplesPerSecond: config.SamplesPerSecond, SceneContext: sceneContext})
+	if err != nil {
+		respondProcessingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ratings": ratings})
+}
+
+func convertObject(c *gin.Context) {
+	var request ObjectConvertRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request format: %v", err)})
+		return
+	}
+
+	filename, err := fetchObject(c, request.ObjectKey)
+	if err != nil {
+		respondFetchError(c, err)
+		return
+	}
+	defer os.Remove(filename)
+
+	age, err := parseAge(request.Age)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ratings := ignoreBriefCensorSpans(request.Ratings, age, config.MinCensorDuration)
+	if err := checkOutputAudio(filename, request.VideoType == "trim"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel, err := processingContext(c, request.Timeout)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer cancel()
+
+	opts := defaultConvertOptions(c)
+	opts.OutputName = request.OutputName
+	result, err := processVideoByAge(ctx, filename, age, ratings, request.VideoType, opts)
+	if err != nil {
+		respondProcessingError(c, err)
+		return
+	}
+	// The output is served from storage, so nothing is kept locally.
+	defer result.remove()
+
+	outputKey := tenantObjectPrefix(c, "processed") + filepath.Base(result.OutputPath)
+	if err := storage.Store(c.Request.Context(), result.OutputPath, outputKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	downloadURL, err := storage.SignedDownloadURL(outputKey, config.SignedURLExpiry)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Video processed successfully",
+		"object_key":   outputKey,
+		"download_url": absoluteURL(c, downloadURL),
+		"expires_in":   int(config.SignedURLExpiry.Seconds()),
+	})
+}
+
+// putStorageObject receives a client upload to a locally signed URL.
+func putStorageObject(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if !validObjectKey(key) || !verifyLocalSignature(http.MethodPut, key, c.Query("expires"), c.Query("signature")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired signature"})
+		return
+	}
+
+	store := storage.(*localStorage)
+	path := store.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store object"})
+		return
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store object"})
+		return
+	}
+	defer out.Close()
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, config.MaxUploadSize)
+	if _, err := out.ReadFrom(body); err != nil {
+		os.Remove(path)
+		log.Printf("Failed to store object %s: %v", key, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to store object"})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// getStorageObject serves a locally stored object to a signed URL.
+func getStorageObject(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if !validObjectKey(key) || !verifyLocalSignature(http.MethodGet, key, c.Query("expires"), c.Query("signature")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired signature"})
+		return
+	}
+
+	path := storage.(*localStorage).path(key)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found", "code": "NOT_FOUND"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filepath.Base(key)))
+	c.File(path)
+}
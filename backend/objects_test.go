@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gocv.io/x/gocv"
+)
+
+func TestConvertObjectFailSafe(t *testing.T) {
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+	defer func(saved Config, savedStorage Storage) {
+		config, storage = saved, savedStorage
+	}(config, storage)
+	config.FailSafe = true
+	config.FailSafeColor = "000000"
+	config.AudioMux = false
+
+	// Outputs are written under the working directory.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	for _, folder := range []string{uploadFolder, processedFolder} {
+		if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	storage = &localStorage{dir: filepath.Join(dir, "storage")}
+	source := filepath.Join(dir, "storage", "uploads", "clip.mp4")
+	if err := os.MkdirAll(filepath.Dir(source), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	// Four seconds of mid-gray at 10fps.
+	generate := exec.Command("ffmpeg", "-y", "-v", "error",
+		"-f", "lavfi", "-i", "color=c=gray:duration=4:size=64x64:rate=10",
+		"-c:v", "mpeg4", "-pix_fmt", "yuv420p", "-an", source)
+	if out, err := generate.CombinedOutput(); err != nil {
+		t.Fatalf("failed to generate test video: %v: %s", err, out)
+	}
+
+	// Only the first second is rated, so the rest is unclassified.
+	confidence := 1.0
+	body, _ := json.Marshal(ObjectConvertRequest{
+		ObjectKey: "uploads/clip.mp4",
+		Age:       mildestRating(),
+		Ratings:   []RatingResult{{Start: 0, End: 1, Rating: mildestRating(), Confidence: &confidence}},
+		VideoType: "blur",
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/objects/convert", convertObject)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/objects/convert", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	outputs, err := filepath.Glob(filepath.Join(dir, "storage", "processed", "*.mp4"))
+	if err != nil || len(outputs) != 1 {
+		t.Fatalf("stored outputs = %v, %v; want one", outputs, err)
+	}
+	video, err := gocv.VideoCaptureFile(outputs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer video.Close()
+
+	img := gocv.NewMat()
+	defer img.Close()
+	for frame := 0; frame < 40; frame++ {
+		if !video.Read(&img) || img.Empty() {
+			t.Fatalf("output ends at frame %d", frame)
+		}
+		// The rated second is shown, the unclassified ones are blanked.
+		brightness := img.Mean().Val1
+		if covered := frame < 20; covered && brightness < 64 {
+			t.Fatalf("frame %d was blanked, brightness %.0f", frame, brightness)
+		} else if !covered && brightness > 16 {
+			t.Fatalf("frame %d was shown, brightness %.0f", frame, brightness)
+		}
+	}
+}
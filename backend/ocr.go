@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gocv.io/x/gocv"
+)
+
+// regionPadding grows detected boxes so the blur fully covers what they
+// hold, e.g. the edges of glyphs.
+const regionPadding = 0.1
+
+// detectBlockedText runs Tesseract over img and returns the blocklisted words
+// it found along with their bounding boxes, normalized to the frame size.
+func detectBlockedText(img gocv.Mat) ([]string, []BlurRegion, error) {
+	tempFile, err := os.CreateTemp("", "censorai_ocr_*.png")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create OCR frame: %v", err)
+	}
+	tempPath := tempFile.Name()
+	tempFile.Close()
+	defer os.Remove(tempPath)
+
+	if ok := gocv.IMWrite(tempPath, img); !ok {
+		return nil, nil, fmt.Errorf("failed to write OCR frame")
+	}
+
+	cmd := exec.Command("tesseract", tempPath, "stdout", "-l", config.OCRLanguage, "tsv")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to run tesseract: %v", err)
+	}
+
+	frameWidth := float64(img.Cols())
+	frameHeight := float64(img.Rows())
+
+	var words []string
+	var regions []BlurRegion
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		// level page block par line word left top width height conf text
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 12 {
+			continue
+		}
+
+		word := strings.ToLower(strings.TrimFunc(fields[11], func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		}))
+		if word == "" || !contains(config.OCRBlocklist, word) {
+			continue
+		}
+
+		left, errLeft := strconv.Atoi(fields[6])
+		top, errTop := strconv.Atoi(fields[7])
+		width, errWidth := strconv.Atoi(fields[8])
+		height, errHeight := strconv.Atoi(fields[9])
+		if errLeft != nil || errTop != nil || errWidth != nil || errHeight != nil {
+			continue
+		}
+
+		words = append(words, word)
+		regions = append(regions, BlurRegion{
+			X:      float64(left) / frameWidth,
+			Y:      float64(top) / frameHeight,
+			Width:  float64(width) / frameWidth,
+			Height: float64(height) / frameHeight,
+		}.pad(regionPadding))
+	}
+
+	return words, regions, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// outputWriter wraps the gocv writer used for censored output, counting the
+// frames written and feeding them to any optional by-products. progress,
+// if set, is advanced by the censor loops for every source frame read,
+// whether or not it is written.
+type outputWriter struct {
+	writer     *gocv.VideoWriter
+	fps        float64
+	frames     int
+	thumbnails *thumbnailSheet
+	progress   *jobProgress
+}
+
+// newOutputWriter opens a writer for path using the first codec in
+// config.VideoCodecs that the platform can actually encode. Some OpenCV
+// builds return a writer for an unavailable fourcc that never opens and
+// leaves an empty file behind, so each candidate is checked with IsOpened.
+func newOutputWriter(path string, fps float64, width, height int) (*outputWriter, error) {
+	for _, codec := range config.VideoCodecs {
+		writer, err := gocv.VideoWriterFile(path, codec, fps, width, height, true)
+		if err != nil {
+			log.Printf("Video codec %s unavailable: %v", codec, err)
+			continue
+		}
+		if !writer.IsOpened() {
+			writer.Close()
+			log.Printf("Video codec %s unavailable: writer did not open", codec)
+			continue
+		}
+		return &outputWriter{writer: writer, fps: fps}, nil
+	}
+
+	os.Remove(path)
+	return nil, fmt.Errorf("failed to create video writer: none of the codecs %s are supported by this OpenCV build",
+		strings.Join(config.VideoCodecs, ", "))
+}
+
+// Write appends img to the output.
+func (w *outputWriter) Write(img gocv.Mat) error {
+	if err := w.writer.Write(img); err != nil {
+		return err
+	}
+	if w.thumbnails != nil {
+		w.thumbnails.add(float64(w.frames)/w.fps, img)
+	}
+	w.frames++
+	return nil
+}
+
+func (w *outputWriter) Close() error {
+	return w.writer.Close()
+}
+
+// verifyOutput checks that the finished output at path is playable and
+// holds close to the expected number of frames, catching encodes that
+// silently produced an empty or truncated file. Outputs expected to be
+// empty, such as fully trimmed videos, are not checked.
+func verifyOutput(path string, expectedFrames int) error {
+	if expectedFrames == 0 {
+		return nil
+	}
+
+	if err := checkReadableVideo(path); err != nil {
+		return err
+	}
+
+	frames, err := countVideoPackets(path)
+	if err != nil {
+		return err
+	}
+	if float64(frames) < float64(expectedFrames)*config.OutputMinFrameRatio {
+		return fmt.Errorf("output has %d frames, expected %d", frames, expectedFrames)
+	}
+	return nil
+}
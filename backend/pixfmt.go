@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// videoEncoders maps the video codecs outputs can be re-encoded in to
+// their ffmpeg encoders.
+var videoEncoders = map[string]string{
+	"h264":  "libx264",
+	"hevc":  "libx265",
+	"mpeg4": "mpeg4",
+}
+
+// encoderPixelFormats caches the pixel formats each ffmpeg encoder
+// supports.
+var encoderPixelFormats sync.Map
+
+// videoCodecArgs picks the ffmpeg video arguments for finalizing the
+// video-only file at videoOnlyPath. The video is copied when it already
+// has OUTPUT_PIX_FMT (or the setting is "source"), and otherwise re-encoded
+// in the same codec with that pixel format. A pixel format the codec's
+// encoder does not support is an error.
+func videoCodecArgs(videoOnlyPath string) ([]string, error) {
+	copyArgs := []string{"-c:v", "copy"}
+	if config.OutputPixFmt == "source" {
+		return copyArgs, nil
+	}
+
+	codec, pixFmt, err := probeVideoCodec(videoOnlyPath)
+	if err != nil {
+		return nil, err
+	}
+	if pixFmt == config.OutputPixFmt {
+		return copyArgs, nil
+	}
+
+	encoder, ok := videoEncoders[codec]
+	if !ok {
+		return nil, fmt.Errorf("cannot convert %s video to pixel format %s", codec, config.OutputPixFmt)
+	}
+	formats, err := pixelFormats(encoder)
+	if err != nil {
+		return nil, err
+	}
+	if !contains(formats, config.OutputPixFmt) {
+		return nil, fmt.Errorf("pixel format %s is not supported by the %s encoder (supported: %s)",
+			config.OutputPixFmt, encoder, strings.Join(formats, ", "))
+	}
+	return []string{"-c:v", encoder, "-pix_fmt", config.OutputPixFmt}, nil
+}
+
+// pixelFormats lists the pixel formats encoder supports, as reported by
+// ffmpeg.
+func pixelFormats(encoder string) ([]string, error) {
+	if formats, ok := encoderPixelFormats.Load(encoder); ok {
+		return formats.([]string), nil
+	}
+
+	output, err := exec.Command("ffmpeg", "-hide_banner", "-h", "encoder="+encoder).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the %s encoder: %v", encoder, err)
+	}
+	var formats []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if list, ok := strings.CutPrefix(strings.TrimSpace(line), "Supported pixel formats:"); ok {
+			formats = strings.Fields(list)
+			break
+		}
+	}
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("ffmpeg does not provide the %s encoder", encoder)
+	}
+
+	encoderPixelFormats.Store(encoder, formats)
+	return formats, nil
+}
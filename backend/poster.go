@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Where extractPosterFrame found the frame it returns.
+const (
+	posterSourceAttached = "poster"
+	posterSourceKeyframe = "keyframe"
+)
+
+// extractPosterFrame writes the embedded poster frame of videoPath, or its
+// first keyframe if there is none, to framePath as a JPEG. Only that one
+// frame is decoded. It returns which of the two it used.
+func extractPosterFrame(videoPath, framePath string) (string, error) {
+	streams, err := probeStreams(videoPath)
+	if err != nil {
+		return "", err
+	}
+
+	source := posterSourceKeyframe
+	args := []string{"-y", "-v", "error", "-skip_frame", "nokey", "-i", videoPath, "-map", "0:v:0"}
+	for _, stream := range streams {
+		if stream.CodecType == "video" && stream.Disposition.AttachedPic != 0 {
+			source = posterSourceAttached
+			args = []string{"-y", "-v", "error", "-i", videoPath, "-map", "0:" + strconv.Itoa(stream.Index)}
+			break
+		}
+	}
+	args = append(args, "-frames:v", "1", framePath)
+
+	if output, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+		os.Remove(framePath)
+		return "", fmt.Errorf("failed to extract %s frame: %v: %s", source, err, strings.TrimSpace(string(output)))
+	}
+	return source, nil
+}
+
+// posterUpload handles POST /poster: a coarse rating of a video from its
+// poster frame or first keyframe alone, for bulk triage of a library
+// before committing to a full analysis.
+func posterUpload(c *gin.Context) {
+	file, err := c.FormFile("video")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No video file provided"})
+		return
+	}
+	if err := checkUploadType(file); err != nil {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error(), "code": "UNSUPPORTED_MEDIA_TYPE"})
+		return
+	}
+
+	sceneContext, err := parseSceneContext(c.PostForm("scene_context"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := uploadPath(c, file.Filename)
+	if err := c.SaveUploadedFile(file, filename); err != nil {
+		respondSaveError(c, filename, err)
+		return
+	}
+	defer os.Remove(filename)
+
+	framePath := strings.TrimSuffix(filename, filepath.Ext(filename)) + "_poster.jpg"
+	source, err := extractPosterFrame(filename, framePath)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "INVALID_VIDEO"})
+		return
+	}
+	defer os.Remove(framePath)
+
+	ctx, cancel, err := processingContext(c, c.PostForm("timeout"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer cancel()
+
+	ratings, err := processImage(ctx, framePath, AnalysisOptions{Interpolation: config.ResizeInterpolation, SceneContext: sceneContext})
+	if err != nil {
+		respondProcessingError(c, err)
+		return
+	}
+
+	rating := ratings[0]
+	c.JSON(http.StatusOK, gin.H{
+		"source":     source,
+		"rating":     rating.Rating,
+		"notes":      rating.Notes,
+		"confidence": rating.Confidence,
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"gocv.io/x/gocv"
+)
+
+// preFilter is the optional local classifier used to skip OpenAI analysis of
+// obviously clean videos. It is nil when PREFILTER_MODEL is unset.
+var preFilter *frameClassifier
+
+// localClassifier rates frames for the "local" analyzer and the local
+// fallback, without any API calls. It is loaded from LOCAL_MODEL, or is the
+// pre-filter when only PREFILTER_MODEL is set, and nil when neither is.
+var localClassifier *frameClassifier
+
+// prefilterVideo scores a sparse, evenly spaced sample of frames. It reports
+// clean only when every sampled frame could be read and scores below
+// PREFILTER_THRESHOLD, along with the video duration in seconds.
+func prefilterVideo(videoPath string) (bool, float64, error) {
+	video, err := gocv.VideoCaptureFile(videoPath)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to open video: %v", err)
+	}
+	defer video.Close()
+
+	fps := video.Get(gocv.VideoCaptureFPS)
+	if fps <= 0 {
+		fps = 30 // Default to 30fps if unable to determine
+	}
+	totalFrames := int(video.Get(gocv.VideoCaptureFrameCount))
+	if totalFrames <= 0 {
+		return false, 0, fmt.Errorf("unable to determine frame count")
+	}
+
+	samples := config.PrefilterSamples
+	if samples > totalFrames {
+		samples = totalFrames
+	}
+	if samples < 1 {
+		return false, 0, nil
+	}
+
+	img := gocv.NewMat()
+	defer img.Close()
+
+	for i := 0; i < samples; i++ {
+		frameIndex := i * totalFrames / samples
+		video.Set(gocv.VideoCapturePosFrames, float64(frameIndex))
+		if ok := video.Read(&img); !ok || img.Empty() {
+			// An unread frame could be anything, so it is not clean.
+			log.Printf("Pre-filter could not read frame %d, running full analysis", frameIndex)
+			return false, 0, nil
+		}
+
+		score, err := preFilter.score(img)
+		if err != nil {
+			return false, 0, err
+		}
+		if score >= config.PrefilterThreshold {
+			log.Printf("Pre-filter flagged frame %d (score %.3f), running full analysis", frameIndex, score)
+			return false, 0, nil
+		}
+	}
+
+	return true, float64(totalFrames) / fps, nil
+}
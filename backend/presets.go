@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Preset bundles a platform's censoring policy so clients can select it by
+// name instead of repeating every field on each /convert call.
+type Preset struct {
+	Age       string `json:"age"`
+	VideoType string `json:"video_type"`
+	// CategoryFloors maps a note keyword to the minimum rating of any
+	// segment whose notes mention it.
+	CategoryFloors map[string]string `json:"category_floors"`
+	// AlwaysBlur lists note keywords whose segments are always censored,
+	// whatever their rating (blurred, or cut from trim outputs).
+	AlwaysBlur []string `json:"always_blur"`
+}
+
+// presets holds the presets loaded from PRESETS_FILE, by name.
+var presets map[string]Preset
+
+// loadPresets reads a JSON object of named presets from path. An empty
+// path means no presets are configured.
+func loadPresets(path string) (map[string]Preset, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read presets: %v", err)
+	}
+
+	var loaded map[string]Preset
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return nil, fmt.Errorf("failed to parse presets: %v", err)
+	}
+
+	for name, preset := range loaded {
+		for keyword, floor := range preset.CategoryFloors {
+			if ratingLevel(floor) < 0 {
+				return nil, fmt.Errorf("preset %s: invalid rating %q for %s", name, floor, keyword)
+			}
+		}
+		normalized := make(map[string]string, len(preset.CategoryFloors))
+		for keyword, floor := range preset.CategoryFloors {
+			normalized[strings.ToLower(keyword)] = floor
+		}
+		preset.CategoryFloors = normalized
+		for i, keyword := range preset.AlwaysBlur {
+			preset.AlwaysBlur[i] = strings.ToLower(keyword)
+		}
+		loaded[name] = preset
+	}
+	return loaded, nil
+}
+
+// apply returns a copy of ratings with the preset's category floors and
+// always-blur keywords applied to each segment's notes.
+func (p Preset) apply(ratings []RatingResult) []RatingResult {
+	result := make([]RatingResult, len(ratings))
+	copy(result, ratings)
+
+	strictest := strictestRating()
+	for i := range result {
+		notes := strings.ToLower(result[i].Notes)
+		for keyword, floor := range p.CategoryFloors {
+			if strings.Contains(notes, keyword) && getRatingValue(floor) > getRatingValue(result[i].Rating) {
+				result[i].Rating = floor
+				result[i].Regions, result[i].RegionKeyframes = nil, nil
+			}
+		}
+		for _, keyword := range p.AlwaysBlur {
+			if strings.Contains(notes, keyword) {
+				result[i].Rating = strictest
+				result[i].Regions, result[i].RegionKeyframes = nil, nil
+			}
+		}
+	}
+	return result
+}
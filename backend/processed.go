@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// processedSweepInterval is how often expired output names are dropped
+// from the registry and its file.
+const processedSweepInterval = 10 * time.Minute
+
+// processedRegistry remembers which output files this server produced, for
+// a while after the files themselves are gone, so downloads can tell an
+// expired file apart from a name that never existed. With a path, every
+// name is also appended to that file, so it is remembered across restarts.
+type processedRegistry struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+	path    string
+}
+
+// processedEntry is one line of the registry file.
+type processedEntry struct {
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var processedFiles = &processedRegistry{entries: make(map[string]time.Time)}
+
+// open loads the names saved at path, if it exists, and records new ones
+// there from now on.
+func (r *processedRegistry) open(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.path = path
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry processedEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil && entry.Key != "" {
+			r.entries[entry.Key] = entry.CreatedAt
+		}
+	}
+	return scanner.Err()
+}
+
+// seed registers outputs already on disk, e.g. from before a restart,
+// including those in tenant subfolders.
+func (r *processedRegistry) seed(folder string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	filepath.WalkDir(folder, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		createdAt := time.Now()
+		if info, err := entry.Info(); err == nil {
+			createdAt = info.ModTime()
+		}
+		r.entries[processedKey(path)] = createdAt
+		return nil
+	})
+	r.prune()
+	r.save()
+}
+
+// processedKey identifies an output by its path relative to
+// processedFolder, which includes the tenant subfolder if any.
+func processedKey(path string) string {
+	key, err := filepath.Rel(processedFolder, path)
+	if err != nil {
+		return filepath.Base(path)
+	}
+	return filepath.ToSlash(key)
+}
+
+func (r *processedRegistry) record(filename string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry := processedEntry{Key: filename, CreatedAt: time.Now()}
+	r.entries[filename] = entry.CreatedAt
+	if len(r.entries) > config.ProcessedMaxEntries {
+		r.prune()
+	}
+	if r.path == "" {
+		return
+	}
+	line, _ := json.Marshal(entry)
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to save output name %s: %v", filename, err)
+	}
+}
+
+// forget drops filename, for an output removed before it was handed out.
+func (r *processedRegistry) forget(filename string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, filename)
+}
+
+// known reports whether filename was produced within the retention window.
+// Entries past the window are dropped.
+func (r *processedRegistry) known(filename string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	createdAt, ok := r.entries[filename]
+	if !ok {
+		return false
+	}
+	if time.Since(createdAt) > config.ProcessedRetention {
+		delete(r.entries, filename)
+		return false
+	}
+	return true
+}
+
+// sweep drops expired names every processedSweepInterval and rewrites the
+// registry file without them, so neither grows for the life of the server.
+func (r *processedRegistry) sweep() {
+	for range time.Tick(processedSweepInterval) {
+		r.mu.Lock()
+		r.prune()
+		r.save()
+		r.mu.Unlock()
+	}
+}
+
+// prune drops the entries past the retention window, then the oldest ones
+// beyond PROCESSED_METADATA_MAX_ENTRIES. r.mu must be held.
+func (r *processedRegistry) prune() {
+	for key, createdAt := range r.entries {
+		if time.Since(createdAt) > config.ProcessedRetention {
+			delete(r.entries, key)
+		}
+	}
+	excess := len(r.entries) - config.ProcessedMaxEntries
+	if excess <= 0 {
+		return
+	}
+	keys := make([]string, 0, len(r.entries))
+	for key := range r.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return r.entries[keys[i]].Before(r.entries[keys[j]])
+	})
+	for _, key := range keys[:excess] {
+		delete(r.entries, key)
+	}
+}
+
+// save rewrites the registry file with the current entries. r.mu must be
+// held.
+func (r *processedRegistry) save() {
+	if r.path == "" {
+		return
+	}
+	var data []byte
+	for key, createdAt := range r.entries {
+		line, _ := json.Marshal(processedEntry{Key: key, CreatedAt: createdAt})
+		data = append(append(data, line...), '\n')
+	}
+	// Written aside and renamed, so a crash never leaves half a file.
+	tempPath := r.path + ".tmp"
+	err := os.WriteFile(tempPath, data, 0644)
+	if err == nil {
+		err = os.Rename(tempPath, r.path)
+	}
+	if err != nil {
+		log.Printf("Failed to save output names: %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Modes of WHISPER_MODE.
+const (
+	whisperAPI   = "api"
+	whisperLocal = "local"
+)
+
+// Modes of AUDIO_CENSOR and /convert's audio_censor.
+const (
+	audioCensorBleep = "bleep"
+	audioCensorMute  = "mute"
+)
+
+// bleepFrequency is the pitch of the tone laid over bleeped words, in Hz.
+const bleepFrequency = 1000
+
+// ProfanitySegment is a stretch of audio where blocklisted words are
+// spoken, padded by TRANSCRIPT_PADDING.
+type ProfanitySegment struct {
+	Start float64  `json:"start"`
+	End   float64  `json:"end"`
+	Words []string `json:"words"`
+}
+
+// profanitySegments returns the spans of words on blocklist, widened by
+// padding seconds on each side. Overlapping spans are merged.
+func profanitySegments(words []TranscriptWord, blocklist []string, padding float64) []ProfanitySegment {
+	blocked := make(map[string]bool, len(blocklist))
+	for _, entry := range blocklist {
+		if entry = normalizeWord(entry); entry != "" {
+			blocked[entry] = true
+		}
+	}
+
+	var matches []TranscriptWord
+	for _, w := range words {
+		if word := normalizeWord(w.Word); blocked[word] {
+			matches = append(matches, TranscriptWord{Word: word, Start: max(w.Start-padding, 0), End: w.End + padding})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Start < matches[j].Start })
+
+	var segments []ProfanitySegment
+	for _, m := range matches {
+		if n := len(segments); n > 0 && m.Start <= segments[n-1].End {
+			last := &segments[n-1]
+			last.End = max(last.End, m.End)
+			last.Words = append(last.Words, m.Word)
+			continue
+		}
+		segments = append(segments, ProfanitySegment{Start: m.Start, End: m.End, Words: []string{m.Word}})
+	}
+	return segments
+}
+
+// parseProfanity decodes /convert's profanity field, a JSON array of
+// ProfanitySegment as returned by /upload.
+func parseProfanity(raw string) ([]ProfanitySegment, error) {
+	var segments []ProfanitySegment
+	if err := json.Unmarshal([]byte(raw), &segments); err != nil {
+		return nil, fmt.Errorf("invalid profanity: %v", err)
+	}
+	for i, s := range segments {
+		if s.Start < 0 || s.End < s.Start {
+			return nil, fmt.Errorf("invalid profanity: segment %d has an invalid time range", i)
+		}
+	}
+	return segments, nil
+}
+
+// detectProfanity transcribes the first audio stream of videoPath with
+// Whisper, per WHISPER_MODE, and returns where words on blocklist are
+// spoken. A file without audio has no profanity.
+func detectProfanity(ctx context.Context, videoPath string, blocklist []string) ([]ProfanitySegment, error) {
+	audio, err := sourceAudioStream(videoPath)
+	if err != nil {
+		return nil, err
+	}
+	if audio == nil {
+		return []ProfanitySegment{}, nil
+	}
+
+	speechPath, err := extractSpeech(videoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(speechPath)
+
+	var words []TranscriptWord
+	if config.WhisperMode == whisperLocal {
+		words, err = transcribeLocally(ctx, speechPath)
+	} else {
+		words, err = transcribeWithOpenAI(ctx, speechPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	segments := profanitySegments(words, blocklist, config.TranscriptPadding)
+	if segments == nil {
+		segments = []ProfanitySegment{}
+	}
+	return segments, nil
+}
+
+// extractSpeech writes the first audio stream of videoPath to a temporary
+// 16 kHz mono MP3, the rate Whisper works at. At that size an hour of
+// audio stays under the API's 25 MB upload limit.
+func extractSpeech(videoPath string) (string, error) {
+	f, err := os.CreateTemp("", "speech_*.mp3")
+	if err != nil {
+		return "", fmt.Errorf("failed to create speech file: %v", err)
+	}
+	f.Close()
+
+	output, err := exec.Command("ffmpeg",
+		"-y",
+		"-v", "error",
+		"-i", videoPath,
+		"-map", "0:a:0",
+		"-ac", "1",
+		"-ar", "16000",
+		"-b:a", "32k",
+		f.Name(),
+	).CombinedOutput()
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to extract audio: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return f.Name(), nil
+}
+
+// transcribeWithOpenAI transcribes the audio at path with the OpenAI
+// transcription API, asking for word-level timestamps.
+func transcribeWithOpenAI(ctx context.Context, path string) ([]TranscriptWord, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("model", config.WhisperModel)
+	form.WriteField("response_format", "verbose_json")
+	form.WriteField("timestamp_granularities[]", "word")
+	part, err := form.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read speech file: %v", err)
+	}
+	_, err = io.Copy(part, f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read speech file: %v", err)
+	}
+	form.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.openai.com/v1/audio/transcriptions", &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("OPENAI_API_KEY"))
+	setOpenAIBillingHeaders(ctx, req.Header)
+
+	resp, err := openAIClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Whisper: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("Whisper returned %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	var result struct {
+		Words []TranscriptWord `json:"words"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode Whisper response: %v", err)
+	}
+	return result.Words, nil
+}
+
+// transcribeLocally runs WHISPER_COMMAND with the audio path appended. The
+// command prints the words as a JSON array, in the format of /convert's
+// transcript field.
+func transcribeLocally(ctx context.Context, path string) ([]TranscriptWord, error) {
+	fields := strings.Fields(config.WhisperCommand)
+	output, err := exec.CommandContext(ctx, fields[0], append(fields[1:], path)...).Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, checkContext(ctx)
+		}
+		return nil, fmt.Errorf("WHISPER_COMMAND failed: %v", err)
+	}
+	return parseTranscript(string(output))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// progressInterval is how often streamed conversions report progress.
+const progressInterval = time.Second
+
+// jobProgress counts source frames processed by a job's analysis or
+// conversion. It is shared by every chunk of a chunked encode. A nil
+// *jobProgress ignores updates.
+type jobProgress struct {
+	frames atomic.Int64
+	total  atomic.Int64
+	// fps holds the math.Float64bits of the source frame rate.
+	fps atomic.Uint64
+	// analyzed counts frames sent to the analyzers, and segments the
+	// rating segments closed so far.
+	analyzed atomic.Int64
+	segments atomic.Int64
+}
+
+// setTotal starts a pass over total source frames at fps.
+func (p *jobProgress) setTotal(total int, fps float64) {
+	if p != nil {
+		p.frames.Store(0)
+		p.total.Store(int64(total))
+		p.fps.Store(math.Float64bits(fps))
+	}
+}
+
+func (p *jobProgress) advance() {
+	if p != nil {
+		p.frames.Add(1)
+	}
+}
+
+// at records that every frame before frame has been processed.
+func (p *jobProgress) at(frame int) {
+	if p != nil {
+		p.frames.Store(int64(frame))
+	}
+}
+
+func (p *jobProgress) sampled() {
+	if p != nil {
+		p.analyzed.Add(1)
+	}
+}
+
+func (p *jobProgress) segmentDone() {
+	if p != nil {
+		p.segments.Add(1)
+	}
+}
+
+// restartSegments forgets the segments found so far, for an analysis pass
+// that builds them again from the start.
+func (p *jobProgress) restartSegments() {
+	if p != nil {
+		p.segments.Store(0)
+	}
+}
+
+// percent returns the share of frames processed so far, 0-100.
+func (p *jobProgress) percent() float64 {
+	total := p.total.Load()
+	if total <= 0 {
+		return 0
+	}
+	return min(100, float64(p.frames.Load())*100/float64(total))
+}
+
+// report returns the progress fields of a job update.
+func (p *jobProgress) report() gin.H {
+	report := gin.H{
+		"percent":         p.percent(),
+		"frames":          p.frames.Load(),
+		"total_frames":    p.total.Load(),
+		"frames_analyzed": p.analyzed.Load(),
+		"segments":        p.segments.Load(),
+	}
+	if fps := math.Float64frombits(p.fps.Load()); fps > 0 {
+		report["timestamp"] = float64(p.frames.Load()) / fps
+	}
+	return report
+}
+
+// wantsProgressStream reports whether the client asked /convert to stream
+// newline-delimited JSON progress instead of a single response.
+func wantsProgressStream(c *gin.Context) bool {
+	return c.PostForm("stream") == "true" || c.GetHeader("Accept") == "application/x-ndjson"
+}
+
+// streamConvert runs processVideoByAge while writing a progress event to
+// the response every progressInterval, then the final result, each as one
+// JSON line over a single chunked response. Errors after the stream starts
+// are reported as an "error" event since the status is already sent.
+func streamConvert(ctx context.Context, c *gin.Context, filename string, age int, ratings []RatingResult, videoType string, opts ConvertOptions) {
+	defer os.Remove(filename)
+
+	progress := contextProgress(ctx)
+	opts.Progress = progress
+
+	// The stream lasts as long as the conversion, so WRITE_TIMEOUT does
+	// not apply to it.
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	send := func(event gin.H) {
+		encoder.Encode(event)
+		c.Writer.Flush()
+	}
+
+	type outcome struct {
+		result *ConvertResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := processVideoByAge(ctx, filename, age, ratings, videoType, opts)
+		done <- outcome{result, err}
+	}()
+
+	send(gin.H{"event": "progress", "percent": 0.0, "job_id": c.Writer.Header().Get(jobIDHeader)})
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			send(gin.H{"event": "progress", "percent": progress.percent()})
+		case out := <-done:
+			if errors.Is(out.err, errJobCancelled) {
+				send(gin.H{"event": "error", "error": "Job was cancelled", "code": "CANCELLED"})
+				return
+			}
+			if errors.Is(out.err, context.DeadlineExceeded) {
+				send(gin.H{"event": "error", "error": "Processing exceeded its deadline (job timeout)", "code": "TIMEOUT"})
+				return
+			}
+			if out.err != nil && isDiskFull(out.err) {
+				send(gin.H{"event": "error", "error": "Not enough disk space to write the output", "code": "INSUFFICIENT_STORAGE"})
+				return
+			}
+			if out.err != nil {
+				send(gin.H{"event": "error", "error": out.err.Error()})
+				return
+			}
+			response := convertResponse(c, out.result)
+			response["event"] = "result"
+			send(response)
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trustedProxies are the networks of TRUSTED_PROXIES. Forwarded headers
+// (X-Forwarded-For, X-Forwarded-Proto, X-Forwarded-Host) are only honored
+// on requests arriving directly from one of them.
+var trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses a list of CIDRs and single IP addresses.
+func parseTrustedProxies(entries []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy network %q: %v", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// fromTrustedProxy reports whether c was sent by one of trustedProxies.
+func fromTrustedProxy(c *gin.Context) bool {
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHeader returns the first value of a forwarded header, as set by
+// the proxy closest to the client, or "" if c is not from a trusted proxy.
+func forwardedHeader(c *gin.Context, name string) string {
+	if !fromTrustedProxy(c) {
+		return ""
+	}
+	value, _, _ := strings.Cut(c.GetHeader(name), ",")
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,39 @@
+package main
+
+import "math"
+
+// rampKernel returns the Gaussian kernel size for a frame at timestamp
+// within BLUR_RAMP seconds before or after a segment blurred in full, so
+// the blur fades in and out instead of popping on. The kernel grows
+// linearly from sharp to blurKernel as the segment nears. The ramp lies
+// outside the segment, so flagged frames always get the full blur. It
+// returns 0 when the frame is not blurred by a ramp.
+func rampKernel(ratings []RatingResult, age int, timestamp float64) int {
+	if config.BlurRamp <= 0 {
+		return 0
+	}
+
+	nearest := math.Inf(1)
+	for _, rating := range ratings {
+		if len(rating.Regions) > 0 || getRatingValue(rating.Rating) <= age {
+			continue
+		}
+		switch {
+		case timestamp < rating.Start:
+			nearest = math.Min(nearest, rating.Start-timestamp)
+		case timestamp > rating.End:
+			nearest = math.Min(nearest, timestamp-rating.End)
+		default:
+			return 0
+		}
+	}
+	if nearest >= config.BlurRamp {
+		return 0
+	}
+
+	kernel := int(float64(blurKernel)*(1-nearest/config.BlurRamp)) | 1
+	if kernel < 3 {
+		return 0
+	}
+	return kernel
+}
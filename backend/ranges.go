@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"gocv.io/x/gocv"
+)
+
+// TimeRange is a span of a video, in seconds.
+type TimeRange struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// parseRanges parses the "ranges" form field, a JSON array of time ranges
+// such as [{"start": 12, "end": 20.5}]. It returns nil when raw is empty.
+func parseRanges(raw string) ([]TimeRange, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var ranges []TimeRange
+	if err := json.Unmarshal([]byte(raw), &ranges); err != nil {
+		return nil, fmt.Errorf("invalid ranges: %v", err)
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("invalid ranges: no ranges given")
+	}
+	for _, r := range ranges {
+		if r.Start < 0 || r.End <= r.Start {
+			return nil, fmt.Errorf("invalid ranges: %g-%g must start at or after 0 and end after it starts", r.Start, r.End)
+		}
+	}
+	return ranges, nil
+}
+
+// analysisFrameRate returns the frame rate analyzeVideoFrom uses for
+// videoPath, and its frame count.
+func analysisFrameRate(videoPath string) (float64, int, error) {
+	video, err := gocv.VideoCaptureFile(videoPath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open video: %v", err)
+	}
+	defer video.Close()
+
+	fps := video.Get(gocv.VideoCaptureFPS)
+	if fps <= 0 {
+		fps = 30 // Matches analyzeVideoFrom's default.
+	}
+	return fps, int(video.Get(gocv.VideoCaptureFrameCount)), nil
+}
+
+// analyzeRanges analyzes only the frames of videoPath within opts.Ranges,
+// widened to whole seconds, and returns their segments. The rest of the
+// video is left unrated. Overlapping ranges are analyzed once.
+func analyzeRanges(ctx context.Context, videoPath string, opts AnalysisOptions) ([]RatingResult, error) {
+	fps, totalFrames, err := analysisFrameRate(videoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Analysis steps start on the first frame of a second, so each range
+	// becomes the whole seconds it touches.
+	second := int(fps)
+	type frameSpan struct{ start, stop int }
+	var spans []frameSpan
+	for _, r := range opts.Ranges {
+		start := int(r.Start*fps) / second * second
+		stop := (int(math.Ceil(r.End*fps)) + second - 1) / second * second
+		if totalFrames > 0 && start >= totalFrames {
+			continue
+		}
+		spans = append(spans, frameSpan{start, stop})
+	}
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var merged []frameSpan
+	for _, span := range spans {
+		if n := len(merged); n > 0 && span.start <= merged[n-1].stop {
+			merged[n-1].stop = max(merged[n-1].stop, span.stop)
+			continue
+		}
+		merged = append(merged, span)
+	}
+
+	results := []RatingResult{}
+	for _, span := range merged {
+		spanOpts := opts
+		spanOpts.stopFrame = span.stop
+		cursor, err := analyzeVideoFrom(ctx, videoPath, spanOpts, AnalysisCursor{NextFrame: span.start}, true)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, cursor.Ratings...)
+	}
+	return results, nil
+}
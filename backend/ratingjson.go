@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// parseRatingContent extracts the rating from a model reply. Replies may
+// wrap the answer in prose or code fences, or include other JSON objects
+// such as a reasoning block before it, so every top-level JSON object in
+// content is decoded and the last one with a valid "rating" is used.
+func parseRatingContent(content string) (RatingData, error) {
+	var found *RatingData
+	objects := 0
+
+	for i := 0; i < len(content); {
+		start := strings.IndexByte(content[i:], '{')
+		if start == -1 {
+			break
+		}
+		start += i
+
+		decoder := json.NewDecoder(strings.NewReader(content[start:]))
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			i = start + 1
+			continue
+		}
+		objects++
+		i = start + int(decoder.InputOffset())
+
+		var data RatingData
+		if err := json.Unmarshal(raw, &data); err == nil && ratingLevel(data.Rating) >= 0 {
+			found = &data
+		}
+	}
+
+	if found == nil {
+		if objects == 0 {
+			return RatingData{}, fmt.Errorf("no JSON object found in response")
+		}
+		return RatingData{}, fmt.Errorf("no JSON object with a valid rating found in response")
+	}
+	return *found, nil
+}
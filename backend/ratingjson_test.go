@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestParseRatingContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		rating  string
+		notes   string
+		wantErr bool
+	}{
+		{
+			name:    "bare object",
+			content: `{"rating": "12+", "notes": "fight"}`,
+			rating:  "12+",
+			notes:   "fight",
+		},
+		{
+			name:    "code fence and prose",
+			content: "Here is my rating:\n```json\n{\"rating\": \"16+\", \"notes\": \"blood\"}\n```\nLet me know if you need more.",
+			rating:  "16+",
+			notes:   "blood",
+		},
+		{
+			name:    "preamble object before rating",
+			content: `{"reasoning": "a knife is visible but no injury"} {"rating": "12+", "notes": "knife"}`,
+			rating:  "12+",
+			notes:   "knife",
+		},
+		{
+			name:    "preamble object with nested braces",
+			content: `{"analysis": {"objects": ["knife"], "text": "a } in a string"}}` + "\n" + `{"rating": "6+", "notes": "kitchen"}`,
+			rating:  "6+",
+			notes:   "kitchen",
+		},
+		{
+			name:    "invalid rating after valid one",
+			content: `{"rating": "16+", "notes": "gore"} {"rating": "unsure", "notes": ""}`,
+			rating:  "16+",
+			notes:   "gore",
+		},
+		{
+			name:    "last valid rating wins",
+			content: `{"rating": "6+", "notes": "draft"} {"rating": "12+", "notes": "final"}`,
+			rating:  "12+",
+			notes:   "final",
+		},
+		{
+			name:    "malformed object before rating",
+			content: `{"rating": 16+} {"rating": "18+", "notes": "nudity"}`,
+			rating:  "18+",
+			notes:   "nudity",
+		},
+		{
+			name:    "no object",
+			content: "I cannot rate this image.",
+			wantErr: true,
+		},
+		{
+			name:    "objects without a valid rating",
+			content: `{"reasoning": "unclear"} {"rating": "PG-13"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := parseRatingContent(tt.content)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data.Rating != tt.rating || data.Notes != tt.notes {
+				t.Errorf("got rating %q notes %q, want %q %q", data.Rating, data.Notes, tt.rating, tt.notes)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RatingLevel is one rating of the scale the analysis uses. A segment is
+// censored for a target age when its Value exceeds the age.
+type RatingLevel struct {
+	Name  string
+	Value int
+}
+
+// defaultRatingScale is the built-in 6+/12+/16+/18+ scheme.
+var defaultRatingScale = []RatingLevel{
+	{Name: "6+", Value: 6},
+	{Name: "12+", Value: 12},
+	{Name: "16+", Value: 16},
+	{Name: "18+", Value: 18},
+}
+
+// defaultRatingGuidelines describes defaultRatingScale to the model.
+const defaultRatingGuidelines = `- **6+**: Minimal, non-detailed violence. No nudity.
+- **12+**: Moderate violence without injury detail. Brief, non-sexual nudity.
+- **16+**: Intense but non-gratuitous violence. Partial nudity and implied sexual content allowed.
+- **18+**: Explicit violence with gore. Nudity, including sexual content, allowed.`
+
+// envRatingScale parses a "name=value,name=value" rating scale, sorted
+// from least to most restrictive, or returns def if the variable is unset.
+// Every rating needs its own name and value: ratings sharing a value
+// could not be told apart as target ages.
+func envRatingScale(key string, def []RatingLevel) ([]RatingLevel, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def, nil
+	}
+
+	var scale []RatingLevel
+	names := make(map[string]bool)
+	values := make(map[int]string)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid entry %q, want name=value", pair)
+		}
+		name := strings.TrimSpace(parts[0])
+		value, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if name == "" || err != nil || value < 0 {
+			return nil, fmt.Errorf("invalid entry %q, want name=value with a value of 0 or more", pair)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("rating %s is listed twice", name)
+		}
+		if other, ok := values[value]; ok {
+			return nil, fmt.Errorf("ratings %s and %s share the value %d", other, name, value)
+		}
+		names[name] = true
+		values[value] = name
+		scale = append(scale, RatingLevel{Name: name, Value: value})
+	}
+
+	if len(scale) < 2 {
+		return nil, fmt.Errorf("need at least two ratings")
+	}
+	sort.Slice(scale, func(i, j int) bool { return scale[i].Value < scale[j].Value })
+	return scale, nil
+}
+
+// ratingGuidelines returns the per-rating guidance given to the model:
+// RATING_GUIDELINES_FILE if set, the built-in text for the default scale,
+// and otherwise just the ratings in order.
+func ratingGuidelines() string {
+	if config.RatingGuidelinesFile != "" {
+		data, err := os.ReadFile(config.RatingGuidelinesFile)
+		if err == nil {
+			return strings.TrimSpace(string(data))
+		}
+		log.Printf("Failed to read rating guidelines, using the rating list: %v", err)
+	} else if isDefaultRatingScale(config.RatingScale) {
+		return defaultRatingGuidelines
+	}
+
+	var b strings.Builder
+	b.WriteString("Ratings, from least to most restrictive:")
+	for _, level := range config.RatingScale {
+		fmt.Fprintf(&b, "\n- **%s**", level.Name)
+	}
+	return b.String()
+}
+
+func isDefaultRatingScale(scale []RatingLevel) bool {
+	if len(scale) != len(defaultRatingScale) {
+		return false
+	}
+	for i, level := range scale {
+		if level != defaultRatingScale[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ratingNames lists the ratings from least to most restrictive.
+func ratingNames() []string {
+	names := make([]string, len(config.RatingScale))
+	for i, level := range config.RatingScale {
+		names[i] = level.Name
+	}
+	return names
+}
+
+// ratingLevel returns the index of rating in the scale, or -1.
+func ratingLevel(rating string) int {
+	for i, level := range config.RatingScale {
+		if level.Name == rating {
+			return i
+		}
+	}
+	return -1
+}
+
+// strictestRating returns the most restrictive rating of the scale.
+func strictestRating() string {
+	return config.RatingScale[len(config.RatingScale)-1].Name
+}
+
+// mildestRating returns the least restrictive rating of the scale.
+func mildestRating() string {
+	return config.RatingScale[0].Name
+}
+
+// ratingAtMost returns the most restrictive rating whose value is at most
+// value, or the mildest rating if none is. It maps the built-in ages onto
+// the configured scale.
+func ratingAtMost(value int) string {
+	name := mildestRating()
+	for _, level := range config.RatingScale {
+		if level.Value <= value {
+			name = level.Name
+		}
+	}
+	return name
+}
+
+// ageLevel returns the index of the rating whose value is age, or -1.
+func ageLevel(age int) int {
+	for i, level := range config.RatingScale {
+		if level.Value == age {
+			return i
+		}
+	}
+	return -1
+}
+
+// allowedAges lists the target ages requests may censor for: the value of
+// every rating but the strictest, which would censor nothing.
+func allowedAges() []string {
+	var ages []string
+	for _, level := range config.RatingScale[:len(config.RatingScale)-1] {
+		ages = append(ages, strconv.Itoa(level.Value))
+	}
+	return ages
+}
+
+// parseAge reads a target age given as a number or as the name of the
+// rating it corresponds to, e.g. "12" or "12+".
+func parseAge(raw string) (int, error) {
+	raw = strings.TrimSpace(raw)
+	level := ratingLevel(raw)
+	if level < 0 {
+		if age, err := strconv.Atoi(raw); err == nil {
+			level = ageLevel(age)
+		}
+	}
+	if level < 0 || level == len(config.RatingScale)-1 {
+		return 0, fmt.Errorf("Age must be one of: %s", strings.Join(allowedAges(), ", "))
+	}
+	return config.RatingScale[level].Value, nil
+}
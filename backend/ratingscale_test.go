@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnvRatingScale(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    []RatingLevel
+		wantErr bool
+	}{
+		{name: "unset", raw: "", want: defaultRatingScale},
+		{
+			name: "sorted by value",
+			raw:  "R=17, G=0,PG=8",
+			want: []RatingLevel{{Name: "G", Value: 0}, {Name: "PG", Value: 8}, {Name: "R", Value: 17}},
+		},
+		{name: "duplicate value", raw: "G=0,PG=0,R=17", wantErr: true},
+		{name: "duplicate name", raw: "G=0,G=8", wantErr: true},
+		{name: "negative value", raw: "G=-1,R=17", wantErr: true},
+		{name: "missing value", raw: "G,R=17", wantErr: true},
+		{name: "single rating", raw: "R=17", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_RATING_SCALE", tt.raw)
+			scale, err := envRatingScale("TEST_RATING_SCALE", defaultRatingScale)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", scale)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(scale, tt.want) {
+				t.Errorf("got %+v, want %+v", scale, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	mime.AddExtensionType(".csv", "text/csv; charset=utf-8")
+}
+
+// writeRedactionLog writes a CSV record of every segment censored in the
+// output at outputPath, one row per segment, for compliance review. It
+// returns the path of the CSV, written next to the output.
+func writeRedactionLog(outputPath string, ratings []RatingResult, age int, videoType string) (string, error) {
+	logPath := strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + "_redactions.csv"
+
+	f, err := os.Create(logPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create redaction log: %v", err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"start", "end", "duration", "rating", "target_age", "action", "notes", "confidence"})
+	for _, segment := range censoredSegments(ratings, age) {
+		confidence := ""
+		if segment.Confidence != nil {
+			confidence = strconv.FormatFloat(*segment.Confidence, 'f', 2, 64)
+		}
+		w.Write([]string{
+			strconv.FormatFloat(segment.Start, 'f', 2, 64),
+			strconv.FormatFloat(segment.End, 'f', 2, 64),
+			strconv.FormatFloat(segment.End-segment.Start, 'f', 2, 64),
+			segment.Rating,
+			strconv.Itoa(age),
+			videoType,
+			segment.Notes,
+			confidence,
+		})
+	}
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		os.Remove(logPath)
+		return "", fmt.Errorf("failed to write redaction log: %v", err)
+	}
+
+	processedFiles.record(processedKey(logPath))
+	return logPath, nil
+}
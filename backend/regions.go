@@ -0,0 +1,198 @@
+package main
+
+import (
+	"image"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// blurKernel is the Gaussian kernel size of a full-strength blur.
+const blurKernel = 45
+
+// BlurRegion is a rectangle to blur, in coordinates normalized to the frame
+// size so it applies regardless of the resolution it was detected at.
+type BlurRegion struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// pad grows the region by fraction of its size on every side, clamped to
+// the frame.
+func (r BlurRegion) pad(fraction float64) BlurRegion {
+	dx := r.Width * fraction
+	dy := r.Height * fraction
+	x0 := math.Max(0, r.X-dx)
+	y0 := math.Max(0, r.Y-dy)
+	x1 := math.Min(1, r.X+r.Width+dx)
+	y1 := math.Min(1, r.Y+r.Height+dy)
+	return BlurRegion{X: x0, Y: y0, Width: x1 - x0, Height: y1 - y0}
+}
+
+// rect converts the region to pixel coordinates for a width x height frame.
+func (r BlurRegion) rect(width, height int) image.Rectangle {
+	rect := image.Rect(
+		int(r.X*float64(width)),
+		int(r.Y*float64(height)),
+		int(math.Ceil((r.X+r.Width)*float64(width))),
+		int(math.Ceil((r.Y+r.Height)*float64(height))),
+	)
+	return rect.Intersect(image.Rect(0, 0, width, height))
+}
+
+// blurRegions blurs each region of img in place. With BLUR_FEATHER set,
+// the blur fades into the surrounding frame instead of ending at a hard
+// rectangle edge.
+func blurRegions(img *gocv.Mat, regions []BlurRegion) {
+	for _, region := range regions {
+		rect := region.rect(img.Cols(), img.Rows())
+		if rect.Empty() {
+			continue
+		}
+		if config.BlurFeather > 0 {
+			featherRegion(img, rect, config.BlurFeather)
+			continue
+		}
+		roi := img.Region(rect)
+		gocv.GaussianBlur(roi, &roi, image.Point{X: blurKernel, Y: blurKernel}, 0, 0, gocv.BorderDefault)
+		roi.Close()
+	}
+}
+
+// featherRegion blurs rect of img and blends the blur into the feather
+// pixels around it through a Gaussian-falloff alpha mask. The mask stays
+// fully opaque over rect itself, so the region is as blurred as without
+// feathering.
+func featherRegion(img *gocv.Mat, rect image.Rectangle, feather int) {
+	outer := rect.Inset(-feather).Intersect(image.Rect(0, 0, img.Cols(), img.Rows()))
+	roi := img.Region(outer)
+	defer roi.Close()
+
+	source := gocv.NewMat()
+	defer source.Close()
+	roi.ConvertTo(&source, gocv.MatTypeCV32FC3)
+	blurred := gocv.NewMat()
+	defer blurred.Close()
+	gocv.GaussianBlur(source, &blurred, image.Point{X: blurKernel, Y: blurKernel}, 0, 0, gocv.BorderDefault)
+
+	// The mask is 1 up to half the feather width past rect, then blurred so
+	// it falls to 0 by the full width. Replicated borders keep it opaque
+	// where rect touches the frame edge.
+	mask := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), outer.Dy(), outer.Dx(), gocv.MatTypeCV32FC3)
+	defer mask.Close()
+	solid := mask.Region(rect.Inset(-feather / 2).Intersect(outer).Sub(outer.Min))
+	solid.SetTo(gocv.NewScalar(1, 1, 1, 0))
+	solid.Close()
+	kernel := feather | 1
+	gocv.GaussianBlur(mask, &mask, image.Point{X: kernel, Y: kernel}, float64(feather)/4, 0, gocv.BorderReplicate)
+	inverse := gocv.NewMat()
+	defer inverse.Close()
+	mask.ConvertToWithParams(&inverse, gocv.MatTypeCV32FC3, -1, 1)
+
+	gocv.Multiply(blurred, mask, &blurred)
+	gocv.Multiply(source, inverse, &source)
+	gocv.AddWeighted(blurred, 1, source, 1, 0, &blurred)
+	blurred.ConvertTo(&source, gocv.MatTypeCV8UC3)
+	source.CopyTo(&roi)
+}
+
+// RegionKeyframe holds the regions located in the frame analyzed at
+// Timestamp.
+type RegionKeyframe struct {
+	Timestamp float64      `json:"timestamp"`
+	Regions   []BlurRegion `json:"regions"`
+}
+
+// modelRegions validates the boxes the model returned, padded like text
+// boxes. It returns nil, blurring the whole frame, if none are usable.
+func modelRegions(regions []BlurRegion) []BlurRegion {
+	var valid []BlurRegion
+	for _, region := range regions {
+		if region.Width <= 0 || region.Height <= 0 || region.X < 0 || region.Y < 0 || region.X >= 1 || region.Y >= 1 {
+			continue
+		}
+		valid = append(valid, region.pad(regionPadding))
+	}
+	return valid
+}
+
+// regionsAt returns the regions to blur in the frame at timestamp. Between
+// two analyzed frames they move from one keyframe's regions to the next;
+// before the first and after the last they hold still.
+func (r RatingResult) regionsAt(timestamp float64) []BlurRegion {
+	if len(r.RegionKeyframes) == 0 {
+		return r.Regions
+	}
+	var before, after *RegionKeyframe
+	for i := range r.RegionKeyframes {
+		k := &r.RegionKeyframes[i]
+		if k.Timestamp <= timestamp && (before == nil || k.Timestamp > before.Timestamp) {
+			before = k
+		}
+		if k.Timestamp >= timestamp && (after == nil || k.Timestamp < after.Timestamp) {
+			after = k
+		}
+	}
+	switch {
+	case before == nil:
+		return after.Regions
+	case after == nil || after.Timestamp == before.Timestamp:
+		return before.Regions
+	}
+	f := (timestamp - before.Timestamp) / (after.Timestamp - before.Timestamp)
+	return interpolateRegions(before.Regions, after.Regions, f)
+}
+
+// interpolateRegions moves each region of from towards the nearest region
+// of to by fraction f. Regions of to that nothing moves towards are
+// blurred in place from the start, so nothing goes unblurred between
+// keyframes.
+func interpolateRegions(from, to []BlurRegion, f float64) []BlurRegion {
+	regions := make([]BlurRegion, 0, max(len(from), len(to)))
+	paired := make([]bool, len(to))
+	for _, region := range from {
+		i := nearestRegion(region, to)
+		if i < 0 {
+			regions = append(regions, region)
+			continue
+		}
+		paired[i] = true
+		regions = append(regions, region.lerp(to[i], f))
+	}
+	for i, region := range to {
+		if !paired[i] {
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+// nearestRegion returns the index of the region in regions whose center is
+// closest to that of region, or -1 if regions is empty.
+func nearestRegion(region BlurRegion, regions []BlurRegion) int {
+	nearest, best := -1, math.Inf(1)
+	x, y := region.center()
+	for i, other := range regions {
+		ox, oy := other.center()
+		if d := math.Hypot(ox-x, oy-y); d < best {
+			nearest, best = i, d
+		}
+	}
+	return nearest
+}
+
+func (r BlurRegion) center() (float64, float64) {
+	return r.X + r.Width/2, r.Y + r.Height/2
+}
+
+// lerp returns the region fraction f of the way from r to to.
+func (r BlurRegion) lerp(to BlurRegion, f float64) BlurRegion {
+	return BlurRegion{
+		X:      r.X + (to.X-r.X)*f,
+		Y:      r.Y + (to.Y-r.Y)*f,
+		Width:  r.Width + (to.Width-r.Width)*f,
+		Height: r.Height + (to.Height-r.Height)*f,
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRetryBackoff caps the wait before retrying an analysis call.
+const maxRetryBackoff = 30 * time.Second
+
+// errProviderDown is returned without calling an analysis provider whose
+// circuit breaker is open.
+var errProviderDown = errors.New("analysis provider unavailable")
+
+// providerStatusError is a response from an analysis provider with a
+// status other than 200.
+type providerStatusError struct {
+	provider   string
+	status     int
+	retryAfter time.Duration
+	message    string
+}
+
+func newProviderStatusError(provider string, resp *http.Response, body []byte) *providerStatusError {
+	err := &providerStatusError{provider: provider, status: resp.StatusCode, message: strings.TrimSpace(string(body))}
+	if seconds, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); parseErr == nil && seconds > 0 {
+		err.retryAfter = time.Duration(seconds) * time.Second
+	}
+	return err
+}
+
+func (e *providerStatusError) Error() string {
+	return fmt.Sprintf("%s returned %d: %s", e.provider, e.status, e.message)
+}
+
+// transient reports whether a failed analysis call may succeed if repeated:
+// it was rate limited, hit a server error or lost its connection. Calls
+// past OPENAI_CALL_TIMEOUT are not repeated, so one frame can't stall a
+// job for several timeouts.
+func transient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, errFrameTimeout) {
+		return false
+	}
+	var status *providerStatusError
+	if errors.As(err, &status) {
+		return status.status == http.StatusTooManyRequests || status.status >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// retryDelay is how long to wait before retry number attempt, from 1: the
+// provider's Retry-After if it sent one, otherwise OPENAI_RETRY_BACKOFF
+// doubled for each earlier retry. The wait is jittered so parallel workers
+// don't retry in lockstep.
+func retryDelay(err error, attempt int) time.Duration {
+	var status *providerStatusError
+	if errors.As(err, &status) && status.retryAfter > 0 {
+		return min(status.retryAfter, maxRetryBackoff)
+	}
+	if config.OpenAIRetryBackoff <= 0 {
+		return 0
+	}
+	delay := min(config.OpenAIRetryBackoff<<min(attempt-1, 16), maxRetryBackoff)
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// withRetries runs call, an analysis call to provider, repeating it up to
+// OPENAI_RETRIES times while it fails transiently. The provider's circuit
+// breaker is checked first and told the outcome.
+func withRetries(ctx context.Context, provider string, call func() (RatingData, error)) (RatingData, error) {
+	breaker := providerBreaker(provider)
+	if err := breaker.allow(provider); err != nil {
+		return RatingData{}, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		data, err := call()
+		if err == nil || !transient(ctx, err) || attempt > config.OpenAIRetries {
+			// Only timeouts and transient failures suggest the provider is
+			// down; a rejected request says nothing either way.
+			switch {
+			case err == nil:
+				breaker.succeeded()
+			case transient(ctx, err) || ctx.Err() == nil && errors.Is(err, errFrameTimeout):
+				breaker.failed(provider)
+			}
+			return data, err
+		}
+
+		delay := retryDelay(err, attempt)
+		log.Printf("%s call failed, retry %d of %d in %v: %v", provider, attempt, config.OpenAIRetries, delay.Round(time.Millisecond), err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return RatingData{}, err
+		}
+	}
+}
+
+// circuitBreaker stops calling a provider that keeps failing. Once
+// OPENAI_BREAKER_THRESHOLD calls in a row fail even after their retries,
+// calls fail at once with errProviderDown for OPENAI_BREAKER_COOLDOWN.
+// Calls after that probe the provider again, and the first failure
+// reopens the breaker.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// breakers holds the circuit breaker of each analysis provider.
+var breakers = struct {
+	mu      sync.Mutex
+	entries map[string]*circuitBreaker
+}{entries: make(map[string]*circuitBreaker)}
+
+func providerBreaker(provider string) *circuitBreaker {
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+	breaker, ok := breakers.entries[provider]
+	if !ok {
+		breaker = &circuitBreaker{}
+		breakers.entries[provider] = breaker
+	}
+	return breaker
+}
+
+// allow returns errProviderDown while the breaker is open.
+func (b *circuitBreaker) allow(provider string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if wait := time.Until(b.openUntil); wait > 0 {
+		return fmt.Errorf("%w: %s failed %d calls in a row, retrying in %v", errProviderDown, provider, b.failures, wait.Round(time.Second))
+	}
+	return nil
+}
+
+func (b *circuitBreaker) succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+func (b *circuitBreaker) failed(provider string) {
+	if config.OpenAIBreakerThreshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures >= config.OpenAIBreakerThreshold {
+		if time.Now().After(b.openUntil) {
+			log.Printf("%s failed %d calls in a row, pausing calls for %v", provider, b.failures, config.OpenAIBreakerCooldown)
+		}
+		b.openUntil = time.Now().Add(config.OpenAIBreakerCooldown)
+	}
+}
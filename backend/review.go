@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gocv.io/x/gocv"
+)
+
+// minConfidence returns the lower of two optional confidences.
+func minConfidence(a, b *float64) *float64 {
+	if a == nil {
+		return b
+	}
+	if b == nil || *a <= *b {
+		return a
+	}
+	return b
+}
+
+// ReviewItem is a segment queued for human review.
+type ReviewItem struct {
+	RatingResult
+	Reasons   []string `json:"reasons"`
+	Priority  float64  `json:"priority"`
+	Thumbnail string   `json:"thumbnail,omitempty"`
+}
+
+// buildReviewQueue selects segments worth a human look: those rated at the
+// target age or one level above it (where a misrating flips the censor
+// decision), and those with confidence below threshold. Higher priority
+// items come first.
+func buildReviewQueue(ratings []RatingResult, age int, threshold float64) []ReviewItem {
+	ageLevel := ageLevel(age)
+
+	items := []ReviewItem{}
+	for _, rating := range ratings {
+		var reasons []string
+		priority := 0.0
+
+		level := ratingLevel(rating.Rating)
+		if level >= 0 && (level == ageLevel || level == ageLevel+1) {
+			reasons = append(reasons, "borderline")
+			priority += 0.5
+		}
+		if rating.Confidence != nil && *rating.Confidence < threshold {
+			reasons = append(reasons, "low_confidence")
+			priority += 1 - *rating.Confidence
+		}
+
+		if len(reasons) > 0 {
+			items = append(items, ReviewItem{RatingResult: rating, Reasons: reasons, Priority: priority})
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Priority != items[j].Priority {
+			return items[i].Priority > items[j].Priority
+		}
+		return items[i].Start < items[j].Start
+	})
+	return items
+}
+
+// attachReviewThumbnails adds a small JPEG of each item's midpoint frame as
+// a data URL.
+func attachReviewThumbnails(videoPath string, items []ReviewItem) error {
+	video, err := gocv.VideoCaptureFile(videoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open video: %v", err)
+	}
+	defer video.Close()
+
+	img := gocv.NewMat()
+	defer img.Close()
+
+	for i := range items {
+		midpoint := (items[i].Start + items[i].End) / 2
+		video.Set(gocv.VideoCapturePosMsec, midpoint*1000)
+		if ok := video.Read(&img); !ok || img.Empty() {
+			continue
+		}
+
+		thumb := gocv.NewMat()
+		height := config.ThumbnailWidth * img.Rows() / img.Cols()
+		gocv.Resize(img, &thumb, image.Point{X: config.ThumbnailWidth, Y: height}, 0, 0, config.ResizeInterpolation)
+		buf, err := gocv.IMEncode(".jpg", thumb)
+		thumb.Close()
+		if err != nil {
+			continue
+		}
+		items[i].Thumbnail = "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.GetBytes())
+		buf.Close()
+	}
+	return nil
+}
+
+// reviewQueue handles POST /review-queue. It takes the ratings from /upload,
+// the target age, an optional confidence_threshold and optionally the video
+// itself for thumbnails.
+func reviewQueue(c *gin.Context) {
+	age, err := parseAge(c.PostForm("age"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var ratings []RatingResult
+	if err := json.Unmarshal([]byte(c.PostForm("ratings")), &ratings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid ratings format: %v", err)})
+		return
+	}
+
+	threshold := config.ReviewConfidenceThreshold
+	if raw := c.PostForm("confidence_threshold"); raw != "" {
+		threshold, err = strconv.ParseFloat(raw, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid confidence_threshold: %s", raw)})
+			return
+		}
+	}
+
+	items := buildReviewQueue(ratings, age, threshold)
+
+	if file, err := c.FormFile("video"); err == nil && len(items) > 0 {
+		if err := checkUploadType(file); err != nil {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error(), "code": "UNSUPPORTED_MEDIA_TYPE"})
+			return
+		}
+
+		filename := filepath.Join(uploadFolder, fmt.Sprintf("review_%d%s", time.Now().UnixNano(), filepath.Ext(file.Filename)))
+		if err := c.SaveUploadedFile(file, filename); err != nil {
+			respondSaveError(c, filename, err)
+			return
+		}
+		defer os.Remove(filename)
+
+		if err := attachReviewThumbnails(filename, items); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "INVALID_VIDEO"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": items, "count": len(items)})
+}
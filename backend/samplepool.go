@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"gocv.io/x/gocv"
+)
+
+// samplePool analyzes the sampled frames of a video on up to
+// ANALYSIS_WORKERS goroutines while the video is still being read. Results
+// are collected in the order frames were submitted, so seconds and
+// segments are assembled exactly as in a sequential pass.
+type samplePool struct {
+	ctx   context.Context
+	opts  AnalysisOptions
+	slots chan struct{}
+	wg    sync.WaitGroup
+	// openAIDown is shared by every worker: once one falls back, the rest
+	// of the video is rated by the fallback policy.
+	openAIDown atomic.Bool
+	failed     atomic.Bool
+}
+
+// pendingSample is a frame submitted to a samplePool. Its result is set
+// once done is closed.
+type pendingSample struct {
+	frameIndex int
+	timestamp  float64
+	done       chan struct{}
+	sample     *frameSample
+	err        error
+}
+
+// pendingSecond holds the submitted samples of one second of video,
+// starting at start. A second without samples repeats the last sample
+// before it if carry is set, as with scene or interval sampling.
+type pendingSecond struct {
+	start   float64
+	samples []*pendingSample
+	carry   bool
+}
+
+// sampleCache keeps the samples of every analyzed frame by frame index, so
+// a later pass over the same video only analyzes frames it has not seen.
+type sampleCache struct {
+	mu      sync.Mutex
+	samples map[int]*frameSample
+}
+
+func newSampleCache() *sampleCache {
+	return &sampleCache{samples: make(map[int]*frameSample)}
+}
+
+func (c *sampleCache) get(frameIndex int) (*frameSample, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sample, ok := c.samples[frameIndex]
+	return sample, ok
+}
+
+func (c *sampleCache) put(frameIndex int, sample *frameSample) {
+	if c != nil {
+		c.mu.Lock()
+		c.samples[frameIndex] = sample
+		c.mu.Unlock()
+	}
+}
+
+func newSamplePool(ctx context.Context, opts AnalysisOptions) *samplePool {
+	return &samplePool{ctx: ctx, opts: opts, slots: make(chan struct{}, max(1, config.AnalysisWorkers))}
+}
+
+// submit analyzes a copy of img, the frame at frameIndex, once a worker is
+// free. It blocks while every worker is busy, so at most ANALYSIS_WORKERS
+// frames are held in memory.
+func (p *samplePool) submit(img gocv.Mat, frameIndex int, timestamp float64) *pendingSample {
+	pending := &pendingSample{frameIndex: frameIndex, timestamp: timestamp, done: make(chan struct{})}
+	if sample, ok := p.opts.sampleCache.get(frameIndex); ok {
+		pending.sample = sample
+		close(pending.done)
+		return pending
+	}
+	frame := img.Clone()
+	p.slots <- struct{}{}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(pending.done)
+		pending.sample, pending.err = analyzeSample(p.ctx, frame, timestamp, p.opts, &p.openAIDown)
+		frame.Close()
+		<-p.slots
+		p.opts.Progress.sampled()
+		if pending.err != nil {
+			p.failed.Store(true)
+		} else {
+			p.opts.sampleCache.put(frameIndex, pending.sample)
+		}
+	}()
+	return pending
+}
+
+// wait blocks until every submitted frame has been analyzed.
+func (p *samplePool) wait() {
+	p.wg.Wait()
+}
+
+// ready reports whether every sample of the second has been analyzed.
+func (s pendingSecond) ready() bool {
+	for _, sample := range s.samples {
+		select {
+		case <-sample.done:
+		default:
+			return false
+		}
+	}
+	return true
+}
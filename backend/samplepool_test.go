@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// brightnessAnalyzer rates a frame by its brightness, answering after a
+// delay that varies by frame so parallel workers finish out of order.
+type brightnessAnalyzer struct{}
+
+func (brightnessAnalyzer) Name() string { return "brightness" }
+
+func (brightnessAnalyzer) Analyze(ctx context.Context, img gocv.Mat, dataURL, sceneContext string) (RatingData, error) {
+	brightness := int(img.Mean().Val1)
+	time.Sleep(time.Duration(brightness%4) * time.Millisecond)
+	names := ratingNames()
+	level := min(brightness/100, len(names)-1)
+	confidence := 0.5 + float64(level)/10
+	return RatingData{Rating: names[level], Notes: fmt.Sprintf("level %d", level), Confidence: &confidence}, nil
+}
+
+// writeTestVideo writes a clip whose brightness level changes every
+// second. Every other second is split evenly between two levels, so the
+// vote has ties to break. The test is skipped if no codec is available.
+func writeTestVideo(t *testing.T, fps, frames int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "clip.mp4")
+	writer, err := newOutputWriter(path, float64(fps), 64, 64)
+	if err != nil {
+		t.Skipf("cannot write test video: %v", err)
+	}
+	for i := 0; i < frames; i++ {
+		second, offset := i/fps, i%fps
+		level := second % 3
+		if second%2 == 1 && offset >= fps/2 {
+			level = (level + 1) % 3
+		}
+		brightness := float64(40 + 100*level + i%5)
+		frame := gocv.NewMatWithSizeFromScalar(gocv.Scalar{Val1: brightness, Val2: brightness, Val3: brightness}, 64, 64, gocv.MatTypeCV8UC3)
+		err := writer.Write(frame)
+		frame.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAnalyzeVideoSameRatingsForAnyWorkerCount(t *testing.T) {
+	defer func(chain []Analyzer, workers int, vote string) {
+		analyzers, config.AnalysisWorkers, config.SampleVote = chain, workers, vote
+	}(analyzers, config.AnalysisWorkers, config.SampleVote)
+	analyzers = []Analyzer{brightnessAnalyzer{}}
+	config.SampleVote = sampleVoteMajority
+
+	path := writeTestVideo(t, 10, 65)
+	opts := AnalysisOptions{SamplesPerSecond: 4, Verbose: true}
+
+	var ratings [][]RatingResult
+	for _, workers := range []int{1, 8} {
+		config.AnalysisWorkers = workers
+		cursor, err := analyzeVideoFrom(context.Background(), path, opts, AnalysisCursor{}, true)
+		if err != nil {
+			t.Fatalf("%d workers: %v", workers, err)
+		}
+		ratings = append(ratings, cursor.Ratings)
+	}
+
+	if len(ratings[0]) < 2 {
+		t.Fatalf("expected several segments, got %+v", ratings[0])
+	}
+	if !reflect.DeepEqual(ratings[0], ratings[1]) {
+		t.Errorf("ratings differ:\n1 worker:  %+v\n8 workers: %+v", ratings[0], ratings[1])
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"gocv.io/x/gocv"
+)
+
+// Ways of combining the samples taken within one second.
+const (
+	sampleVoteStrictest = "strictest"
+	sampleVoteMajority  = "majority"
+)
+
+// frameSample is the analysis of one sampled frame.
+type frameSample struct {
+	Rating     string
+	Notes      string
+	Confidence *float64
+	// Regions is set when blurring them is enough: the on-screen text that
+	// raised the rating, or the boxes the model located with REGION_BLUR.
+	Regions []BlurRegion
+	// Keyframes time those regions for the blur to follow.
+	Keyframes []RegionKeyframe
+}
+
+// sampleOffsets marks which frames within each second of a video at fps
+// frames per second are analyzed, spreading perSecond samples evenly from
+// the first frame. It also returns the offset of the last sample.
+func sampleOffsets(fps, perSecond int) ([]bool, int) {
+	if perSecond < 1 {
+		perSecond = 1
+	}
+	if perSecond > fps {
+		perSecond = fps
+	}
+
+	offsets := make([]bool, fps)
+	last := 0
+	for i := 0; i < perSecond; i++ {
+		last = i * fps / perSecond
+		offsets[last] = true
+	}
+	return offsets, last
+}
+
+// maxSampleInterval is the longest sample_interval_seconds, in seconds.
+const maxSampleInterval = 60
+
+// resolution returns the size frames are scaled to for analysis.
+func (o AnalysisOptions) resolution() int {
+	if o.Resolution > 0 {
+		return o.Resolution
+	}
+	return config.AnalysisResolution
+}
+
+// analyzeSample rates one sampled frame with the analyzer chain, or the
+// fallback policy once openAIDown is set, then applies the note floors and OCR. It returns
+// nil without an error if the frame could not be encoded.
+func analyzeSample(ctx context.Context, img gocv.Mat, timestamp float64, opts AnalysisOptions, openAIDown *atomic.Bool) (*frameSample, error) {
+	dataURL, err := encodeSample(img, opts.Interpolation, opts.resolution())
+	if err != nil {
+		return nil, nil
+	}
+
+	var data RatingData
+	if config.SkipBlankFrames && isBlankFrame(img) {
+		data = RatingData{Rating: mildestRating()}
+	} else if openAIDown.Load() {
+		data, err = fallbackRating(img, errOpenAIDown)
+	} else {
+		data, err = analyzeFrame(ctx, img, dataURL, opts.SceneContext, timestamp)
+		if ctx.Err() != nil {
+			return nil, checkContext(ctx)
+		}
+		if err != nil && config.FallbackPolicy != fallbackFail {
+			log.Printf("Analysis unavailable at %.2fs, applying %s fallback: %v", timestamp, config.FallbackPolicy, err)
+			openAIDown.Store(true)
+			data, err = fallbackRating(img, err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sample := &frameSample{
+		Rating:     applyNoteRatingFloors(data.Rating, data.Notes),
+		Notes:      data.Notes,
+		Confidence: data.Confidence,
+	}
+	if config.RegionBlur && sample.Rating == data.Rating {
+		sample.Regions = modelRegions(data.Regions)
+	}
+
+	if config.OCREnabled {
+		words, regions, err := detectBlockedText(img)
+		if err != nil {
+			log.Printf("OCR failed at %.2fs: %v", timestamp, err)
+		} else if len(words) > 0 {
+			sample.Notes += ", text: " + strings.Join(words, " ")
+			if getRatingValue(config.OCRRating) > getRatingValue(sample.Rating) {
+				sample.Rating = config.OCRRating
+				sample.Regions = append(sample.Regions, regions...)
+			}
+		}
+	}
+	if sample.Regions != nil {
+		sample.Keyframes = []RegionKeyframe{{Timestamp: timestamp, Regions: sample.Regions}}
+	}
+
+	return sample, nil
+}
+
+// voteSamples combines the samples of one second into a single sample.
+// The rating is the most restrictive one, or with SAMPLE_VOTE=majority the
+// most common one (ties go to the more restrictive). Notes from every
+// sample are kept and the lowest confidence wins. Regions are kept only if
+// every sample at the chosen rating has them.
+func voteSamples(samples []frameSample) frameSample {
+	if len(samples) == 1 {
+		return samples[0]
+	}
+
+	rating := ""
+	if config.SampleVote == sampleVoteMajority {
+		counts := make(map[string]int)
+		for _, s := range samples {
+			counts[s.Rating]++
+		}
+		// Ratings are visited in sample order, not map order, so full ties
+		// (equal count and value) always resolve the same way.
+		for _, s := range samples {
+			r, n := s.Rating, counts[s.Rating]
+			if rating == "" || n > counts[rating] || (n == counts[rating] && getRatingValue(r) > getRatingValue(rating)) {
+				rating = r
+			}
+		}
+	} else {
+		for _, s := range samples {
+			if rating == "" || getRatingValue(s.Rating) > getRatingValue(rating) {
+				rating = s.Rating
+			}
+		}
+	}
+
+	combined := frameSample{Rating: rating}
+	var notes []string
+	regionOnly := true
+	for _, s := range samples {
+		notes = append(notes, s.Notes)
+		combined.Confidence = minConfidence(combined.Confidence, s.Confidence)
+		if s.Rating == rating {
+			if s.Regions == nil {
+				regionOnly = false
+			}
+			combined.Regions = append(combined.Regions, s.Regions...)
+			combined.Keyframes = append(combined.Keyframes, s.Keyframes...)
+		}
+	}
+	combined.Notes = strings.Join(notes, ", ")
+	if !regionOnly {
+		combined.Regions, combined.Keyframes = nil, nil
+	}
+	return combined
+}
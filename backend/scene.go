@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseSceneContext validates a client's scene context: a short note on
+// the video a frame comes from (genre, known content warnings) that helps
+// the model read ambiguous frames. Whitespace is collapsed so it stays a
+// single line of the prompt.
+func parseSceneContext(raw string) (string, error) {
+	text := strings.Join(strings.Fields(raw), " ")
+	if len(text) > config.SceneContextMaxLength {
+		return "", fmt.Errorf("scene_context must be at most %d characters", config.SceneContextMaxLength)
+	}
+	return text, nil
+}
+
+// scenePrompt returns the prompt preamble for sceneContext, or "" if
+// there is none. The model is told to use it only to interpret what it
+// sees, so a context cannot lower the rating of visible content.
+func scenePrompt(sceneContext string) string {
+	if sceneContext == "" {
+		return ""
+	}
+	return "Context about the video this frame comes from: " + sceneContext + "\n" +
+		"Use this context only to interpret ambiguous content (for example red sauce versus blood); rate what is actually visible.\n\n"
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+)
+
+// Sampling modes of /upload's sampling option.
+const (
+	samplingFixed = "fixed"
+	samplingScene = "scene"
+)
+
+// analyzeScenes analyzes videoPath shot by shot instead of second by
+// second. Shot cuts are found with the SHOT_CUT_THRESHOLD histogram
+// comparison, and sceneSampleFrames picks a few frames of each shot, so
+// static scenes cost few calls while a flash cut in and out of a shot is
+// still seen. Wherever two of those samples disagree, a second pass
+// analyzes the SamplesPerSecond grid between them to place the boundary.
+// Scene analyses are not checkpointed.
+func analyzeScenes(ctx context.Context, videoPath string, opts AnalysisOptions) (AnalysisCursor, error) {
+	cuts, fps, totalFrames, err := detectShotFrames(videoPath)
+	if err != nil {
+		return AnalysisCursor{}, err
+	}
+	opts.sampleFrames = sceneSampleFrames(cuts, fps, totalFrames, config.SceneMaxInterval)
+	opts.sampleCache = newSampleCache()
+	log.Printf("Sampling %d frames from %d shots", len(opts.sampleFrames), len(cuts)+1)
+
+	// The timeline is only recorded by the pass whose segments are kept.
+	first := opts
+	first.Timeline = nil
+	cursor, err := analyzeVideoFrom(ctx, videoPath, first, AnalysisCursor{}, true)
+	if err != nil || analysisFailed(cursor) {
+		return cursor, err
+	}
+
+	extra := boundaryFrames(opts.sampleCache, fps, opts.SamplesPerSecond)
+	if len(extra) == 0 && opts.Timeline == nil {
+		return cursor, nil
+	}
+	for _, frame := range extra {
+		opts.sampleFrames[frame] = true
+	}
+	log.Printf("Sampling %d more frames around rating changes", len(extra))
+	opts.Progress.restartSegments()
+	return analyzeVideoFrom(ctx, videoPath, opts, AnalysisCursor{}, true)
+}
+
+// sceneSampleFrames returns the frames to analyze in a video of
+// totalFrames frames at fps with shot cuts at cuts: one frame of each shot,
+// half a second in (or halfway, for shorter shots) to skip transitions,
+// and then one every maxInterval seconds for as long as the shot lasts.
+func sceneSampleFrames(cuts []int, fps float64, totalFrames int, maxInterval float64) map[int]bool {
+	step := max(1, int(maxInterval*fps))
+	starts := append([]int{0}, cuts...)
+	frames := make(map[int]bool)
+	for i, start := range starts {
+		end := totalFrames
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		if end <= start {
+			continue
+		}
+		for frame := start + min((end-start)/2, int(fps)/2); frame < end; frame += step {
+			frames[frame] = true
+		}
+	}
+	return frames
+}
+
+// boundaryFrames returns the frames of the perSecond sampling grid lying
+// between two cached samples, next to each other in time, whose ratings
+// differ and that are not yet analyzed.
+func boundaryFrames(cache *sampleCache, fps float64, perSecond int) []int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	analyzed := make([]int, 0, len(cache.samples))
+	for frame, sample := range cache.samples {
+		if sample != nil {
+			analyzed = append(analyzed, frame)
+		}
+	}
+	sort.Ints(analyzed)
+
+	offsets, _ := sampleOffsets(int(fps), perSecond)
+	var frames []int
+	for i := 1; i < len(analyzed); i++ {
+		from, to := analyzed[i-1], analyzed[i]
+		if cache.samples[from].Rating == cache.samples[to].Rating {
+			continue
+		}
+		for frame := from + 1; frame < to; frame++ {
+			if _, seen := cache.samples[frame]; !seen && offsets[frame%int(fps)] {
+				frames = append(frames, frame)
+			}
+		}
+	}
+	return frames
+}
+
+// analysisFailed reports whether cursor ends with the error rating of a
+// frame that could not be rated.
+func analysisFailed(cursor AnalysisCursor) bool {
+	n := len(cursor.Ratings)
+	return n > 0 && ratingLevel(cursor.Ratings[n-1].Rating) < 0
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// segmentGap is the largest gap between two segments still treated as
+// contiguous. Segments end one sample before the next one starts.
+const segmentGap = 1.0 + 0.001
+
+// ignoreBriefCensorSpans returns a copy of ratings in which runs of
+// consecutive over-age segments shorter than minDuration seconds are
+// relabeled as acceptable for age, so they are neither blurred nor trimmed.
+func ignoreBriefCensorSpans(ratings []RatingResult, age int, minDuration float64) []RatingResult {
+	result := make([]RatingResult, len(ratings))
+	copy(result, ratings)
+	if minDuration <= 0 {
+		return result
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Start < result[j].Start
+	})
+
+	for i := 0; i < len(result); {
+		if getRatingValue(result[i].Rating) <= age {
+			i++
+			continue
+		}
+
+		j := i
+		for j+1 < len(result) &&
+			getRatingValue(result[j+1].Rating) > age &&
+			result[j+1].Start-result[j].End <= segmentGap {
+			j++
+		}
+
+		if result[j].End-result[i].Start < minDuration {
+			for k := i; k <= j; k++ {
+				result[k].Rating = config.RatingScale[ageLevel(age)].Name
+				result[k].Regions, result[k].RegionKeyframes = nil, nil
+			}
+		}
+		i = j + 1
+	}
+
+	return result
+}
+
+// censoredSegments returns the segments rated above age, in start order.
+func censoredSegments(ratings []RatingResult, age int) []RatingResult {
+	segments := []RatingResult{}
+	for _, rating := range ratings {
+		if getRatingValue(rating.Rating) > age {
+			segments = append(segments, rating)
+		}
+	}
+
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].Start < segments[j].Start
+	})
+	return segments
+}
+
+// segmentsDuration sums the length of segments in seconds.
+func segmentsDuration(segments []RatingResult) float64 {
+	var total float64
+	for _, segment := range segments {
+		total += segment.End - segment.Start
+	}
+	return total
+}
+
+// timestampEpsilon absorbs floating point error when matching frame
+// timestamps against segment bounds.
+const timestampEpsilon = 0.001
+
+// covers reports whether the frame at timestamp falls in the segment,
+// bounds included.
+func (r RatingResult) covers(timestamp float64) bool {
+	return timestamp >= r.Start-timestampEpsilon && timestamp <= r.End+timestampEpsilon
+}
+
+// clampEdgeSegments returns a copy of ratings fitted to the edges of a
+// video of totalFrames frames at fps, whatever rounding the client or the
+// analysis applied. A segment starting before or within the first frame
+// starts at 0, so the very first frame is censored. A segment ending
+// within or past the last frame ends no earlier than the duration, so the
+// final frame is censored too. Ends are never pulled in, since OpenCV's
+// frame count is only an estimate for some containers.
+func clampEdgeSegments(ratings []RatingResult, fps float64, totalFrames int) []RatingResult {
+	result := make([]RatingResult, len(ratings))
+	copy(result, ratings)
+	if fps <= 0 || totalFrames <= 0 {
+		return result
+	}
+
+	frame := 1 / fps
+	duration := float64(totalFrames) * frame
+	for i := range result {
+		if result[i].Start < frame {
+			result[i].Start = 0
+		}
+		if result[i].End > duration-frame {
+			result[i].End = max(result[i].End, duration)
+		}
+	}
+	return result
+}
+
+// consolidateSegments returns a copy of ratings, sorted by start, in which
+// every segment shorter than minDuration seconds is folded into the
+// stricter of its neighbours no more than maxGap seconds away, taking the
+// most restrictive of their ratings. The shortest segment is folded first,
+// and neighbours left with the same rating are joined, so a brief 12+
+// span between two 16+ spans becomes one 16+ span. Segments without a
+// valid rating are left alone.
+func consolidateSegments(ratings []RatingResult, minDuration, maxGap float64) []RatingResult {
+	result := sortedRatings(ratings)
+	if minDuration <= 0 {
+		return result
+	}
+
+	near := func(i, j int) bool {
+		return i >= 0 && j < len(result) &&
+			ratingLevel(result[i].Rating) >= 0 && ratingLevel(result[j].Rating) >= 0 &&
+			result[j].Start-result[i].End <= maxGap+timestampEpsilon
+	}
+
+	for {
+		shortest := -1
+		for i, r := range result {
+			if r.End-r.Start >= minDuration || !near(i-1, i) && !near(i, i+1) {
+				continue
+			}
+			if shortest < 0 || r.End-r.Start < result[shortest].End-result[shortest].Start {
+				shortest = i
+			}
+		}
+		if shortest < 0 {
+			break
+		}
+
+		// Fold into the stricter neighbour; on a tie, the earlier one.
+		i := shortest - 1
+		if !near(i, shortest) || near(shortest, shortest+1) &&
+			ratingLevel(result[shortest+1].Rating) > ratingLevel(result[i].Rating) {
+			i = shortest
+		}
+		result[i] = joinSegments(result[i], result[i+1])
+		result = append(result[:i+1], result[i+2:]...)
+
+		for j := max(i-1, 0); j+1 < len(result) && j <= i; {
+			if near(j, j+1) && result[j].Rating == result[j+1].Rating {
+				result[j] = joinSegments(result[j], result[j+1])
+				result = append(result[:j+1], result[j+2:]...)
+				i--
+				continue
+			}
+			j++
+		}
+	}
+	return result
+}
+
+// joinSegments combines a segment with the one following it into one
+// spanning both, at the stricter rating. Blur regions survive only if both
+// segments are blurred by region.
+func joinSegments(a, b RatingResult) RatingResult {
+	joined := a
+	joined.End = max(a.End, b.End)
+	if ratingLevel(b.Rating) > ratingLevel(a.Rating) {
+		joined.Rating = b.Rating
+	}
+	joined.Confidence = minConfidence(a.Confidence, b.Confidence)
+	joined.Samples = append(append([]SampleDetail(nil), a.Samples...), b.Samples...)
+	joined.Regions, joined.RegionKeyframes = nil, nil
+	if len(a.Regions) > 0 && len(b.Regions) > 0 {
+		joined.Regions = append(append([]BlurRegion(nil), a.Regions...), b.Regions...)
+		joined.RegionKeyframes = append(append([]RegionKeyframe(nil), a.RegionKeyframes...), b.RegionKeyframes...)
+	}
+
+	counts := make(map[string]int)
+	for _, note := range strings.Split(a.Notes+","+b.Notes, ",") {
+		if note = strings.TrimSpace(note); note != "" && note != notesEllipsis {
+			counts[note]++
+		}
+	}
+	joined.Notes = joinNotes(counts)
+	return joined
+}
@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestClampEdgeSegments(t *testing.T) {
+	const fps, totalFrames = 25.0, 250 // 10s
+	lastFrame := float64(totalFrames-1) / fps
+
+	tests := []struct {
+		name       string
+		segment    RatingResult
+		start, end float64
+	}{
+		{"starts at 0", RatingResult{Start: 0, End: 2}, 0, 2},
+		{"starts within the first frame", RatingResult{Start: 0.03, End: 2}, 0, 2},
+		{"starts before 0", RatingResult{Start: -0.5, End: 2}, 0, 2},
+		{"starts after the first frame", RatingResult{Start: 0.04, End: 2}, 0.04, 2},
+		{"ends at the duration", RatingResult{Start: 8, End: 10}, 8, 10},
+		{"ends within the last frame", RatingResult{Start: 8, End: 9.97}, 8, 10},
+		{"ends past the duration", RatingResult{Start: 8, End: 12}, 8, 12},
+		{"ends before the last frame", RatingResult{Start: 8, End: 9.9}, 8, 9.9},
+		{"spans the whole video", RatingResult{Start: 0.01, End: 9.99}, 0, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.segment.Rating = strictestRating()
+			clamped := clampEdgeSegments([]RatingResult{tt.segment}, fps, totalFrames)
+			if got := clamped[0]; got.Start != tt.start || got.End != tt.end {
+				t.Fatalf("got [%v, %v], want [%v, %v]", got.Start, got.End, tt.start, tt.end)
+			}
+
+			// The first and last frames are blurred, and cut by trim,
+			// exactly when the segment reaches them.
+			for _, timestamp := range []float64{0, lastFrame} {
+				want := timestamp == 0 && tt.start == 0 || timestamp == lastFrame && tt.end >= 10
+				if got := clamped[0].covers(timestamp); got != want {
+					t.Errorf("covers %vs: got %v, want %v", timestamp, got, want)
+				}
+				if got := needsBlur(clamped, 0, false, timestamp); got != want {
+					t.Errorf("blur at %vs: got %v, want %v", timestamp, got, want)
+				}
+				if kept, _ := trimKeepsFrame(clamped, 0, timestamp); want && kept {
+					t.Errorf("trim at %vs kept a censored frame", timestamp)
+				}
+			}
+		})
+	}
+}
+
+func TestClampEdgeSegmentsLeavesOthersAlone(t *testing.T) {
+	ratings := []RatingResult{{Start: 0.02, End: 3, Rating: "16+"}, {Start: 3, End: 7, Rating: "6+"}, {Start: 7, End: 9.98, Rating: "16+"}}
+	clamped := clampEdgeSegments(ratings, 25, 250)
+
+	want := []RatingResult{{Start: 0, End: 3, Rating: "16+"}, {Start: 3, End: 7, Rating: "6+"}, {Start: 7, End: 10, Rating: "16+"}}
+	for i := range want {
+		if clamped[i].Start != want[i].Start || clamped[i].End != want[i].End {
+			t.Errorf("segment %d: got [%v, %v], want [%v, %v]", i, clamped[i].Start, clamped[i].End, want[i].Start, want[i].End)
+		}
+	}
+	if ratings[0].Start != 0.02 || ratings[2].End != 9.98 {
+		t.Error("clampEdgeSegments changed its input")
+	}
+	if clamped := clampEdgeSegments(ratings, 0, 250); clamped[0].Start != 0.02 {
+		t.Error("segments were clamped without a frame rate")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// detectShotCuts returns the timestamps, in seconds, of the shot cuts in
+// videoPath; see detectShotFrames.
+func detectShotCuts(videoPath string) ([]float64, error) {
+	frames, fps, _, err := detectShotFrames(videoPath)
+	if err != nil {
+		return nil, err
+	}
+	cuts := make([]float64, len(frames))
+	for i, frame := range frames {
+		cuts[i] = float64(frame) / fps
+	}
+	return cuts, nil
+}
+
+// detectShotFrames returns the frame indices of the shot cuts in
+// videoPath, along with its frame rate and the number of frames read. A
+// cut is a frame whose hue/saturation histogram differs from the previous
+// frame's by more than SHOT_CUT_THRESHOLD (Bhattacharyya distance, 0 =
+// identical, 1 = disjoint).
+func detectShotFrames(videoPath string) ([]int, float64, int, error) {
+	video, err := gocv.VideoCaptureFile(videoPath)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to open video: %v", err)
+	}
+	defer video.Close()
+
+	fps := video.Get(gocv.VideoCaptureFPS)
+	if fps <= 0 {
+		fps = 30 // Default to 30fps if unable to determine
+	}
+
+	img := gocv.NewMat()
+	defer img.Close()
+	hsv := gocv.NewMat()
+	defer hsv.Close()
+	mask := gocv.NewMat()
+	defer mask.Close()
+	hist := gocv.NewMat()
+	defer hist.Close()
+	prev := gocv.NewMat()
+	defer prev.Close()
+
+	var cuts []int
+	frameIndex := 0
+	for ; ; frameIndex++ {
+		if ok := video.Read(&img); !ok || img.Empty() {
+			break
+		}
+
+		gocv.CvtColor(img, &hsv, gocv.ColorBGRToHSV)
+		gocv.CalcHist([]gocv.Mat{hsv}, []int{0, 1}, mask, &hist, []int{30, 32}, []float64{0, 180, 0, 256}, false)
+		gocv.Normalize(hist, &hist, 0, 1, gocv.NormMinMax)
+
+		if !prev.Empty() && float64(gocv.CompareHist(prev, hist, gocv.HistCmpBhattacharya)) > config.ShotCutThreshold {
+			cuts = append(cuts, frameIndex)
+		}
+		hist.CopyTo(&prev)
+	}
+
+	return cuts, fps, frameIndex, nil
+}
+
+// snapToShotCuts moves each boundary between consecutive segments to the
+// nearest shot cut within window seconds, so censoring starts and stops on
+// a cut rather than mid-shot. The end of the earlier segment moves by the
+// same amount as the start of the later one. ratings must be in start
+// order, as processVideo produces them.
+func snapToShotCuts(ratings []RatingResult, cuts []float64, window float64) []RatingResult {
+	if len(cuts) == 0 {
+		return ratings
+	}
+
+	for i := 1; i < len(ratings); i++ {
+		boundary := ratings[i].Start
+		nearest, distance := boundary, math.Inf(1)
+		for _, cut := range cuts {
+			if d := math.Abs(cut - boundary); d <= window && d < distance {
+				nearest, distance = cut, d
+			}
+		}
+
+		shift := nearest - boundary
+		if shift == 0 {
+			continue
+		}
+		// Keep both segments non-empty.
+		if ratings[i].Start+shift >= ratings[i].End || ratings[i-1].End+shift <= ratings[i-1].Start {
+			continue
+		}
+		ratings[i].Start += shift
+		ratings[i-1].End += shift
+	}
+	return ratings
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Storage is where clients upload source videos and download results
+// directly, using pre-signed URLs, so large files don't pass through the
+// handlers.
+type Storage interface {
+	// SignedUploadURL returns a URL the client can PUT an object to.
+	SignedUploadURL(key string, expiry time.Duration) (string, error)
+	// SignedDownloadURL returns a URL the client can GET an object from.
+	SignedDownloadURL(key string, expiry time.Duration) (string, error)
+	// Fetch copies the object at key to localPath, giving up when ctx
+	// ends.
+	Fetch(ctx context.Context, key, localPath string) error
+	// Store copies localPath to the object at key, giving up when ctx
+	// ends.
+	Store(ctx context.Context, localPath, key string) error
+}
+
+// storage is the configured Storage backend.
+var storage Storage
+
+func newStorage() (Storage, error) {
+	switch config.StorageBackend {
+	case "s3":
+		if config.S3Bucket == "" || config.S3AccessKey == "" || config.S3SecretKey == "" {
+			return nil, fmt.Errorf("S3 storage requires S3_BUCKET, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		return &s3Storage{client: &http.Client{Timeout: config.StorageTimeout}}, nil
+	default:
+		if config.StorageSigningSecret == "" {
+			return nil, fmt.Errorf("local storage requires STORAGE_SIGNING_SECRET")
+		}
+		return &localStorage{dir: config.StorageDir}, nil
+	}
+}
+
+// validObjectKey rejects keys that could escape the storage root.
+func validObjectKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// localStorage keeps objects on disk and signs URLs served by this server's
+// /storage routes with an HMAC. Its URLs are relative to the server root.
+type localStorage struct {
+	dir string
+}
+
+func (s *localStorage) signedURL(method, key string, expiry time.Duration) string {
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	return fmt.Sprintf("/storage/%s?expires=%s&signature=%s", key, expires, localSignature(method, key, expires))
+}
+
+func (s *localStorage) SignedUploadURL(key string, expiry time.Duration) (string, error) {
+	return s.signedURL(http.MethodPut, key, expiry), nil
+}
+
+func (s *localStorage) SignedDownloadURL(key string, expiry time.Duration) (string, error) {
+	return s.signedURL(http.MethodGet, key, expiry), nil
+}
+
+func (s *localStorage) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+func (s *localStorage) Fetch(ctx context.Context, key, localPath string) error {
+	return copyFile(s.path(key), localPath)
+}
+
+func (s *localStorage) Store(ctx context.Context, localPath, key string) error {
+	return copyFile(localPath, s.path(key))
+}
+
+// localSignature signs method, key and expiry with STORAGE_SIGNING_SECRET.
+func localSignature(method, key, expires string) string {
+	mac := hmac.New(sha256.New, []byte(config.StorageSigningSecret))
+	fmt.Fprintf(mac, "%s\n%s\n%s", method, key, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyLocalSignature checks a /storage request's signature and expiry.
+func verifyLocalSignature(method, key, expires, signature string) bool {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return false
+	}
+	expected := localSignature(method, key, expires)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// s3Storage signs S3 (or S3-compatible) URLs with AWS Signature V4 query
+// parameters, using path-style addressing. Transfers go through client,
+// whose timeout is STORAGE_TIMEOUT.
+type s3Storage struct {
+	client *http.Client
+}
+
+func (s *s3Storage) objectURL(key string) (*url.URL, error) {
+	endpoint := config.S3Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", config.S3Region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %v", err)
+	}
+	u.Path = path.Join("/", config.S3Bucket, key)
+	return u, nil
+}
+
+func (s *s3Storage) presign(method, key string, expiry time.Duration) (string, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, config.S3Region)
+
+	query := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    config.S3AccessKey + "/" + scope,
+		"X-Amz-Date":          amzDate,
+		"X-Amz-Expires":       strconv.Itoa(int(expiry.Seconds())),
+		"X-Amz-SignedHeaders": "host",
+	}
+	canonicalQuery := canonicalQueryString(query)
+	canonicalURI := awsURIEncode(u.Path, false)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		canonicalURI,
+		canonicalQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+config.S3SecretKey), date)
+	signingKey = hmacSHA256(signingKey, config.S3Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	return fmt.Sprintf("%s://%s%s?%s&X-Amz-Signature=%s", u.Scheme, u.Host, canonicalURI, canonicalQuery, signature), nil
+}
+
+func (s *s3Storage) SignedUploadURL(key string, expiry time.Duration) (string, error) {
+	return s.presign(http.MethodPut, key, expiry)
+}
+
+func (s *s3Storage) SignedDownloadURL(key string, expiry time.Duration) (string, error) {
+	return s.presign(http.MethodGet, key, expiry)
+}
+
+func (s *s3Storage) Fetch(ctx context.Context, key, localPath string) error {
+	signedURL, err := s.presign(http.MethodGet, key, 15*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, signedURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create download request: %v", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch object: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch object %s: status %d", key, resp.StatusCode)
+	}
+
+	out, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", localPath, err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		os.Remove(localPath)
+		return fmt.Errorf("failed to download object: %v", err)
+	}
+	return nil
+}
+
+func (s *s3Storage) Store(ctx context.Context, localPath, key string) error {
+	signedURL, err := s.presign(http.MethodPut, key, 15*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", localPath, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %v", localPath, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, signedURL, file)
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %v", err)
+	}
+	req.ContentLength = info.Size()
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload object: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to upload object %s: status %d", key, resp.StatusCode)
+	}
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQueryString(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, awsURIEncode(key, true)+"="+awsURIEncode(params[key], true))
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsURIEncode percent-encodes everything but RFC 3986 unreserved
+// characters, and "/" unless encodeSlash is set, as SigV4 requires.
+func awsURIEncode(value string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		switch {
+		case ch >= 'A' && ch <= 'Z', ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~':
+			b.WriteByte(ch)
+		case ch == '/' && !encodeSlash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", src, err)
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(dst), err)
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", dst, err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("failed to copy %s: %v", src, err)
+	}
+	return nil
+}
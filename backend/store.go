@@ -0,0 +1,251 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// jobStore persists jobs, the ratings they produced or applied and the
+// files they wrote, so finished jobs survive restarts and can be listed
+// later. Queries use $n placeholders, which SQLite and Postgres both
+// accept as long as they are numbered in order of first use. A nil
+// *jobStore keeps nothing.
+type jobStore struct {
+	db *sql.DB
+}
+
+// jobDB is the configured store, or nil when DATABASE_URL is "none".
+var jobDB *jobStore
+
+var jobSchema = []string{
+	`CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		tenant TEXT NOT NULL,
+		instance TEXT,
+		status TEXT NOT NULL,
+		started_at TEXT NOT NULL,
+		finished_at TEXT,
+		ratings TEXT,
+		result TEXT,
+		failure TEXT
+	)`,
+	`CREATE INDEX IF NOT EXISTS jobs_tenant_started ON jobs (tenant, started_at)`,
+	`CREATE TABLE IF NOT EXISTS job_outputs (
+		job_id TEXT NOT NULL,
+		filename TEXT NOT NULL,
+		PRIMARY KEY (job_id, filename)
+	)`,
+}
+
+// openJobStore opens the database at url: a postgres:// URL, or otherwise
+// the path of a SQLite file, created if missing. Jobs this instance's
+// previous run left unfinished are marked failed, as nothing is processing
+// them any more; those of other instances sharing the database are left
+// alone.
+func openJobStore(url string) (*jobStore, error) {
+	driver, dsn := "sqlite3", strings.TrimPrefix(url, "sqlite:")
+	if strings.HasPrefix(url, "postgres://") || strings.HasPrefix(url, "postgresql://") {
+		driver, dsn = "postgres", url
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == "sqlite3" {
+		// SQLite allows one writer at a time.
+		db.SetMaxOpenConns(1)
+	}
+	for _, statement := range jobSchema {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create tables: %v", err)
+		}
+	}
+
+	// Tables created before jobs recorded their instance lack the column.
+	if _, err := db.Exec(`SELECT instance FROM jobs LIMIT 1`); err != nil {
+		if _, err := db.Exec(`ALTER TABLE jobs ADD COLUMN instance TEXT`); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to add the instance column: %v", err)
+		}
+	}
+
+	failure, _ := json.Marshal(gin.H{"error": "The server restarted before the job finished", "code": "INTERRUPTED"})
+	if _, err := db.Exec(`UPDATE jobs SET status = $1, failure = $2, finished_at = $3 WHERE instance = $4 AND status IN ($5, $6, $7)`,
+		jobFailed, string(failure), formatStoreTime(time.Now()), config.InstanceID, jobQueued, jobAnalyzing, jobEncoding); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to close interrupted jobs: %v", err)
+	}
+	return &jobStore{db: db}, nil
+}
+
+// create saves a newly registered job, replacing an earlier one with the
+// same ID of the same tenant. A job of another tenant is left alone;
+// registerJob refuses its ID.
+func (s *jobStore) create(id string, j *job) {
+	if s == nil {
+		return
+	}
+	s.exec("save job", `DELETE FROM job_outputs WHERE job_id = $1 AND EXISTS (SELECT 1 FROM jobs WHERE id = $1 AND tenant = $2)`, id, j.tenant)
+	s.exec("save job", `INSERT INTO jobs (id, tenant, status, started_at, instance) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET status = $3, started_at = $4, instance = $5, finished_at = NULL, ratings = NULL, result = NULL, failure = NULL
+		WHERE jobs.tenant = $2`,
+		id, j.tenant, j.status, formatStoreTime(j.started), config.InstanceID)
+}
+
+// tenant returns the tenant of the stored job id, if there is one.
+func (s *jobStore) tenant(id string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	var tenant string
+	if err := s.db.QueryRow(`SELECT tenant FROM jobs WHERE id = $1`, id).Scan(&tenant); err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to load job %s: %v", id, err)
+		}
+		return "", false
+	}
+	return tenant, true
+}
+
+// update saves the status and outcome of a job.
+func (s *jobStore) update(id string, j *job) {
+	if s == nil {
+		return
+	}
+	var finished any
+	if !j.finished.IsZero() {
+		finished = formatStoreTime(j.finished)
+	}
+	s.exec("update job", `UPDATE jobs SET status = $1, finished_at = $2, result = $3, failure = $4 WHERE id = $5`,
+		j.status, finished, storeJSON(j.result), storeJSON(j.failure), id)
+}
+
+func (s *jobStore) saveRatings(id string, ratings []RatingResult) {
+	if s != nil {
+		s.exec("save ratings", `UPDATE jobs SET ratings = $1 WHERE id = $2`, storeJSON(ratings), id)
+	}
+}
+
+func (s *jobStore) addOutput(id, filename string) {
+	if s != nil {
+		s.exec("save output", `INSERT INTO job_outputs (job_id, filename) VALUES ($1, $2) ON CONFLICT DO NOTHING`, id, filename)
+	}
+}
+
+// exec runs a write, logging failures: the job itself carries on without
+// its record.
+func (s *jobStore) exec(action, query string, args ...any) {
+	if _, err := s.db.Exec(query, args...); err != nil {
+		log.Printf("Failed to %s: %v", action, err)
+	}
+}
+
+// load returns the stored job id, if there is one.
+func (s *jobStore) load(id string) (*job, bool) {
+	if s == nil {
+		return nil, false
+	}
+	found, err := s.query(`WHERE id = $1`, id)
+	if err != nil {
+		log.Printf("Failed to load job %s: %v", id, err)
+	}
+	if len(found) == 0 {
+		return nil, false
+	}
+	return found[0].job, true
+}
+
+// list returns up to limit stored jobs of tenant, newest first, with the
+// given status if it is set.
+func (s *jobStore) list(tenant, status string, limit int) ([]storedJob, error) {
+	if status != "" {
+		return s.query(`WHERE tenant = $1 AND status = $2 ORDER BY started_at DESC LIMIT $3`, tenant, status, limit)
+	}
+	return s.query(`WHERE tenant = $1 ORDER BY started_at DESC LIMIT $2`, tenant, limit)
+}
+
+// storedJob is a job read back from the store.
+type storedJob struct {
+	id string
+	*job
+}
+
+// query reads the jobs selected by where, with their outputs.
+func (s *jobStore) query(where string, args ...any) ([]storedJob, error) {
+	rows, err := s.db.Query(`SELECT id, tenant, status, started_at, finished_at, ratings, result, failure FROM jobs `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found []storedJob
+	for rows.Next() {
+		var id, started string
+		var finished, ratings, result, failure sql.NullString
+		j := &job{}
+		if err := rows.Scan(&id, &j.tenant, &j.status, &started, &finished, &ratings, &result, &failure); err != nil {
+			return nil, err
+		}
+		j.started, _ = time.Parse(time.RFC3339Nano, started)
+		j.finished, _ = time.Parse(time.RFC3339Nano, finished.String)
+		json.Unmarshal([]byte(ratings.String), &j.ratings)
+		json.Unmarshal([]byte(result.String), &j.result)
+		json.Unmarshal([]byte(failure.String), &j.failure)
+		found = append(found, storedJob{id: id, job: j})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// A SQLite store has a single connection, which rows holds until
+	// closed.
+	rows.Close()
+
+	for _, stored := range found {
+		if stored.outputs, err = s.outputs(stored.id); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+func (s *jobStore) outputs(id string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT filename FROM job_outputs WHERE job_id = $1 ORDER BY filename`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var filenames []string
+	for rows.Next() {
+		var filename string
+		if err := rows.Scan(&filename); err != nil {
+			return nil, err
+		}
+		filenames = append(filenames, filename)
+	}
+	return filenames, rows.Err()
+}
+
+// storeTimeLayout keeps every fractional digit, unlike RFC3339Nano, so
+// times compare as text in the order they happened. time.RFC3339Nano still
+// parses it.
+const storeTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+func formatStoreTime(t time.Time) string {
+	return t.UTC().Format(storeTimeLayout)
+}
+
+// storeJSON encodes v for a TEXT column. A nil v is stored as null and
+// read back as nil.
+func storeJSON(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
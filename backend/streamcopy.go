@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errStreamCopyUnsupported means a blur output cannot be stream-copied and
+// must be encoded in full.
+var errStreamCopyUnsupported = errors.New("stream copy unavailable")
+
+// copySpan is a range of frames between two keyframes of the source that
+// is either stream-copied or, if any frame in it is blurred, re-encoded.
+type copySpan struct {
+	start, end int
+	flagged    bool
+}
+
+// encodeStreamCopy writes a blur output of videoPath to outputPath that
+// re-encodes only the keyframe intervals containing blurred frames and
+// stream-copies the rest, so clean footage keeps its original quality. The
+// re-encoded spans are rendered by the usual blur loop, then encoded with
+// the source's codec and pixel format so the spans can be concatenated. It
+// returns errStreamCopyUnsupported when the source or options do not allow
+// it.
+func encodeStreamCopy(ctx context.Context, videoPath, outputPath string, ratings []RatingResult, age int, opts ConvertOptions, fps float64, width, height, totalFrames int) (int, error) {
+	if opts.frameStep("blur", fps) != 1 || opts.Transform != nil {
+		return 0, fmt.Errorf("%w: frames are dropped or transformed", errStreamCopyUnsupported)
+	}
+	codec, pixFmt, err := probeVideoCodec(videoPath)
+	if err != nil {
+		return 0, err
+	}
+	encoder, ok := videoEncoders[codec]
+	if !ok {
+		return 0, fmt.Errorf("%w: cannot re-encode %s spans", errStreamCopyUnsupported, codec)
+	}
+	keyframes, err := probeKeyframes(videoPath, fps, totalFrames)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	spans := copySpans(keyframes, totalFrames, func(frame int) bool {
+		return needsBlur(ratings, age, opts.FailSafe, float64(frame)/fps)
+	})
+
+	base := strings.TrimSuffix(outputPath, ".mp4")
+	spanPaths := make([]string, len(spans))
+	defer func() {
+		for _, path := range spanPaths {
+			os.Remove(path)
+		}
+	}()
+
+	written, copied := 0, 0
+	for i, span := range spans {
+		if err := checkContext(ctx); err != nil {
+			return 0, err
+		}
+		spanPaths[i] = fmt.Sprintf("%s_span%d.mp4", base, i)
+
+		if !span.flagged {
+			if err := copyFrames(videoPath, spanPaths[i], float64(span.start)/fps, float64(span.end-span.start)/fps); err != nil {
+				return 0, err
+			}
+			for f := span.start; f < span.end; f++ {
+				opts.Progress.advance()
+			}
+			written += span.end - span.start
+			copied += span.end - span.start
+			continue
+		}
+
+		renderedPath := fmt.Sprintf("%s_span%d_render.mp4", base, i)
+		frames, err := encodeChunk(ctx, videoPath, renderedPath, ratings, age, "blur", opts, fps, width, height, span.start, span.end)
+		if err == nil {
+			err = reencodeSpan(renderedPath, spanPaths[i], encoder, pixFmt)
+		}
+		os.Remove(renderedPath)
+		if err != nil {
+			return 0, err
+		}
+		written += frames
+	}
+
+	if err := concatChunks(spanPaths, outputPath); err != nil {
+		return 0, err
+	}
+
+	log.Printf("Stream-copied %d of %d frames in %d spans in %v", copied, totalFrames, len(spans), time.Since(start))
+	return written, nil
+}
+
+// needsBlur reports whether blurFrame changes the frame at timestamp.
+func needsBlur(ratings []RatingResult, age int, failSafe bool, timestamp float64) bool {
+	if failSafe && uncertainAt(ratings, timestamp, config.FailSafeConfidence) {
+		return true
+	}
+	if rampKernel(ratings, age, timestamp) > 0 {
+		return true
+	}
+	for _, rating := range ratings {
+		if rating.covers(timestamp) && getRatingValue(rating.Rating) > age {
+			return true
+		}
+	}
+	return false
+}
+
+// copySpans splits [0, totalFrames) at keyframes into spans, merging
+// neighbours that are both flagged or both clean.
+func copySpans(keyframes []int, totalFrames int, flagged func(frame int) bool) []copySpan {
+	var spans []copySpan
+	for i, start := range keyframes {
+		end := totalFrames
+		if i+1 < len(keyframes) {
+			end = keyframes[i+1]
+		}
+		span := copySpan{start: start, end: end}
+		for frame := start; frame < end; frame++ {
+			if flagged(frame) {
+				span.flagged = true
+				break
+			}
+		}
+		if n := len(spans); n > 0 && spans[n-1].flagged == span.flagged {
+			spans[n-1].end = end
+			continue
+		}
+		spans = append(spans, span)
+	}
+	return spans
+}
+
+// probeKeyframes returns the frame indices of the keyframes of the first
+// video stream of path, starting with 0. Only keyframes are decoded, so
+// this is fast even for long videos.
+func probeKeyframes(path string, fps float64, totalFrames int) ([]int, error) {
+	output, err := exec.Command("ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-skip_frame", "nokey",
+		"-show_entries", "frame=pts_time",
+		"-of", "csv=p=0",
+		path,
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe keyframes: %v", err)
+	}
+
+	var times []float64
+	for _, line := range strings.Split(string(output), "\n") {
+		if t, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(line, ",")), 64); err == nil {
+			times = append(times, t)
+		}
+	}
+	if len(times) == 0 {
+		return nil, fmt.Errorf("%w: no keyframes found", errStreamCopyUnsupported)
+	}
+
+	keyframes := []int{0}
+	for _, t := range times[1:] {
+		frame := int(math.Round((t - times[0]) * fps))
+		if frame > keyframes[len(keyframes)-1] && frame < totalFrames {
+			keyframes = append(keyframes, frame)
+		}
+	}
+	return keyframes, nil
+}
+
+// copyFrames stream-copies duration seconds of the first video stream of
+// videoPath, starting at the keyframe at start, into spanPath.
+func copyFrames(videoPath, spanPath string, start, duration float64) error {
+	cmd := exec.Command("ffmpeg",
+		"-y",
+		"-v", "error",
+		"-ss", strconv.FormatFloat(start, 'f', 6, 64),
+		"-i", videoPath,
+		"-t", strconv.FormatFloat(duration, 'f', 6, 64),
+		"-map", "0:v:0",
+		"-c", "copy",
+		"-an",
+		spanPath,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(spanPath)
+		return fmt.Errorf("failed to copy span: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// reencodeSpan encodes a rendered span with the source's encoder and pixel
+// format so it can be concatenated with stream-copied spans.
+func reencodeSpan(renderedPath, spanPath, encoder, pixFmt string) error {
+	args := []string{"-y", "-v", "error", "-i", renderedPath, "-map", "0:v:0", "-c:v", encoder}
+	if pixFmt != "" {
+		args = append(args, "-pix_fmt", pixFmt)
+	}
+	args = append(args, "-an", spanPath)
+
+	if output, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+		os.Remove(spanPath)
+		return fmt.Errorf("failed to re-encode span: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Formats /upload can return ratings in besides JSON. CSV holds the
+// per-second timeline rather than the merged segments.
+const (
+	ratingsFormatJSON = "json"
+	ratingsFormatVTT  = "vtt"
+	ratingsFormatSRT  = "srt"
+	ratingsFormatCSV  = "csv"
+)
+
+// ratingsFormat picks the response format for /upload from the format
+// query parameter, falling back to the Accept header and then JSON.
+func ratingsFormat(c *gin.Context) (string, error) {
+	if raw := c.Query("format"); raw != "" {
+		switch format := strings.ToLower(raw); format {
+		case ratingsFormatJSON, ratingsFormatVTT, ratingsFormatSRT, ratingsFormatCSV:
+			return format, nil
+		}
+		return "", fmt.Errorf("format must be one of json, vtt, srt or csv")
+	}
+
+	switch c.NegotiateFormat(gin.MIMEJSON, "text/vtt", "application/x-subrip", "text/csv") {
+	case "text/vtt":
+		return ratingsFormatVTT, nil
+	case "application/x-subrip":
+		return ratingsFormatSRT, nil
+	case "text/csv":
+		return ratingsFormatCSV, nil
+	}
+	return ratingsFormatJSON, nil
+}
+
+// respondRatings writes ratings as format: body for JSON, the timeline for
+// CSV, otherwise one subtitle cue per rated segment with its rating and
+// notes as the text. A recorded timeline is added to the JSON body.
+func respondRatings(c *gin.Context, format string, ratings []RatingResult, timeline *analysisTimeline, body gin.H) {
+	if timeline != nil {
+		body["timeline"] = timeline.list()
+	}
+	switch format {
+	case ratingsFormatCSV:
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", timelineCSV(timeline.list()))
+	case ratingsFormatVTT:
+		c.Data(http.StatusOK, "text/vtt; charset=utf-8", []byte(ratingCues(ratings, true)))
+	case ratingsFormatSRT:
+		c.Data(http.StatusOK, "application/x-subrip; charset=utf-8", []byte(ratingCues(ratings, false)))
+	default:
+		c.JSON(http.StatusOK, body)
+	}
+}
+
+// ratingCues renders ratings as a WebVTT file when vtt is set, otherwise
+// as SRT.
+func ratingCues(ratings []RatingResult, vtt bool) string {
+	var b strings.Builder
+	if vtt {
+		b.WriteString("WEBVTT\n\n")
+	}
+	for i, rating := range ratings {
+		text := rating.Rating
+		if notes := strings.TrimSpace(rating.Notes); notes != "" {
+			// A blank line would end the cue early.
+			text += ": " + strings.Join(strings.Fields(notes), " ")
+		}
+		if vtt {
+			fmt.Fprintf(&b, "%s --> %s\n%s\n\n", formatVTTTime(rating.Start), formatVTTTime(rating.End), text)
+		} else {
+			fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, formatSRTTime(rating.Start), formatSRTTime(rating.End), text)
+		}
+	}
+	return b.String()
+}
+
+// formatSRTTime formats seconds as an SRT timestamp (HH:MM:SS,mmm).
+func formatSRTTime(seconds float64) string {
+	return strings.Replace(formatVTTTime(seconds), ".", ",", 1)
+}
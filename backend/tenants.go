@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tenantContextKey is the gin context key holding the request's tenant.
+const tenantContextKey = "tenant"
+
+var validTenantID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// tenantJobs counts each tenant's in-flight processing requests.
+var tenantJobs = struct {
+	mu     sync.Mutex
+	counts map[string]int
+}{counts: make(map[string]int)}
+
+// tenantScope reads the tenant from the TENANT_HEADER request header and
+// scopes the request's files to that tenant's subfolders. Requests without
+// the header use the shared folders unless TENANT_REQUIRED is set. For
+// processing routes (jobs set), the tenant's disk quota and concurrent job
+// limit are enforced with 507 and 429.
+func tenantScope(jobs bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant := c.GetHeader(config.TenantHeader)
+		if tenant == "" {
+			if config.TenantRequired {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s header is required", config.TenantHeader)})
+				return
+			}
+			c.Next()
+			return
+		}
+		if !validTenantID.MatchString(tenant) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+			return
+		}
+		c.Set(tenantContextKey, tenant)
+
+		if !jobs {
+			c.Next()
+			return
+		}
+
+		for _, dir := range []string{tenantUploadDir(c), tenantProcessedDir(c)} {
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare tenant folders"})
+				return
+			}
+		}
+
+		if config.TenantDiskQuota > 0 && tenantDiskUsage(tenant) >= config.TenantDiskQuota {
+			c.AbortWithStatusJSON(http.StatusInsufficientStorage, gin.H{"error": "Tenant disk quota exceeded", "code": "QUOTA_EXCEEDED"})
+			return
+		}
+
+		if !acquireTenantJob(tenant) {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many concurrent jobs for tenant", "code": "TOO_MANY_JOBS"})
+			return
+		}
+		defer func() {
+			if !c.GetBool(tenantJobHeldKey) {
+				releaseTenantJob(tenant)
+			}
+		}()
+
+		c.Next()
+	}
+}
+
+// tenantFromQuery lets clients that cannot set headers, such as browser
+// WebSockets, send the tenant as the "tenant" query parameter instead of
+// the TENANT_HEADER header.
+func tenantFromQuery(c *gin.Context) {
+	if tenant := c.Query("tenant"); tenant != "" && c.GetHeader(config.TenantHeader) == "" {
+		c.Request.Header.Set(config.TenantHeader, tenant)
+	}
+}
+
+// requestTenant returns the request's tenant, or "" for the shared folders.
+func requestTenant(c *gin.Context) string {
+	return c.GetString(tenantContextKey)
+}
+
+// tenantUploadDir is where the request's uploads are saved.
+func tenantUploadDir(c *gin.Context) string {
+	return filepath.Join(uploadFolder, requestTenant(c))
+}
+
+// tenantProcessedDir is where the request's outputs are written and looked
+// up by /download.
+func tenantProcessedDir(c *gin.Context) string {
+	return filepath.Join(processedFolder, requestTenant(c))
+}
+
+// tenantJobHeldKey marks a request whose tenant job slot was handed over
+// to a background job; see holdTenantJob.
+const tenantJobHeldKey = "tenant_job_held"
+
+// holdTenantJob keeps the request's tenant job slot taken once the handler
+// returns, for a background job to release with the returned function.
+func holdTenantJob(c *gin.Context) func() {
+	tenant := requestTenant(c)
+	if tenant == "" {
+		return func() {}
+	}
+	c.Set(tenantJobHeldKey, true)
+	return func() { releaseTenantJob(tenant) }
+}
+
+func acquireTenantJob(tenant string) bool {
+	tenantJobs.mu.Lock()
+	defer tenantJobs.mu.Unlock()
+
+	if config.TenantMaxJobs > 0 && tenantJobs.counts[tenant] >= config.TenantMaxJobs {
+		return false
+	}
+	tenantJobs.counts[tenant]++
+	return true
+}
+
+func releaseTenantJob(tenant string) {
+	tenantJobs.mu.Lock()
+	defer tenantJobs.mu.Unlock()
+
+	tenantJobs.counts[tenant]--
+	if tenantJobs.counts[tenant] <= 0 {
+		delete(tenantJobs.counts, tenant)
+	}
+}
+
+// tenantDiskUsage sums the size of the tenant's uploads and outputs.
+func tenantDiskUsage(tenant string) int64 {
+	var total int64
+	for _, dir := range []string{filepath.Join(uploadFolder, tenant), filepath.Join(processedFolder, tenant)} {
+		filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return nil
+			}
+			if info, err := entry.Info(); err == nil {
+				total += info.Size()
+			}
+			return nil
+		})
+	}
+	return total
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+func init() {
+	mime.AddExtensionType(".vtt", "text/vtt")
+}
+
+// thumbnailSheet collects periodic thumbnails of the output and writes them
+// as a sprite sheet plus a WebVTT index for scrub-bar previews.
+type thumbnailSheet struct {
+	interval float64
+	width    int
+	height   int
+	next     float64
+	thumbs   []gocv.Mat
+}
+
+func newThumbnailSheet(frameWidth, frameHeight int) *thumbnailSheet {
+	width := config.ThumbnailWidth
+	height := width * frameHeight / frameWidth
+	if height <= 0 {
+		height = width * 9 / 16
+	}
+
+	return &thumbnailSheet{
+		interval: config.ThumbnailInterval,
+		width:    width,
+		height:   height,
+	}
+}
+
+// add keeps a copy of img when timestamp (in output time) has reached the
+// next thumbnail slot.
+func (t *thumbnailSheet) add(timestamp float64, img gocv.Mat) {
+	if timestamp < t.next {
+		return
+	}
+
+	thumb := gocv.NewMat()
+	gocv.Resize(img, &thumb, image.Point{X: t.width, Y: t.height}, 0, 0, config.ResizeInterpolation)
+	t.thumbs = append(t.thumbs, thumb)
+	t.next = float64(len(t.thumbs)) * t.interval
+}
+
+// addFromFile fills the sheet by decoding an already-written output, used
+// when the output was produced in chunks.
+func (t *thumbnailSheet) addFromFile(path string) error {
+	video, err := gocv.VideoCaptureFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to open output: %v", err)
+	}
+	defer video.Close()
+
+	fps := video.Get(gocv.VideoCaptureFPS)
+	if fps <= 0 {
+		fps = 30 // Default to 30fps if unable to determine
+	}
+
+	img := gocv.NewMat()
+	defer img.Close()
+
+	for frameIndex := 0; video.Read(&img) && !img.Empty(); frameIndex++ {
+		t.add(float64(frameIndex)/fps, img)
+	}
+	return nil
+}
+
+// thumbnailSpritePath returns the sprite sheet the index at vttPath
+// refers to.
+func thumbnailSpritePath(vttPath string) string {
+	return strings.TrimSuffix(vttPath, ".vtt") + ".jpg"
+}
+
+// write saves the sprite sheet and WebVTT index next to outputPath and
+// returns the path of the .vtt file.
+func (t *thumbnailSheet) write(outputPath string) (string, error) {
+	defer t.Close()
+	if len(t.thumbs) == 0 {
+		return "", fmt.Errorf("no thumbnails captured")
+	}
+
+	base := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
+	vttPath := base + "_thumbs.vtt"
+	spritePath := thumbnailSpritePath(vttPath)
+
+	columns := config.ThumbnailColumns
+	if columns > len(t.thumbs) {
+		columns = len(t.thumbs)
+	}
+	rows := (len(t.thumbs) + columns - 1) / columns
+
+	sprite := gocv.Zeros(rows*t.height, columns*t.width, gocv.MatTypeCV8UC3)
+	defer sprite.Close()
+
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n\n")
+	for i, thumb := range t.thumbs {
+		x := (i % columns) * t.width
+		y := (i / columns) * t.height
+
+		roi := sprite.Region(image.Rect(x, y, x+t.width, y+t.height))
+		thumb.CopyTo(&roi)
+		roi.Close()
+
+		start := float64(i) * t.interval
+		fmt.Fprintf(&vtt, "%s --> %s\n%s#xywh=%d,%d,%d,%d\n\n",
+			formatVTTTime(start), formatVTTTime(start+t.interval),
+			filepath.Base(spritePath), x, y, t.width, t.height)
+	}
+
+	if ok := gocv.IMWrite(spritePath, sprite); !ok {
+		return "", fmt.Errorf("failed to write thumbnail sprite")
+	}
+	if err := os.WriteFile(vttPath, []byte(vtt.String()), 0644); err != nil {
+		os.Remove(spritePath)
+		return "", fmt.Errorf("failed to write thumbnail index: %v", err)
+	}
+
+	processedFiles.record(processedKey(spritePath))
+	processedFiles.record(processedKey(vttPath))
+	return vttPath, nil
+}
+
+func (t *thumbnailSheet) Close() {
+	for _, thumb := range t.thumbs {
+		thumb.Close()
+	}
+	t.thumbs = nil
+}
+
+// formatVTTTime formats seconds as a WebVTT timestamp (HH:MM:SS.mmm).
+func formatVTTTime(seconds float64) string {
+	millis := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d",
+		millis/3600000, millis/60000%60, millis/1000%60, millis%1000)
+}
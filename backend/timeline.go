@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"strings"
+)
+
+// TimelineEntry is the combined rating of one analyzed second, before
+// consecutive seconds are merged into segments.
+type TimelineEntry struct {
+	Timestamp  float64  `json:"timestamp"`
+	Rating     string   `json:"rating"`
+	Notes      string   `json:"notes"`
+	Confidence *float64 `json:"confidence,omitempty"`
+}
+
+// SampleDetail is the rating of one analyzed frame of a segment, before
+// the frames of each second are voted on and seconds are merged.
+type SampleDetail struct {
+	Timestamp  float64  `json:"timestamp"`
+	Rating     string   `json:"rating"`
+	Notes      string   `json:"notes,omitempty"`
+	Confidence *float64 `json:"confidence,omitempty"`
+}
+
+func newSampleDetail(timestamp float64, sample frameSample) SampleDetail {
+	return SampleDetail{
+		Timestamp:  timestamp,
+		Rating:     sample.Rating,
+		Notes:      strings.TrimSpace(sample.Notes),
+		Confidence: sample.Confidence,
+	}
+}
+
+// analysisTimeline collects the per-second ratings of an analysis. A nil
+// *analysisTimeline ignores them.
+type analysisTimeline struct {
+	entries []TimelineEntry
+}
+
+func (t *analysisTimeline) record(timestamp float64, sample frameSample) {
+	if t != nil {
+		t.entries = append(t.entries, TimelineEntry{
+			Timestamp:  timestamp,
+			Rating:     sample.Rating,
+			Notes:      strings.TrimSpace(sample.Notes),
+			Confidence: sample.Confidence,
+		})
+	}
+}
+
+// list returns the recorded entries, never nil so it encodes as a JSON
+// array.
+func (t *analysisTimeline) list() []TimelineEntry {
+	if t == nil || t.entries == nil {
+		return []TimelineEntry{}
+	}
+	return t.entries
+}
+
+// timelineCSV renders entries as CSV, one row per second.
+func timelineCSV(entries []TimelineEntry) []byte {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write([]string{"timestamp", "rating", "notes", "confidence"})
+	for _, entry := range entries {
+		confidence := ""
+		if entry.Confidence != nil {
+			confidence = strconv.FormatFloat(*entry.Confidence, 'f', 2, 64)
+		}
+		w.Write([]string{
+			strconv.FormatFloat(entry.Timestamp, 'f', 2, 64),
+			entry.Rating,
+			entry.Notes,
+			confidence,
+		})
+	}
+	w.Flush()
+	return b.Bytes()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// TranscriptWord is one word of a time-coded transcript, as produced by
+// most speech recognition systems with word-level timings.
+type TranscriptWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// parseTranscript decodes a JSON array of TranscriptWord.
+func parseTranscript(raw string) ([]TranscriptWord, error) {
+	var words []TranscriptWord
+	if err := json.Unmarshal([]byte(raw), &words); err != nil {
+		return nil, fmt.Errorf("invalid transcript: %v", err)
+	}
+	for i, w := range words {
+		if w.Start < 0 || w.End < w.Start {
+			return nil, fmt.Errorf("invalid transcript: word %d has an invalid time range", i)
+		}
+	}
+	return words, nil
+}
+
+// normalizeWord lowercases word and strips surrounding punctuation so
+// "Damn," matches a blocklist entry of "damn".
+func normalizeWord(word string) string {
+	return strings.ToLower(strings.TrimFunc(word, func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSpace(r)
+	}))
+}
+
+// transcriptSegments returns a segment rated rating for every word of
+// words on blocklist, widened by padding seconds on each side. Overlapping
+// segments are merged and their notes list the words found.
+func transcriptSegments(words []TranscriptWord, blocklist []string, rating string, padding float64) []RatingResult {
+	var segments []RatingResult
+	for _, s := range profanitySegments(words, blocklist, padding) {
+		segments = append(segments, RatingResult{Start: s.Start, End: s.End, Rating: rating, Notes: "Transcript: " + strings.Join(s.Words, ", ")})
+	}
+	return segments
+}
+
+// overlayRatings returns ratings with each of segments in place of the
+// time it covers. Existing segments are cut around them, so the first
+// segment covering any moment is the one that applies, as trim expects.
+func overlayRatings(ratings, segments []RatingResult) []RatingResult {
+	if len(segments) == 0 {
+		return ratings
+	}
+
+	var result []RatingResult
+	for _, r := range ratings {
+		pieces := []RatingResult{r}
+		for _, s := range segments {
+			var next []RatingResult
+			for _, p := range pieces {
+				if s.End < p.Start || s.Start > p.End {
+					next = append(next, p)
+					continue
+				}
+				if p.Start < s.Start {
+					before := p
+					before.End = s.Start
+					next = append(next, before)
+				}
+				if p.End > s.End {
+					after := p
+					after.Start = s.End
+					next = append(next, after)
+				}
+			}
+			pieces = next
+		}
+		result = append(result, pieces...)
+	}
+	return sortedRatings(append(result, segments...))
+}
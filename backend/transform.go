@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"math"
+
+	"github.com/gin-gonic/gin"
+	"gocv.io/x/gocv"
+)
+
+// FrameTransform is a geometric correction, such as the output of an
+// upstream stabilization step, applied to every frame before analysis and
+// encoding. Frames are rotated, then cropped, then scaled. A nil
+// *FrameTransform leaves frames unchanged.
+type FrameTransform struct {
+	// Rotation is clockwise, in degrees. Multiples of 90 turn the whole
+	// frame; other angles rotate about the center within the same frame
+	// size, leaving the corners black.
+	Rotation float64 `json:"rotation"`
+	// Crop is in pixels of the rotated frame and is clamped to it.
+	Crop *CropRect `json:"crop"`
+	// Scale resizes the cropped frame; 0 keeps its size.
+	Scale float64 `json:"scale"`
+}
+
+// CropRect is a crop rectangle in pixels.
+type CropRect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// parseSidecar reads the optional sidecar JSON describing a FrameTransform,
+// sent either as a "sidecar" file or as a form value. It returns nil when
+// there is none.
+func parseSidecar(c *gin.Context) (*FrameTransform, error) {
+	raw := []byte(c.PostForm("sidecar"))
+	if file, err := c.FormFile("sidecar"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sidecar: %v", err)
+		}
+		raw, err = io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sidecar: %v", err)
+		}
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var transform FrameTransform
+	if err := json.Unmarshal(raw, &transform); err != nil {
+		return nil, fmt.Errorf("invalid sidecar: %v", err)
+	}
+	if crop := transform.Crop; crop != nil && (crop.X < 0 || crop.Y < 0 || crop.Width <= 0 || crop.Height <= 0) {
+		return nil, fmt.Errorf("invalid sidecar: crop must have a non-negative origin and a positive size")
+	}
+	if transform.Scale < 0 {
+		return nil, fmt.Errorf("invalid sidecar: scale must not be negative")
+	}
+	if transform.Rotation == 0 && transform.Crop == nil && (transform.Scale == 0 || transform.Scale == 1) {
+		return nil, nil
+	}
+	return &transform, nil
+}
+
+// rotation returns Rotation normalized to [0, 360).
+func (t *FrameTransform) rotation() float64 {
+	r := math.Mod(t.Rotation, 360)
+	if r < 0 {
+		r += 360
+	}
+	return r
+}
+
+// cropRect returns the crop clamped to a width x height rotated frame.
+func (t *FrameTransform) cropRect(width, height int) image.Rectangle {
+	frame := image.Rect(0, 0, width, height)
+	if t.Crop == nil {
+		return frame
+	}
+	return image.Rect(t.Crop.X, t.Crop.Y, t.Crop.X+t.Crop.Width, t.Crop.Y+t.Crop.Height).Intersect(frame)
+}
+
+// scaled returns the size a cropped width x height frame is scaled to.
+func (t *FrameTransform) scaled(width, height int) image.Point {
+	if t.Scale == 0 || t.Scale == 1 {
+		return image.Point{X: width, Y: height}
+	}
+	return image.Point{
+		X: max(1, int(math.Round(float64(width)*t.Scale))),
+		Y: max(1, int(math.Round(float64(height)*t.Scale))),
+	}
+}
+
+// size returns the size of a transformed width x height frame, or an error
+// if the crop lies outside the frame.
+func (t *FrameTransform) size(width, height int) (int, int, error) {
+	if t == nil {
+		return width, height, nil
+	}
+	if r := t.rotation(); r == 90 || r == 270 {
+		width, height = height, width
+	}
+	crop := t.cropRect(width, height)
+	if crop.Empty() {
+		return 0, 0, fmt.Errorf("sidecar crop lies outside the %dx%d frame", width, height)
+	}
+	size := t.scaled(crop.Dx(), crop.Dy())
+	return size.X, size.Y, nil
+}
+
+// apply transforms img in place.
+func (t *FrameTransform) apply(img *gocv.Mat) {
+	if t == nil {
+		return
+	}
+
+	switch r := t.rotation(); r {
+	case 0:
+	case 90:
+		gocv.Rotate(*img, img, gocv.Rotate90Clockwise)
+	case 180:
+		gocv.Rotate(*img, img, gocv.Rotate180Clockwise)
+	case 270:
+		gocv.Rotate(*img, img, gocv.Rotate90CounterClockwise)
+	default:
+		// OpenCV angles are counter-clockwise.
+		center := image.Point{X: img.Cols() / 2, Y: img.Rows() / 2}
+		matrix := gocv.GetRotationMatrix2D(center, -r, 1)
+		gocv.WarpAffine(*img, img, matrix, image.Point{X: img.Cols(), Y: img.Rows()})
+		matrix.Close()
+	}
+
+	crop := t.cropRect(img.Cols(), img.Rows())
+	if !crop.Empty() && crop != image.Rect(0, 0, img.Cols(), img.Rows()) {
+		roi := img.Region(crop)
+		roi.CopyTo(img)
+		roi.Close()
+	}
+
+	if size := t.scaled(img.Cols(), img.Rows()); size.X != img.Cols() || size.Y != img.Rows() {
+		gocv.Resize(*img, img, size, 0, 0, gocv.InterpolationLinear)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gocv.io/x/gocv"
+)
+
+// TriageResult is the pass/fail outcome of triageVideo.
+type TriageResult struct {
+	Clean          bool    `json:"clean"`
+	SamplesChecked int     `json:"samples_checked"`
+	Timestamp      float64 `json:"timestamp,omitempty"`
+	Rating         string  `json:"rating,omitempty"`
+	Notes          string  `json:"notes,omitempty"`
+}
+
+// triageVideo rates up to samples evenly spaced frames in time order and
+// stops at the first one rated above age. It reports clean when none of
+// the sampled frames is, which is only as thorough as the sampling budget.
+func triageVideo(ctx context.Context, videoPath string, age, samples int, sceneContext string) (*TriageResult, error) {
+	release, err := analysisLimit.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	video, err := gocv.VideoCaptureFile(videoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open video: %v", err)
+	}
+	defer video.Close()
+
+	fps := video.Get(gocv.VideoCaptureFPS)
+	if fps <= 0 {
+		fps = 30 // Default to 30fps if unable to determine
+	}
+	totalFrames := int(video.Get(gocv.VideoCaptureFrameCount))
+	if totalFrames <= 0 {
+		return nil, fmt.Errorf("unable to determine frame count")
+	}
+	if samples > totalFrames {
+		samples = totalFrames
+	}
+
+	img := gocv.NewMat()
+	defer img.Close()
+
+	result := &TriageResult{Clean: true}
+	for i := 0; i < samples; i++ {
+		if err := checkContext(ctx); err != nil {
+			return nil, err
+		}
+
+		frameIndex := i * totalFrames / samples
+		video.Set(gocv.VideoCapturePosFrames, float64(frameIndex))
+		if ok := video.Read(&img); !ok || img.Empty() {
+			continue
+		}
+		result.SamplesChecked++
+
+		if config.SkipBlankFrames && isBlankFrame(img) {
+			continue
+		}
+
+		dataURL, err := encodeSample(img, config.ResizeInterpolation, config.AnalysisResolution)
+		if err != nil {
+			continue
+		}
+		data, err := analyzeFrame(ctx, img, dataURL, sceneContext, float64(frameIndex)/fps)
+		if ctx.Err() != nil {
+			return nil, checkContext(ctx)
+		}
+		if err != nil && config.FallbackPolicy != fallbackFail {
+			data, err = fallbackRating(img, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		rating := applyNoteRatingFloors(data.Rating, data.Notes)
+		if getRatingValue(rating) > age {
+			timestamp := float64(frameIndex) / fps
+			log.Printf("Triage found %s content at %.2fs after %d samples", rating, timestamp, result.SamplesChecked)
+			result.Clean = false
+			result.Timestamp = timestamp
+			result.Rating = rating
+			result.Notes = data.Notes
+			return result, nil
+		}
+	}
+
+	return result, nil
+}
+
+// triageUpload handles POST /triage: a quick pass/fail check of whether a
+// video has anything rated above age, without a full timeline.
+func triageUpload(c *gin.Context) {
+	file, err := c.FormFile("video")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No video file provided"})
+		return
+	}
+	if err := checkUploadType(file); err != nil {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error(), "code": "UNSUPPORTED_MEDIA_TYPE"})
+		return
+	}
+
+	age, err := parseAge(c.PostForm("age"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	samples := config.TriageSamples
+	if raw := c.PostForm("samples"); raw != "" {
+		samples, err = strconv.Atoi(raw)
+		if err != nil || samples <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid samples: %s", raw)})
+			return
+		}
+	}
+
+	sceneContext, err := parseSceneContext(c.PostForm("scene_context"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := uploadPath(c, file.Filename)
+	if err := c.SaveUploadedFile(file, filename); err != nil {
+		respondSaveError(c, filename, err)
+		return
+	}
+	defer os.Remove(filename)
+
+	if err := checkReadableVideo(filename); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "INVALID_VIDEO"})
+		return
+	}
+
+	ctx, cancel, err := processingContext(c, c.PostForm("timeout"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer cancel()
+
+	result, err := triageVideo(ctx, filename, age, samples, sceneContext)
+	if err != nil {
+		respondProcessingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+	"gocv.io/x/gocv"
+)
+
+// uploadPath returns where to save the request's upload of filename. The
+// client's name is reduced to a safe base name, without directories,
+// control characters or leading dots and dashes, and prefixed with the
+// time so concurrent uploads of the same name do not overwrite each other.
+func uploadPath(c *gin.Context, filename string) string {
+	name := strings.TrimLeft(unsafeKeyChars.ReplaceAllString(filepath.Base(filename), "_"), "._-")
+	if name == "" {
+		name = "upload"
+	}
+	// Long names are cut from the front to keep the extension.
+	if len(name) > 100 {
+		name = name[len(name)-100:]
+	}
+	return filepath.Join(tenantUploadDir(c), fmt.Sprintf("%d_%s", time.Now().UnixNano(), name))
+}
+
+// checkReadableVideo confirms gocv can open videoPath and decode at least
+// one frame, so empty or truncated uploads fail with a clear error instead
+// of yielding empty results.
+func checkReadableVideo(videoPath string) error {
+	video, err := gocv.VideoCaptureFile(videoPath)
+	if err != nil || !video.IsOpened() {
+		if video != nil {
+			video.Close()
+		}
+		return fmt.Errorf("file could not be opened as a video")
+	}
+	defer video.Close()
+
+	img := gocv.NewMat()
+	defer img.Close()
+
+	if ok := video.Read(&img); !ok || img.Empty() {
+		return fmt.Errorf("video contains no readable frames")
+	}
+	return nil
+}
+
+// checkUploadType applies the video type allowlist to a multipart upload.
+func checkUploadType(file *multipart.FileHeader) error {
+	mtype, err := sniffUpload(file)
+	if err != nil {
+		return err
+	}
+	return checkAllowedType(file.Filename, mtype)
+}
+
+// sniffUpload detects the content type of a multipart upload.
+func sniffUpload(file *multipart.FileHeader) (*mimetype.MIME, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %v", err)
+	}
+	defer f.Close()
+
+	mtype, err := mimetype.DetectReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect file type: %v", err)
+	}
+	return mtype, nil
+}
+
+// checkAnalysisUploadType is checkUploadType for /upload, which also
+// takes images on the image allowlist. It returns the kind of media
+// accepted, or errAudioOnly for audio files.
+func checkAnalysisUploadType(file *multipart.FileHeader) (string, error) {
+	mtype, err := sniffUpload(file)
+	if err != nil {
+		return "", err
+	}
+
+	err = checkAllowedType(file.Filename, mtype)
+	if err == nil {
+		return mediaVideo, nil
+	}
+	if strings.HasPrefix(mtype.String(), "audio/") {
+		return "", errAudioOnly
+	}
+
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(file.Filename)), ".")
+	if contains(config.AllowedImageExtensions, ext) {
+		for _, allowed := range config.AllowedImageMIMETypes {
+			if mtype.Is(allowed) {
+				return mediaImage, nil
+			}
+		}
+	}
+	return "", err
+}
+
+// checkFileType applies the video type allowlist to a file on disk.
+func checkFileType(name, path string) error {
+	mtype, err := mimetype.DetectFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to detect file type: %v", err)
+	}
+	return checkAllowedType(name, mtype)
+}
+
+// checkAllowedType is the single place deciding whether a file is an
+// accepted video: both its extension and its sniffed MIME type must be on
+// the configured allowlists.
+func checkAllowedType(name string, mtype *mimetype.MIME) error {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+	if !contains(config.AllowedVideoExtensions, ext) {
+		return &unsupportedTypeError{fmt.Sprintf("file extension %q is not allowed, expected one of: %s",
+			ext, strings.Join(config.AllowedVideoExtensions, ", "))}
+	}
+
+	for _, allowed := range config.AllowedVideoMIMETypes {
+		if mtype.Is(allowed) {
+			return nil
+		}
+	}
+	return &unsupportedTypeError{fmt.Sprintf("file content type %s is not an allowed video type", mtype.String())}
+}
+
+// unsupportedTypeError reports a file rejected by the video type allowlist,
+// which handlers answer with 415.
+type unsupportedTypeError struct {
+	reason string
+}
+
+func (e *unsupportedTypeError) Error() string {
+	return e.reason
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// VariantResult is one output of processVideoVariants.
+type VariantResult struct {
+	VideoType string
+	*ConvertResult
+}
+
+// processVideoVariants renders one output per entry of videoTypes (blur or
+// trim) from a single decode of videoPath, so comparing censor styles does
+// not pay for decoding the source once per style. Outputs are always
+// encoded in one pass, whatever ENCODE_CHUNKS is set to.
+func processVideoVariants(ctx context.Context, videoPath string, age int, ratings []RatingResult, videoTypes []string, opts ConvertOptions) ([]VariantResult, error) {
+	release, err := encodeLimit.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if opts.Progress == nil {
+		opts.Progress = contextProgress(ctx)
+	}
+
+	video, err := gocv.VideoCaptureFile(videoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open video: %v", err)
+	}
+	defer video.Close()
+
+	fps := video.Get(gocv.VideoCaptureFPS)
+	if fps <= 0 {
+		fps = 30 // Default to 30fps if unable to determine
+	}
+	width, height, err := opts.Transform.size(int(video.Get(gocv.VideoCaptureFrameWidth)), int(video.Get(gocv.VideoCaptureFrameHeight)))
+	if err != nil {
+		return nil, err
+	}
+	totalFrames := int(video.Get(gocv.VideoCaptureFrameCount))
+	opts.Progress.setTotal(totalFrames, fps)
+	ratings = clampEdgeSegments(ratings, fps, totalFrames)
+
+	type variant struct {
+		videoType  string
+		outputPath string
+		writerPath string
+		step       int
+		writer     *outputWriter
+		thumbnails *thumbnailSheet
+	}
+
+	suffixes := make([]string, len(videoTypes))
+	for i, videoType := range videoTypes {
+		suffixes[i] = "_" + videoType + ".mp4"
+	}
+	outputPaths, release := claimOutputPaths(ctx, opts, suffixes...)
+	defer release()
+
+	exporter, err := newFrameExporter(opts.ExportFrames, opts.outputDir())
+	if err != nil {
+		return nil, err
+	}
+	defer exporter.Close()
+	opts.FlaggedFrames = exporter
+
+	variants := make([]*variant, 0, len(videoTypes))
+	defer func() {
+		for _, v := range variants {
+			if v.thumbnails != nil {
+				v.thumbnails.Close()
+			}
+		}
+	}()
+	// cleanup removes the partial outputs of a failed render. Writers are
+	// safe to close twice, and finalized writer files are already gone.
+	cleanup := func() {
+		for _, v := range variants {
+			v.writer.Close()
+			os.Remove(v.writerPath)
+			os.Remove(v.outputPath)
+		}
+	}
+
+	for i, videoType := range videoTypes {
+		outputPath := outputPaths[i]
+		writerPath := strings.TrimSuffix(outputPath, ".mp4") + "_video.mp4"
+		writer, err := newOutputWriter(writerPath, fps/float64(opts.frameStep(videoType, fps)), width, height)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+
+		v := &variant{videoType: videoType, outputPath: outputPath, writerPath: writerPath, step: opts.frameStep(videoType, fps), writer: writer}
+		if opts.Thumbnails {
+			v.thumbnails = newThumbnailSheet(width, height)
+			writer.thumbnails = v.thumbnails
+		}
+		variants = append(variants, v)
+	}
+
+	img := gocv.NewMat()
+	defer img.Close()
+	blurred := gocv.NewMat()
+	defer blurred.Close()
+	motion := opts.motionMasker()
+	defer motion.Close()
+	placeholder := opts.failSafeFrame()
+	defer placeholder.Close()
+
+	trimRatings := sortedRatings(ratings)
+	for frameIndex := 0; ; frameIndex++ {
+		if err := checkContext(ctx); err != nil {
+			cleanup()
+			return nil, err
+		}
+		lost, ok := readFrame(video, &img, frameIndex, totalFrames)
+		if !ok {
+			break
+		}
+		frameIndex += lost
+		opts.Transform.apply(&img)
+		opts.FlaggedFrames.add(img, ratings, age, float64(frameIndex)/fps)
+
+		timestamp := float64(frameIndex) / fps
+		for _, v := range variants {
+			if v.videoType == "blur" {
+				// Lost frames are filled with this one to keep the timing.
+				frame := blurFrame(img, &blurred, ratings, age, opts.Projection, motion, placeholder, opts.Debug, timestamp)
+				for i := 0; i <= lost; i++ {
+					if (frameIndex-lost+i)%v.step == 0 {
+						v.writer.Write(frame)
+					}
+				}
+			} else if keep, _ := trimKeepsFrame(trimRatings, age, timestamp); keep && frameIndex%v.step == 0 &&
+				!(opts.FailSafe && uncertainAt(trimRatings, timestamp, config.FailSafeConfidence)) {
+				v.writer.Write(img)
+			}
+		}
+		for i := 0; i <= lost; i++ {
+			opts.Progress.advance()
+		}
+	}
+
+	var results []VariantResult
+	for _, v := range variants {
+		v.writer.Close()
+		result, err := completeOutput(ctx, videoPath, v.writerPath, v.outputPath, ratings, age, v.videoType, opts, v.thumbnails, v.writer.frames, fps, totalFrames)
+		if err != nil {
+			// Outputs already finished go too, with their by-products.
+			for _, finished := range results {
+				finished.remove()
+			}
+			cleanup()
+			return nil, fmt.Errorf("failed to finish %s output: %v", v.videoType, err)
+		}
+		results = append(results, VariantResult{VideoType: v.videoType, ConvertResult: result})
+	}
+
+	return results, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const warmupFrameSize = 64
+
+// warmup initializes gocv's codecs and loads any configured DNN models so the
+// first real request doesn't pay for lazy initialization. A missing or
+// unloadable model is reported as an error so startup fails early.
+func warmup() error {
+	start := time.Now()
+
+	frame := gocv.NewMatWithSize(warmupFrameSize, warmupFrameSize, gocv.MatTypeCV8UC3)
+	defer frame.Close()
+
+	buf, err := gocv.IMEncode(".jpg", frame)
+	if err != nil {
+		return fmt.Errorf("failed to encode warmup frame: %v", err)
+	}
+	buf.Close()
+
+	if err := warmupCapture(frame); err != nil {
+		return err
+	}
+	log.Printf("Warmup: codecs ready in %v", time.Since(start))
+
+	for _, modelPath := range config.WarmupModels {
+		modelStart := time.Now()
+		if err := warmupModel(modelPath); err != nil {
+			return err
+		}
+		log.Printf("Warmup: loaded model %s in %v", modelPath, time.Since(modelStart))
+	}
+
+	log.Printf("Warmup complete in %v", time.Since(start))
+	return nil
+}
+
+// warmupCapture writes a tiny clip and reads it back, exercising both the
+// VideoWriter and VideoCapture paths used by the handlers. The clip is
+// written with the first of VIDEO_CODECS that works, as outputs are.
+func warmupCapture(frame gocv.Mat) error {
+	tempFile, err := os.CreateTemp("", "censorai_warmup_*.mp4")
+	if err != nil {
+		return fmt.Errorf("failed to create warmup file: %v", err)
+	}
+	tempPath := tempFile.Name()
+	tempFile.Close()
+	defer os.Remove(tempPath)
+
+	writer, err := newOutputWriter(tempPath, 1, warmupFrameSize, warmupFrameSize)
+	if err != nil {
+		return fmt.Errorf("failed to create warmup writer: %v", err)
+	}
+	err = writer.Write(frame)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write warmup clip: %v", err)
+	}
+
+	capture, err := gocv.VideoCaptureFile(tempPath)
+	if err != nil {
+		return fmt.Errorf("failed to open warmup capture %s: %v", filepath.Base(tempPath), err)
+	}
+	defer capture.Close()
+
+	img := gocv.NewMat()
+	defer img.Close()
+	if !capture.Read(&img) || img.Empty() {
+		return fmt.Errorf("failed to read back warmup clip %s", filepath.Base(tempPath))
+	}
+
+	return nil
+}
+
+func warmupModel(modelPath string) error {
+	if _, err := os.Stat(modelPath); err != nil {
+		return fmt.Errorf("required model file %s is missing: %v", modelPath, err)
+	}
+
+	net := gocv.ReadNet(modelPath, "")
+	defer net.Close()
+	if net.Empty() {
+		return fmt.Errorf("failed to load model %s", modelPath)
+	}
+
+	return nil
+}
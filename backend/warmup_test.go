@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+func TestWarmupCaptureWithoutCodec(t *testing.T) {
+	defer func(codecs []string) { config.VideoCodecs = codecs }(config.VideoCodecs)
+	// No OpenCV build encodes this fourcc, as on builds without mp4v.
+	config.VideoCodecs = []string{"zzzz"}
+
+	frame := gocv.NewMatWithSize(warmupFrameSize, warmupFrameSize, gocv.MatTypeCV8UC3)
+	defer frame.Close()
+
+	err := warmupCapture(frame)
+	if err == nil {
+		t.Fatal("warmupCapture succeeded without a usable codec")
+	}
+	if !strings.Contains(err.Error(), "none of the codecs zzzz are supported") {
+		t.Errorf("unclear error: %v", err)
+	}
+}